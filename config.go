@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/screenshot-sorter/pkg/config"
+	"github.com/screenshot-sorter/pkg/fileutils"
+)
+
+// envPrefix starts the environment variables that override settings, e.g.
+// SCREENSHOT_SORTER_RATE for -rate and SCREENSHOT_SORTER_ON_CONFLICT for
+// -on-conflict
+const envPrefix = "SCREENSHOT_SORTER_"
+
+// commandSettings are settings only some commands take. A configuration
+// file may hold them for those commands; the others ignore them.
+var commandSettings = map[string]bool{"out": true, "on-drift": true, "pidfile": true}
+
+// pathSettings are the settings whose values in a configuration file may
+// start with ~ for the home directory
+var pathSettings = map[string]bool{"source": true, "target": true, "photo-target": true, "out": true, "pidfile": true}
+
+// Where a setting came from, apart from the profile and the environment
+const (
+	fromDefault = "default"
+	fromFlag    = "flag"
+	fromFile    = "config file"
+)
+
+// envName returns the environment variable that overrides the flag name
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// loadSettings adds -config and -profile to flags and parses args. The
+// flags args leave out are then taken from the environment, from the
+// profile chosen with -profile, and from the top of the configuration
+// file, in that order. It returns where the value of each flag came from.
+func loadSettings(flags *flag.FlagSet, args []string) (map[string]string, error) {
+	configPath := flags.String("config", "", "Configuration file (default: screenshot-sorter/config.toml in the user's config directory)")
+	profile := flags.String("profile", "", "Profile of the configuration file to use")
+	flags.Parse(args)
+
+	origins := make(map[string]string)
+	flags.VisitAll(func(f *flag.Flag) { origins[f.Name] = fromDefault })
+	flags.Visit(func(f *flag.Flag) { origins[f.Name] = fromFlag })
+	for _, name := range []string{"config", "profile"} {
+		if value := os.Getenv(envName(name)); value != "" && origins[name] == fromDefault {
+			flags.Set(name, value)
+			origins[name] = "$" + envName(name)
+		}
+	}
+
+	var file *config.File
+	switch {
+	case *configPath != "":
+		var err error
+		if file, err = config.Load(*configPath); err != nil {
+			return nil, err
+		}
+	default:
+		if path := config.Find(); path != "" {
+			var err error
+			if file, err = config.Load(path); err != nil {
+				return nil, err
+			}
+			*configPath = path
+			origins["config"] = "found"
+		}
+	}
+
+	var base, chosen map[string]config.Setting
+	if file != nil {
+		if err := checkSettings(file); err != nil {
+			return nil, err
+		}
+		base = file.Settings
+	}
+	if *profile != "" {
+		if file == nil {
+			return nil, fmt.Errorf("profile %s needs a configuration file, but none was found", *profile)
+		}
+		var ok bool
+		if chosen, ok = file.Profiles[*profile]; !ok {
+			return nil, fmt.Errorf("%s has no profile %s", file.Path, *profile)
+		}
+	}
+
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		// -version is not a setting; a configuration file cannot hold it
+		// either, see checkSettings
+		if err != nil || origins[f.Name] != fromDefault || f.Name == "config" || f.Name == "profile" || f.Name == "version" {
+			return
+		}
+		if value := os.Getenv(envName(f.Name)); value != "" {
+			if serr := flags.Set(f.Name, value); serr != nil {
+				err = fmt.Errorf("invalid value %q for $%s: %w", value, envName(f.Name), serr)
+			}
+			origins[f.Name] = "$" + envName(f.Name)
+			return
+		}
+		if s, ok := chosen[f.Name]; ok {
+			err = setFromFile(flags, f, s, file.Path)
+			origins[f.Name] = "profile " + *profile
+			return
+		}
+		if s, ok := base[f.Name]; ok {
+			err = setFromFile(flags, f, s, file.Path)
+			origins[f.Name] = fromFile
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return origins, nil
+}
+
+// checkSettings reports settings in file that no command takes
+func checkSettings(file *config.File) error {
+	known := flag.NewFlagSet("", flag.ContinueOnError)
+	sortingFlags(known)
+	check := func(settings map[string]config.Setting) error {
+		for key, s := range settings {
+			if key != "version" && (known.Lookup(key) != nil || commandSettings[key]) {
+				continue
+			}
+			return fmt.Errorf("%s: unknown setting %s", file.Path, s.Key)
+		}
+		return nil
+	}
+	if err := check(file.Settings); err != nil {
+		return err
+	}
+	for _, settings := range file.Profiles {
+		if err := check(settings); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setFromFile sets f to the value of a setting from the configuration
+// file at path. Arrays set repeatable flags once per element and other
+// list flags to the elements separated by commas.
+func setFromFile(flags *flag.FlagSet, f *flag.Flag, s config.Setting, path string) error {
+	values := s.Values
+	if pathSettings[f.Name] && !s.Array {
+		values = []string{expandHome(values[0])}
+	}
+	if s.Array {
+		switch f.Value.(type) {
+		case *stringList:
+		case *commaList:
+			values = []string{strings.Join(values, ",")}
+		default:
+			return fmt.Errorf("%s: %s takes a single value, not an array", path, s.Key)
+		}
+	}
+	for _, value := range values {
+		if err := flags.Set(f.Name, value); err != nil {
+			return fmt.Errorf("%s: invalid value %q for %s: %w", path, value, s.Key, err)
+		}
+	}
+	return nil
+}
+
+// expandHome replaces a leading ~ in path with the home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") && !strings.HasPrefix(path, "~"+string(filepath.Separator)) {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+// runConfig implements "screenshot-sorter config show [flags]", which
+// prints the settings a run with the same flags would use
+func runConfig(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "show" {
+		fmt.Fprintf(os.Stderr, "Usage: %s config show [flags]\n", filepath.Base(os.Args[0]))
+		return errors.New(`expected "config show"`)
+	}
+	flags := flag.NewFlagSet("config show", flag.ExitOnError)
+	settings := sortingFlags(flags)
+	origins, err := loadSettings(flags, args[1:])
+	if err != nil {
+		return err
+	}
+	if settings.TargetDir == "" {
+		flags.Set("target", settings.SourceDir)
+		origins["target"] = "default: the source"
+	}
+	if len(settings.TimeSources) == 0 {
+		flags.Set("time-sources", strings.Join(fileutils.DefaultTimeSources, ","))
+	}
+	return writeSettings(os.Stdout, flags, origins)
+}
+
+// writeSettings writes the value of each flag, and where it came from, in
+// the format of the configuration file
+func writeSettings(w io.Writer, flags *flag.FlagSet, origins map[string]string) error {
+	header := []string{"config", "profile"}
+	for _, name := range header {
+		value := flags.Lookup(name).Value.String()
+		if value == "" {
+			value = "none"
+		}
+		if _, err := fmt.Fprintf(w, "# %s: %s (%s)\n", name, value, origins[name]); err != nil {
+			return err
+		}
+	}
+
+	var lines [][2]string
+	width := 0
+	flags.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" || f.Name == "profile" || f.Name == "version" {
+			return
+		}
+		line := f.Name + " = " + settingValue(f)
+		lines = append(lines, [2]string{line, origins[f.Name]})
+		if len(line) > width {
+			width = len(line)
+		}
+	})
+	for _, line := range lines {
+		if _, err := fmt.Fprintf(w, "%-*s  # %s\n", width, line[0], line[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// settingValue formats the value of f as the configuration file would
+// hold it
+func settingValue(f *flag.Flag) string {
+	getter, ok := f.Value.(flag.Getter)
+	if !ok {
+		return strconv.Quote(f.Value.String())
+	}
+	switch value := getter.Get().(type) {
+	case bool, int, int64, uint, uint64, float64:
+		return f.Value.String()
+	case []string:
+		quoted := make([]string, len(value))
+		for i, v := range value {
+			quoted[i] = strconv.Quote(v)
+		}
+		return "[" + strings.Join(quoted, ", ") + "]"
+	default:
+		return strconv.Quote(f.Value.String())
+	}
+}
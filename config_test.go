@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes a configuration file and returns its path
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadSettings(t *testing.T) {
+	path := writeConfig(t, `
+mode = "copy"
+rate = 10
+workers = 2
+recursive = true
+
+[profile.phone]
+rate = 20
+workers = 3
+time-sources = ["exif", "mtime"]
+filename-pattern = ['^IMG_(?P<year>\d{4})', '^PXL_']
+`)
+	t.Setenv("SCREENSHOT_SORTER_WORKERS", "4")
+	t.Setenv("SCREENSHOT_SORTER_ON_CONFLICT", "skip")
+
+	flags := flag.NewFlagSet("cmd", flag.ContinueOnError)
+	config, err := parseFlags(flags, []string{"-config", path, "-profile", "phone", "-on-conflict", "counter"})
+	if err != nil {
+		t.Fatalf("parseFlags() error = %v", err)
+	}
+	// Flags beat the environment, which beats the profile, which beats the
+	// top of the file, which beats the defaults
+	if config.OnConflict != "counter" || config.Workers != 4 || config.Rate != 20 || config.Mode != "copy" || !config.Recursive {
+		t.Errorf("OnConflict, Workers, Rate, Mode, Recursive = %s, %d, %v, %s, %v; want counter, 4, 20, copy, true",
+			config.OnConflict, config.Workers, config.Rate, config.Mode, config.Recursive)
+	}
+	if config.Layout != "{year}" {
+		t.Errorf("Layout = %q, want the default", config.Layout)
+	}
+	if want := []string{"exif", "mtime"}; !reflect.DeepEqual(config.TimeSources, want) {
+		t.Errorf("TimeSources = %v, want %v", config.TimeSources, want)
+	}
+	if want := []string{`^IMG_(?P<year>\d{4})`, "^PXL_"}; !reflect.DeepEqual(config.FilenamePatterns, want) {
+		t.Errorf("FilenamePatterns = %v, want %v", config.FilenamePatterns, want)
+	}
+
+	// The profile can come from the environment as well
+	t.Setenv("SCREENSHOT_SORTER_PROFILE", "phone")
+	config, err = parseFlags(flag.NewFlagSet("cmd", flag.ContinueOnError), []string{"-config", path})
+	if err != nil || config.Rate != 20 {
+		t.Errorf("Rate = %v, %v with $SCREENSHOT_SORTER_PROFILE; want 20", config.Rate, err)
+	}
+}
+
+func TestLoadSettingsErrors(t *testing.T) {
+	tests := []struct {
+		config string
+		args   []string
+		want   string
+	}{
+		{config: "rate = 1", args: []string{"-profile", "desktop"}, want: "has no profile desktop"},
+		{config: "colour = 'red'", want: ": unknown setting colour"},
+		{config: "[profile.a]\nlist = true", want: ": unknown setting profile.a.list"},
+		{config: "workers = 'many'", want: `: invalid value "many" for workers`},
+		{config: "mode = ['copy']", want: ": mode takes a single value, not an array"},
+	}
+	for _, tt := range tests {
+		path := writeConfig(t, tt.config)
+		_, err := parseFlags(flag.NewFlagSet("cmd", flag.ContinueOnError), append([]string{"-config", path}, tt.args...))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseFlags() with %q error = %v, want %q", tt.config, err, tt.want)
+		}
+	}
+
+	// -version is not a setting, so the environment cannot turn every run
+	// into a version print
+	t.Setenv("SCREENSHOT_SORTER_VERSION", "1")
+	flags := flag.NewFlagSet("cmd", flag.ContinueOnError)
+	if _, err := parseFlags(flags, []string{"-config", writeConfig(t, "")}); err != nil || flags.Lookup("version").Value.String() != "false" {
+		t.Errorf("version = %s, %v with $SCREENSHOT_SORTER_VERSION; want false", flags.Lookup("version").Value, err)
+	}
+
+	t.Setenv("SCREENSHOT_SORTER_RATE", "fast")
+	_, err := parseFlags(flag.NewFlagSet("cmd", flag.ContinueOnError), []string{"-config", writeConfig(t, "")})
+	if err == nil || !strings.Contains(err.Error(), "$SCREENSHOT_SORTER_RATE") {
+		t.Errorf("parseFlags() with a bad $SCREENSHOT_SORTER_RATE error = %v", err)
+	}
+}
+
+func TestWriteSettings(t *testing.T) {
+	path := writeConfig(t, "bandwidth = '2M'\n[profile.p]\ntime-sources = ['exif', 'mtime']\n")
+	flags := flag.NewFlagSet("cmd", flag.ContinueOnError)
+	sortingFlags(flags)
+	origins, err := loadSettings(flags, []string{"-config", path, "-profile", "p", "-recursive", "-layout", `{year}\{month}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := writeSettings(&out, flags, origins); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# config: " + path + " (flag)",
+		`bandwidth = "2M"`,
+		"# config file",
+		`layout = "{year}\\{month}"`,
+		"recursive = true",
+		`time-sources = ["exif", "mtime"]`,
+		"# profile p",
+		"rate = 100",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, out.String())
+		}
+	}
+
+	// What config show prints reads back as the same settings
+	lines := strings.Split(out.String(), "\n")
+	for i, line := range lines {
+		if i := strings.LastIndex(line, "  #"); i > 0 {
+			line = line[:i]
+		}
+		lines[i] = line
+	}
+	again := flag.NewFlagSet("cmd", flag.ContinueOnError)
+	sortingFlags(again)
+	if _, err := loadSettings(again, []string{"-config", writeConfig(t, strings.Join(lines, "\n"))}); err != nil {
+		t.Fatalf("reading the output back: %v", err)
+	}
+	flags.VisitAll(func(f *flag.Flag) {
+		if f.Name != "config" && f.Name != "profile" && again.Lookup(f.Name).Value.String() != f.Value.String() {
+			t.Errorf("%s = %q read back, want %q", f.Name, again.Lookup(f.Name).Value, f.Value)
+		}
+	})
+}
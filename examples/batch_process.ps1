@@ -1,10 +1,21 @@
-# Example PowerShell script for batch processing screenshots
+# Example PowerShell script for batch processing screenshots.
+# screenshot-sorter exits with 0 on success, 1 if some files could not be
+# processed and 2 if a run could not be carried out at all.
+
+$status = 0
+
+function Invoke-Sorter {
+    "" | screenshot-sorter @args
+    if ($LASTEXITCODE -gt $script:status) { $script:status = $LASTEXITCODE }
+}
 
 # Process all screenshots from Downloads to Pictures
-screenshot-sorter -source "$env:USERPROFILE\Downloads" -target "$env:USERPROFILE\Pictures" -recursive
+Invoke-Sorter -source "$env:USERPROFILE\Downloads" -target "$env:USERPROFILE\Pictures" -recursive
 
 # Process Screenshots folder with verbose output
-screenshot-sorter -source "$env:USERPROFILE\Pictures\Screenshots" -verbose
+Invoke-Sorter -source "$env:USERPROFILE\Pictures\Screenshots" -verbose
 
 # Dry run on Desktop
-screenshot-sorter -source "$env:USERPROFILE\Desktop" -dry-run
\ No newline at end of file
+Invoke-Sorter -source "$env:USERPROFILE\Desktop" -dry-run
+
+exit $status
@@ -1,12 +1,26 @@
 #!/bin/bash
 
-# Example script demonstrating batch processing of multiple directories
+# Example script demonstrating batch processing of multiple directories.
+# screenshot-sorter exits with 0 on success, 1 if some files could not be
+# processed and 2 if a run could not be carried out at all.
+
+status=0
+
+run() {
+    screenshot-sorter "$@" < /dev/null
+    code=$?
+    if [ $code -gt $status ]; then
+        status=$code
+    fi
+}
 
 # Process all screenshots from Downloads to Pictures
-screenshot-sorter -source ~/Downloads -target ~/Pictures -recursive
+run -source ~/Downloads -target ~/Pictures -recursive
 
 # Process Camera Uploads with verbose output
-screenshot-sorter -source ~/Dropbox/Camera\ Uploads -verbose
+run -source ~/Dropbox/Camera\ Uploads -verbose
 
 # Dry run on a specific directory
-screenshot-sorter -source ~/Desktop/Screenshots -dry-run
\ No newline at end of file
+run -source ~/Desktop/Screenshots -dry-run
+
+exit $status
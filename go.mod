@@ -2,4 +2,7 @@ module github.com/screenshot-sorter
 
 go 1.20
 
-require golang.org/x/time v0.3.0
+require (
+	github.com/BurntSushi/toml v1.4.0
+	golang.org/x/time v0.3.0
+)
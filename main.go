@@ -1,59 +1,352 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	// Embed the zone database so -timezone works on systems without one
+	_ "time/tzdata"
 
+	"github.com/screenshot-sorter/pkg/classify"
 	"github.com/screenshot-sorter/pkg/core"
+	"github.com/screenshot-sorter/pkg/fileutils"
+	"github.com/screenshot-sorter/pkg/layout"
+	"github.com/screenshot-sorter/pkg/lock"
+	"github.com/screenshot-sorter/pkg/throttle"
 )
 
 const version = "1.0.0"
 
+// Exit codes
+const (
+	// exitOK means everything was processed
+	exitOK = 0
+	// exitPartial means the run completed but some files or directories
+	// could not be processed
+	exitPartial = 1
+	// exitFatal means the run could not be carried out at all, e.g.
+	// because of an invalid configuration or an unreadable source
+	exitFatal = 2
+	// exitInterrupted means the run was stopped by SIGINT or SIGTERM, the
+	// code shells use for a command killed by Ctrl-C
+	exitInterrupted = 130
+)
+
 func main() {
-	config := parseFlags()
+	ctx := interruptContext()
+
+	if len(os.Args) > 1 {
+		var run func(context.Context, []string) error
+		switch os.Args[1] {
+		case "undo":
+			run = runUndo
+		case "plan":
+			run = runPlan
+		case "apply":
+			run = runApply
+		case "watch":
+			run = runWatch
+		case "serve":
+			run = runServe
+		case "config":
+			run = runConfig
+		}
+		if run != nil {
+			os.Exit(report(run(ctx, os.Args[2:])))
+		}
+	}
+
+	config, err := parseFlags(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Print(err)
+		os.Exit(exitFatal)
+	}
 
 	if config.Version {
 		fmt.Printf("Screenshot Sorter v%s\n", version)
 		return
 	}
 
+	if err := config.Validate(); err != nil {
+		log.Print("Invalid configuration: ", err)
+		os.Exit(exitFatal)
+	}
+
+	release, err := lockTargets(config)
+	if err != nil {
+		log.Print(err)
+		os.Exit(exitFatal)
+	}
 	processor := core.NewImageProcessor(config)
-	if err := processor.ProcessDirectory(config.SourceDir, config.TargetDir); err != nil {
-		log.Fatal(err)
+	summary, err := processor.ProcessDirectory(ctx, config.SourceDir, config.TargetDir)
+	release()
+	code := report(err)
+	if code == exitFatal {
+		os.Exit(code)
+	}
+
+	// JSON is for scripts, which get the whole outcome from the summary and
+	// the exit code, and must not be stopped by the prompt
+	if config.Output == "json" {
+		if err := writeSummary(os.Stdout, summary, config.Output); err != nil {
+			log.Print(err)
+			os.Exit(exitFatal)
+		}
+		os.Exit(code)
 	}
 
-	fmt.Println("\nScreenshot sorting complete!")
+	if err := writeSummary(os.Stdout, summary, config.Output); err != nil {
+		log.Print(err)
+		os.Exit(exitFatal)
+	}
+	switch code {
+	case exitOK:
+		fmt.Println("\nScreenshot sorting complete!")
+	case exitInterrupted:
+		fmt.Println("\nScreenshot sorting was interrupted.")
+	default:
+		fmt.Println("\nScreenshot sorting finished with errors.")
+	}
+	if summary.RunID != "" {
+		fmt.Printf("To undo this run: %s undo -target %q %s\n", filepath.Base(os.Args[0]), config.TargetDir, summary.RunID)
+	}
+	// Whoever interrupted the run wants it to end, not to be asked, and
+	// under cron or a service manager nobody is there to press Enter
+	if code == exitInterrupted || !interactive() {
+		os.Exit(code)
+	}
 	fmt.Println("Press Enter to exit...")
 	if _, err := fmt.Scanln(); err != nil && err.Error() != "unexpected newline" {
 		log.Printf("Error reading input: %v", err)
 	}
+	os.Exit(code)
 }
 
-func parseFlags() *core.Config {
-	config := &core.Config{}
+// report prints the outcome of a run and returns its exit code. Partial
+// failures are listed in full, since that is what cron mails and batch
+// scripts are read for.
+func report(err error) int {
+	var runErr *core.RunError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, context.Canceled):
+		if errors.As(err, &runErr) {
+			fmt.Fprint(os.Stderr, "\n"+runErr.Summary(0))
+		}
+		fmt.Fprintln(os.Stderr, "\nInterrupted: files in progress were completed or rolled back, the rest were left alone")
+		return exitInterrupted
+	case errors.As(err, &runErr):
+		fmt.Fprint(os.Stderr, "\n"+runErr.Summary(0))
+		return exitPartial
+	default:
+		log.Print(err)
+		return exitFatal
+	}
+}
 
-	// Get executable directory as default
-	exePath, err := os.Executable()
+// interruptContext returns a context that is canceled on SIGINT or SIGTERM,
+// so the run can wind down without leaving a file half transferred. A
+// second signal ends the process at once.
+func interruptContext() context.Context {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+		fmt.Fprintln(os.Stderr, "\nInterrupted, finishing the files in progress (interrupt again to quit at once)")
+	}()
+	return ctx
+}
+
+// interactive reports whether stdin is a terminal, with someone at it
+func interactive() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// lockTarget keeps other sorters off targetDir until release is called.
+// Dry runs change nothing and take no lock.
+func lockTarget(targetDir string, dryRun bool) (release func(), err error) {
+	if dryRun {
+		return func() {}, nil
+	}
+	l, err := lock.Acquire(targetDir)
 	if err != nil {
-		log.Fatal("Failed to get executable path:", err)
+		return nil, err
+	}
+	return func() { l.Release() }, nil
+}
+
+// lockTargets locks the target of a sorting run and, if photos are sorted
+// into one of their own, the photo target as well
+func lockTargets(config *core.Config) (release func(), err error) {
+	return lockAll([]string{config.TargetDir, config.PhotoTarget}, config.DryRun)
+}
+
+// lockAll locks each of dirs, skipping empty ones, and releases the locks
+// in reverse order
+func lockAll(dirs []string, dryRun bool) (release func(), err error) {
+	var releases []func()
+	release = func() {
+		for i := len(releases) - 1; i >= 0; i-- {
+			releases[i]()
+		}
+	}
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		r, err := lockTarget(dir, dryRun)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		releases = append(releases, r)
 	}
-	defaultDir := filepath.Dir(exePath)
+	return release, nil
+}
 
-	flag.BoolVar(&config.DryRun, "dry-run", false, "Show what would be done without making changes")
-	flag.BoolVar(&config.Verbose, "verbose", false, "Show detailed processing information")
-	flag.BoolVar(&config.Recursive, "recursive", false, "Process subdirectories recursively")
-	flag.StringVar(&config.TargetDir, "target", "", "Target directory for sorted files (default: source directory)")
-	flag.StringVar(&config.SourceDir, "source", defaultDir, "Source directory to process (default: executable directory)")
-	flag.BoolVar(&config.Version, "version", false, "Show version information")
-	flag.Parse()
+// parseFlags defines the sorting flags on flags and sets them from args,
+// the environment and the configuration file
+func parseFlags(flags *flag.FlagSet, args []string) (*core.Config, error) {
+	config := sortingFlags(flags)
+	if _, err := loadSettings(flags, args); err != nil {
+		return nil, err
+	}
 
 	// If target is not specified, use source directory
 	if config.TargetDir == "" {
 		config.TargetDir = config.SourceDir
 	}
 
+	return config, nil
+}
+
+// sortingFlags defines the flags of a sorting run on flags
+func sortingFlags(flags *flag.FlagSet) *core.Config {
+	config := &core.Config{}
+
+	defaultDir := executableDir()
+
+	flags.BoolVar(&config.DryRun, "dry-run", false, "Show what would be done without making changes")
+	flags.BoolVar(&config.Verbose, "verbose", false, "Show detailed processing information")
+	flags.BoolVar(&config.Recursive, "recursive", false, "Process subdirectories recursively")
+	flags.StringVar(&config.TargetDir, "target", "", "Target directory for sorted files (default: source directory)")
+	flags.StringVar(&config.SourceDir, "source", defaultDir, "Source directory to process (default: executable directory)")
+	flags.BoolVar(&config.Version, "version", false, "Show version information")
+	flags.StringVar(&config.Layout, "layout", layout.Default, "Destination path template, e.g. {year}/{month:02}-{monthname}")
+	flags.Var((*stringList)(&config.FilenamePatterns), "filename-pattern", "Regexp with named groups (year, month, day, hour, minute, second) to read capture times from file names; may be repeated")
+	flags.StringVar(&config.Mode, "mode", "move", "How files reach the target: move, copy, hardlink or reflink")
+	flags.StringVar(&config.OnConflict, "on-conflict", "timestamp", "What to do when the destination name is taken: timestamp, skip, overwrite, counter, hash, keep-newer or skip-if-identical")
+	flags.IntVar(&config.Workers, "workers", core.DefaultWorkers, "Number of files to process at a time")
+	throttleFlags(flags, config)
+	flags.StringVar(&config.Output, "output", "text", "Format of the end-of-run summary: text or json")
+	flags.BoolVar(&config.NoJournal, "no-journal", false, "Do not record this run in the undo journal")
+	flags.BoolVar(&config.Resume, "resume", false, "Skip what an interrupted run with the same options already finished")
+	flags.BoolVar(&config.RemoveDuplicates, "remove-duplicates", false, "Delete source files identical to the existing destination (move mode only)")
+	flags.StringVar(&config.Photos, "photos", "sort", "What to do with camera photos among the screenshots: sort them alike, skip them, or separate them into -photo-target")
+	flags.StringVar(&config.PhotoTarget, "photo-target", "", "Directory that -photos separate sorts camera photos into")
+	flags.Float64Var(&config.ScreenshotThreshold, "screenshot-threshold", classify.DefaultThreshold, "Score from 0 to 1 from which a file counts as a screenshot rather than a photo")
+	flags.BoolVar(&config.FixExtensions, "fix-extensions", false, "Give files whose content does not match their extension, or that have none, the right extension at the destination")
+	flags.StringVar(&config.TimeZone, "timezone", "local", "Time zone for folder names and collision suffixes: an IANA name, \"local\" or \"utc\"")
+	flags.Var((*stringList)(&config.Include), "include", "Only process files whose path below the source matches this glob, e.g. '**/Screenshots/*.png'; may be repeated")
+	flags.Var((*stringList)(&config.Exclude), "exclude", "Leave out files and directories whose path below the source matches this glob, e.g. node_modules or 'Unsorted keep/'; may be repeated")
+	flags.Var((*commaList)(&config.TimeSources), "time-sources", "Comma-separated time sources in priority order (default: "+strings.Join(fileutils.DefaultTimeSources, ",")+")")
 	return config
 }
+
+// throttleFlags defines the flags that limit filesystem operations and
+// copy bandwidth on flags
+func throttleFlags(flags *flag.FlagSet, config *core.Config) {
+	flags.Float64Var(&config.Rate, "rate", core.DefaultRate, "Filesystem operations per second; 0 means no limit")
+	flags.IntVar(&config.Burst, "burst", 1, "Operations that may start at once before -rate applies")
+	flags.Var((*byteCount)(&config.Bandwidth), "bandwidth", "Bytes copied per second, e.g. 500K or 10M; 0 means no limit (default 0)")
+	flags.BoolVar(&config.Adaptive, "adaptive", false, "Slow down while filesystem operations take longer than usual")
+}
+
+// executableDir returns the directory of the running executable, the
+// default source and target
+func executableDir() string {
+	exePath, err := os.Executable()
+	if err != nil {
+		log.Fatal("Failed to get executable path:", err)
+	}
+	return filepath.Dir(exePath)
+}
+
+// stringList is a flag.Value that collects every occurrence of a
+// repeatable flag
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+func (s *stringList) Get() any {
+	return []string(*s)
+}
+
+// commaList is a flag.Value for a comma-separated list
+type commaList []string
+
+func (c *commaList) String() string {
+	return strings.Join(*c, ",")
+}
+
+func (c *commaList) Set(value string) error {
+	*c = splitList(value)
+	return nil
+}
+
+func (c *commaList) Get() any {
+	return []string(*c)
+}
+
+// byteCount is a flag.Value for a number of bytes such as 10M
+type byteCount int64
+
+func (b *byteCount) String() string {
+	n := int64(*b)
+	for _, suffix := range []string{"T", "G", "M", "K"} {
+		unit, _ := throttle.ParseBytes("1" + suffix)
+		if n != 0 && n%unit == 0 {
+			return strconv.FormatInt(n/unit, 10) + suffix
+		}
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+func (b *byteCount) Set(value string) error {
+	n, err := throttle.ParseBytes(value)
+	*b = byteCount(n)
+	return err
+}
+
+// Get returns the count as it is written, e.g. "10M"
+func (b *byteCount) Get() any {
+	return b.String()
+}
+
+// splitList splits a comma-separated flag value, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
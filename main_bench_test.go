@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"testing"
@@ -46,7 +47,7 @@ func BenchmarkProcessFile(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		processor.ProcessFile(tempDir, tempDir, dirEntry)
+		processor.ProcessFile(context.Background(), tempDir, tempDir, dirEntry)
 		// Recreate file for next iteration
 		os.WriteFile(testFile, []byte("test content"), 0644)
 	}
@@ -81,7 +82,7 @@ func BenchmarkProcessDirectory(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		if err := processor.ProcessDirectory(tempDir, tempDir); err != nil {
+		if _, err := processor.ProcessDirectory(context.Background(), tempDir, tempDir); err != nil {
 			b.Fatal(err)
 		}
 
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -86,6 +88,25 @@ func TestParseFlags(t *testing.T) {
 	}
 }
 
+func TestThrottleFlags(t *testing.T) {
+	config, err := parseFlags(flag.NewFlagSet("cmd", flag.ContinueOnError), []string{"-rate", "0", "-burst", "4", "-bandwidth", "10M", "-adaptive"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Rate != 0 || config.Burst != 4 || config.Bandwidth != 10<<20 || !config.Adaptive {
+		t.Errorf("Rate, Burst, Bandwidth, Adaptive = %v, %d, %d, %v, want 0, 4, %d, true",
+			config.Rate, config.Burst, config.Bandwidth, config.Adaptive, 10<<20)
+	}
+
+	config, err = parseFlags(flag.NewFlagSet("cmd", flag.ContinueOnError), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Rate != core.DefaultRate || config.Burst != 1 || config.Bandwidth != 0 || config.Adaptive {
+		t.Errorf("default Rate, Burst, Bandwidth, Adaptive = %v, %d, %d, %v", config.Rate, config.Burst, config.Bandwidth, config.Adaptive)
+	}
+}
+
 func TestProcessFile(t *testing.T) {
 	// Create temporary test directory
 	tempDir, err := os.MkdirTemp("", "screenshot-sorter-test")
@@ -120,11 +141,11 @@ func TestProcessFile(t *testing.T) {
 
 	// Create DirEntry wrapper and test file processing
 	dirEntry := FileInfoDirEntry{info: fileInfo}
-	processed, err := processor.ProcessFile(tempDir, tempDir, dirEntry)
+	result, err := processor.ProcessFile(context.Background(), tempDir, tempDir, dirEntry)
 	if err != nil {
 		t.Errorf("ProcessFile() error = %v", err)
 	}
-	if !processed {
+	if result == nil || !result.Done {
 		t.Error("ProcessFile() file was not processed")
 	}
 
@@ -146,18 +167,25 @@ func TestSupportedFormats(t *testing.T) {
 		{"test.jpeg", true},
 		{"test.gif", true},
 		{"test.bmp", true},
+		{"test.webp", true},
+		{"test.heic", true},
+		{"test.avif", true},
+		{"test.tiff", true},
+		{"test.jxl", true},
 		{"test.txt", false},
 		{"test.doc", false},
-		{"test", false},
+		{".DS_Store", false},
+		{"test", true},     // Content decides for files without an extension
+		{"10.15.32", true}, // and digits alone are not one
 		{"test.PNG", true}, // Test case insensitivity
 		{"test.JPG", true},
 	}
 
+	processor := core.NewImageProcessor(&core.Config{})
 	for _, tt := range tests {
 		t.Run(tt.filename, func(t *testing.T) {
-			ext := filepath.Ext(strings.ToLower(tt.filename))
-			if got := core.SupportedFormats[ext]; got != tt.want {
-				t.Errorf("SupportedFormats[%q] = %v, want %v", ext, got, tt.want)
+			if got := processor.Considers(tt.filename); got != tt.want {
+				t.Errorf("Considers(%q) = %v, want %v", tt.filename, got, tt.want)
 			}
 		})
 	}
@@ -201,7 +229,7 @@ func TestProcessDirectoryRecursive(t *testing.T) {
 	}
 
 	processor := core.NewImageProcessor(config)
-	if err := processor.ProcessDirectory(tempDir, tempDir); err != nil {
+	if _, err := processor.ProcessDirectory(context.Background(), tempDir, tempDir); err != nil {
 		t.Errorf("ProcessDirectory() error = %v", err)
 	}
 
@@ -267,12 +295,12 @@ func TestFileConflictResolution(t *testing.T) {
 	// Process the file
 	fileInfo, _ := os.Stat(testFile)
 	dirEntry := FileInfoDirEntry{info: fileInfo}
-	processed, err := processor.ProcessFile(tempDir, tempDir, dirEntry)
+	result, err := processor.ProcessFile(context.Background(), tempDir, tempDir, dirEntry)
 
 	if err != nil {
 		t.Errorf("ProcessFile() error = %v", err)
 	}
-	if !processed {
+	if result == nil || !result.Done {
 		t.Error("ProcessFile() file was not processed")
 	}
 
@@ -312,13 +340,13 @@ func TestDryRun(t *testing.T) {
 	// Process the file
 	fileInfo, _ := os.Stat(testFile)
 	dirEntry := FileInfoDirEntry{info: fileInfo}
-	processed, err := processor.ProcessFile(tempDir, tempDir, dirEntry)
+	result, err := processor.ProcessFile(context.Background(), tempDir, tempDir, dirEntry)
 
 	if err != nil {
 		t.Errorf("ProcessFile() error = %v", err)
 	}
-	if !processed {
-		t.Error("ProcessFile() should return processed=true in dry-run mode")
+	if result == nil || !result.Done {
+		t.Error("ProcessFile() should report the file as done in dry-run mode")
 	}
 
 	// Verify file was not moved
@@ -343,7 +371,7 @@ func TestProcessDirectoryErrors(t *testing.T) {
 	}
 
 	processor := core.NewImageProcessor(config)
-	err := processor.ProcessDirectory("nonexistent", "nonexistent")
+	_, err := processor.ProcessDirectory(context.Background(), "nonexistent", "nonexistent")
 	if err == nil {
 		t.Error("ProcessDirectory() should return error for non-existent directory")
 	}
@@ -371,9 +399,81 @@ func TestProcessDirectoryErrors(t *testing.T) {
 	config.TargetDir = tempDir
 
 	// Attempt to process the read-only directory
-	err = processor.ProcessDirectory(tempDir, tempDir)
+	_, err = processor.ProcessDirectory(context.Background(), tempDir, tempDir)
 	if err == nil {
 		// Note: On Windows, this might still succeed due to permission inheritance
 		t.Log("Warning: Expected error for read-only directory, but got none (might be OS-dependent)")
 	}
 }
+
+func TestReport(t *testing.T) {
+	partial := &core.RunError{Errors: []*core.ProcessError{{Path: "a.png", Op: "move", Err: os.ErrPermission}}}
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"success", nil, exitOK},
+		{"partial failure", partial, exitPartial},
+		{"wrapped partial failure", fmt.Errorf("run: %w", partial), exitPartial},
+		{"fatal", os.ErrNotExist, exitFatal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := report(tt.err); got != tt.want {
+				t.Errorf("report() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteSummary(t *testing.T) {
+	day := time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC)
+	s := &core.Summary{
+		Mode:        "copy",
+		Scanned:     4,
+		Transferred: 2,
+		Bytes:       3 << 20,
+		Skipped:     map[string]int{core.ReasonUnsupported: 2},
+		Buckets:     map[string]*core.BucketSummary{"2023": {Files: 2, Bytes: 3 << 20, First: day, Last: day}},
+		Elapsed:     1500 * time.Millisecond,
+	}
+
+	var text strings.Builder
+	if err := writeSummary(&text, s, "text"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Scanned 4 files in 1.5s", "Copied: 2 (3.0 MiB)", "Skipped: 2 (unsupported format: 2)", "2023  2 files"} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("text summary %q lacks %q", text.String(), want)
+		}
+	}
+
+	var data strings.Builder
+	if err := writeSummary(&data, s, "json"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(data.String(), `"elapsed_seconds": 1.5`) {
+		t.Errorf("JSON summary = %s", data.String())
+	}
+}
+
+func TestLockTargets(t *testing.T) {
+	config := &core.Config{TargetDir: t.TempDir(), Photos: "separate", PhotoTarget: t.TempDir()}
+	release, err := lockTargets(config)
+	if err != nil {
+		t.Fatalf("lockTargets() error = %v", err)
+	}
+	// Both targets are taken until the locks are released
+	if _, err := lockTarget(config.PhotoTarget, false); err == nil {
+		t.Error("photo target was not locked")
+	}
+	release()
+	for _, dir := range []string{config.TargetDir, config.PhotoTarget} {
+		again, err := lockTarget(dir, false)
+		if err != nil {
+			t.Fatalf("%s still locked after release: %v", dir, err)
+		}
+		again()
+	}
+}
@@ -0,0 +1,244 @@
+// Package checkpoint records the progress of a run, so that a run cut short
+// by a reboot or an interrupt can resume without evaluating again what it
+// already finished.
+package checkpoint
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/screenshot-sorter/pkg/journal"
+)
+
+// fileName is the checkpoint of a target, next to its journals
+const fileName = "checkpoint.jsonl"
+
+// version is the format version written to checkpoint headers
+const version = 1
+
+// FlushInterval is how often buffered records are written out. Records of
+// finished transfers are written out at once.
+const FlushInterval = 5 * time.Second
+
+// Path returns the checkpoint file of targetDir
+func Path(targetDir string) string {
+	return filepath.Join(targetDir, journal.DirName, fileName)
+}
+
+// Header identifies the run a checkpoint belongs to. It is the first line
+// of the file.
+type Header struct {
+	Version   int       `json:"version"`
+	Started   time.Time `json:"started"`
+	SourceDir string    `json:"source_dir"`
+	TargetDir string    `json:"target_dir"`
+	// Settings fingerprints the configuration that decides where files go;
+	// a checkpoint only applies to runs with the same settings
+	Settings string `json:"settings"`
+	// RunID is the undo journal of the run, if it keeps one, so a resumed
+	// run can be undone together with the part done before
+	RunID string `json:"run_id,omitempty"`
+}
+
+// Kinds of records
+const (
+	// KindDir is a directory whose files were all processed. ModTime is
+	// the directory's own, taken when it was finished.
+	KindDir = "dir"
+	// KindFile is a file that was processed. ModTime and Size are the
+	// source's; Dest is where it went, if Done.
+	KindFile = "file"
+)
+
+// Record is one finished directory or file
+type Record struct {
+	Kind    string    `json:"kind"`
+	Path    string    `json:"path"`
+	ModTime time.Time `json:"mod_time"`
+	Size    int64     `json:"size,omitempty"`
+	Dest    string    `json:"dest,omitempty"`
+	// Done is set when the file was transferred or removed; otherwise it
+	// was left where it was
+	Done bool `json:"done,omitempty"`
+}
+
+// State is a checkpoint read back from disk
+type State struct {
+	Header
+	// Dirs and Files map paths to their latest record
+	Dirs  map[string]Record
+	Files map[string]Record
+}
+
+// Load reads the checkpoint of targetDir. It returns nil if there is none.
+func Load(targetDir string) (*State, error) {
+	f, err := os.Open(Path(targetDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("checkpoint is empty")
+	}
+	s := &State{Dirs: make(map[string]Record), Files: make(map[string]Record)}
+	if err := json.Unmarshal(scanner.Bytes(), &s.Header); err != nil {
+		return nil, fmt.Errorf("checkpoint header: %w", err)
+	}
+	if s.Version != version {
+		return nil, fmt.Errorf("unsupported checkpoint version %d", s.Version)
+	}
+
+	var truncated error
+	for line := 2; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		if truncated != nil {
+			return nil, truncated
+		}
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			// Like the journal, a run killed mid-write can leave a partial
+			// last line
+			truncated = fmt.Errorf("checkpoint line %d: %w", line, err)
+			continue
+		}
+		switch r.Kind {
+		case KindDir:
+			s.Dirs[r.Path] = r
+		case KindFile:
+			s.Files[r.Path] = r
+		}
+	}
+	return s, scanner.Err()
+}
+
+// Writer appends records to a checkpoint. It is safe for concurrent use.
+// Errors are sticky: after the first, records are dropped and Close
+// returns it.
+type Writer struct {
+	path string
+	// header is written with the first record of a new checkpoint, so runs
+	// that finish nothing leave none behind
+	header  []byte
+	mu      sync.Mutex
+	f       *os.File
+	buf     *bufio.Writer
+	flushed time.Time
+	err     error
+}
+
+// Create starts a new checkpoint for targetDir. Any earlier one is
+// replaced once the first record is added.
+func Create(targetDir string, h Header) (*Writer, error) {
+	h.Version = version
+	line, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{path: Path(targetDir), header: append(line, '\n')}, nil
+}
+
+// Append continues the checkpoint of targetDir after a resume
+func Append(targetDir string) (*Writer, error) {
+	path := Path(targetDir)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{path: path, f: f, buf: bufio.NewWriter(f), flushed: time.Now()}, nil
+}
+
+// create writes the header to a temporary file and renames it into place,
+// so a crash never leaves a checkpoint without one
+func (w *Writer) create() error {
+	if err := os.MkdirAll(filepath.Dir(w.path), 0755); err != nil {
+		return fmt.Errorf("failed to create checkpoint directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(w.path), "."+fileName+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(w.header); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), w.path); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	w.f, w.buf, w.flushed = tmp, bufio.NewWriter(tmp), time.Now()
+	return nil
+}
+
+// Add appends a record. Records of files that were transferred or removed
+// are written out at once, since repeating such a file after a crash could
+// transfer it twice; the rest are written out every FlushInterval.
+func (w *Writer) Add(r Record) {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return
+	}
+	if w.f == nil {
+		if w.err = w.create(); w.err != nil {
+			return
+		}
+	}
+	if _, w.err = w.buf.Write(append(line, '\n')); w.err != nil {
+		return
+	}
+	if r.Done || time.Since(w.flushed) >= FlushInterval {
+		w.err = w.buf.Flush()
+		w.flushed = time.Now()
+	}
+}
+
+// Close writes out the remaining records and closes the checkpoint
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil {
+		return w.err
+	}
+	if w.err == nil {
+		w.err = w.buf.Flush()
+	}
+	if err := w.f.Close(); w.err == nil {
+		w.err = err
+	}
+	return w.err
+}
+
+// Remove deletes the checkpoint of targetDir once its run is complete,
+// along with the state directory if nothing else is kept there
+func Remove(targetDir string) error {
+	if err := os.Remove(Path(targetDir)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	os.Remove(filepath.Dir(Path(targetDir)))
+	return nil
+}
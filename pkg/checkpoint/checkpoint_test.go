@@ -0,0 +1,81 @@
+package checkpoint
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWriterAndLoad(t *testing.T) {
+	target := t.TempDir()
+	if s, err := Load(target); s != nil || err != nil {
+		t.Fatalf("Load() without a checkpoint = %+v, %v", s, err)
+	}
+
+	w, err := Create(target, Header{SourceDir: "/src", TargetDir: target, Settings: "abc", RunID: "run"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(Path(target)); !os.IsNotExist(err) {
+		t.Error("Create() wrote a checkpoint before the first record")
+	}
+	mtime := time.Date(2023, 4, 1, 10, 0, 0, 0, time.UTC)
+	w.Add(Record{Kind: KindFile, Path: "/src/a.png", ModTime: mtime, Size: 3, Dest: target + "/2023/a.png", Done: true})
+	w.Add(Record{Kind: KindFile, Path: "/src/b.png", ModTime: mtime, Size: 4})
+	w.Add(Record{Kind: KindDir, Path: "/src", ModTime: mtime})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Records added after a resume follow the earlier ones, and a partial
+	// last line is ignored
+	w, err = Append(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Add(Record{Kind: KindFile, Path: "/src/c.png", ModTime: mtime, Size: 5})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(Path(target), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"kind":"file","pa`)
+	f.Close()
+
+	s, err := Load(target)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if s.SourceDir != "/src" || s.Settings != "abc" || s.RunID != "run" {
+		t.Errorf("Load() header = %+v", s.Header)
+	}
+	if len(s.Files) != 3 || !s.Files["/src/a.png"].Done || s.Files["/src/c.png"].Size != 5 {
+		t.Errorf("Load() files = %+v", s.Files)
+	}
+	if d, ok := s.Dirs["/src"]; !ok || !d.ModTime.Equal(mtime) {
+		t.Errorf("Load() dirs = %+v", s.Dirs)
+	}
+
+	if err := Remove(target); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := Load(target); s != nil || err != nil {
+		t.Errorf("Load() after Remove() = %+v, %v", s, err)
+	}
+}
+
+func TestLoadCorrupt(t *testing.T) {
+	target := t.TempDir()
+	w, _ := Create(target, Header{})
+	w.Add(Record{Kind: KindFile, Path: "/src/a.png"})
+	w.Close()
+	f, _ := os.OpenFile(Path(target), os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString("garbage\n" + `{"kind":"file","path":"/src/b.png"}` + "\n")
+	f.Close()
+
+	if _, err := Load(target); err == nil {
+		t.Error("Load() should reject a damaged line that is not the last")
+	}
+}
@@ -0,0 +1,225 @@
+// Package classify tells screenshots from camera photos. Each signal found
+// in a file, such as camera EXIF tags or a screen-sized image, adds to or
+// takes from the case for a screenshot, and the total becomes a score
+// between 0 and 1.
+package classify
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/screenshot-sorter/pkg/fileutils"
+)
+
+// Kind is what a file turned out to be
+type Kind string
+
+const (
+	Screenshot Kind = "screenshot"
+	Photo      Kind = "photo"
+)
+
+// DefaultThreshold is the score from which a file counts as a screenshot
+// unless New is given another
+const DefaultThreshold = 0.5
+
+// Weights of the signals, in log-odds for a screenshot: a file with no
+// signals scores 0.5, and each weight of 1 shifts the odds by a factor e
+const (
+	weightCameraEXIF   = -3
+	weightExposure     = -2
+	weightNoCameraEXIF = 1
+	weightPNG          = 1.5
+	weightHEIC         = -1.5
+	weightScreenSize   = 2
+	weightScreenName   = 3
+	weightCameraName   = -1.5
+)
+
+// screenshotName matches the names screenshot tools give files, in the
+// languages they most often come in
+var screenshotName = regexp.MustCompile(`(?i)screen ?shot|screencap|screen capture|bildschirmfoto|capture d.écran|schermata|captura de pantalla|schermafbeelding|skärmbild|zrzut ekranu|снимок экрана|スクリーンショット|屏幕截图|截屏|스크린샷`)
+
+// cameraName matches the names cameras and phone camera apps give photos.
+// iPhones name screenshots IMG_1234.PNG as well, so it counts for less
+// than a screenshot name.
+var cameraName = regexp.MustCompile(`(?i)^(img|dsc[fn]?|_dsc|pxl|mvimg|dji|gopr|p\d{3})[_-]?\d|^\d{8}_\d{6}`)
+
+// Image is what is known about a file
+type Image struct {
+	// Name is the file name
+	Name string
+	// Format is the name of its format, as in package format
+	Format string
+	// Width and Height are the size of the image, or zero if unknown
+	Width, Height int
+	// EXIF is the EXIF block of the file, if it has one
+	EXIF *fileutils.EXIF
+	// EXIFRead is set when the file was checked for EXIF, so a nil EXIF
+	// means it has none rather than that it was not looked for
+	EXIFRead bool
+}
+
+// Signal is one piece of evidence and its weight
+type Signal struct {
+	Name   string
+	Weight float64
+}
+
+// Result is the verdict on a file
+type Result struct {
+	Kind Kind
+	// Score is the confidence, from 0 to 1, that the file is a screenshot
+	Score float64
+	// Signals are the evidence the score rests on
+	Signals []Signal
+}
+
+// String describes the result, e.g. "photo (0.05: camera EXIF, camera name)"
+func (r Result) String() string {
+	names := make([]string, len(r.Signals))
+	for i, s := range r.Signals {
+		names[i] = s.Name
+	}
+	if len(names) == 0 {
+		names = []string{"no signals"}
+	}
+	return fmt.Sprintf("%s (%.2f: %s)", r.Kind, r.Score, strings.Join(names, ", "))
+}
+
+// Classifier scores images. It is safe for concurrent use.
+type Classifier struct {
+	threshold float64
+}
+
+// New returns a classifier that calls files scoring at least threshold
+// screenshots. Zero means DefaultThreshold.
+func New(threshold float64) (*Classifier, error) {
+	if threshold == 0 {
+		threshold = DefaultThreshold
+	}
+	if threshold <= 0 || threshold >= 1 || math.IsNaN(threshold) {
+		return nil, fmt.Errorf("invalid screenshot threshold %v (must be between 0 and 1)", threshold)
+	}
+	return &Classifier{threshold: threshold}, nil
+}
+
+// Classify weighs the signals found in img
+func (c *Classifier) Classify(img Image) Result {
+	var signals []Signal
+	add := func(name string, weight float64) {
+		signals = append(signals, Signal{Name: name, Weight: weight})
+	}
+
+	switch e := img.EXIF; {
+	case e != nil && (e.Make != "" || e.Model != ""):
+		add("camera EXIF", weightCameraEXIF)
+		if e.Exposure {
+			add("exposure settings", weightExposure)
+		}
+	case e != nil && e.Exposure:
+		add("exposure settings", weightExposure)
+	case img.EXIFRead:
+		add("no camera EXIF", weightNoCameraEXIF)
+	}
+	switch img.Format {
+	case "png":
+		add("PNG", weightPNG)
+	case "heic":
+		add("HEIC", weightHEIC)
+	}
+	if screenSize(img.Width, img.Height) {
+		add(fmt.Sprintf("screen size %dx%d", img.Width, img.Height), weightScreenSize)
+	}
+	switch {
+	case screenshotName.MatchString(img.Name):
+		add("screenshot name", weightScreenName)
+	case cameraName.MatchString(img.Name):
+		add("camera name", weightCameraName)
+	}
+
+	var sum float64
+	for _, s := range signals {
+		sum += s.Weight
+	}
+	r := Result{Kind: Photo, Score: 1 / (1 + math.Exp(-sum)), Signals: signals}
+	if r.Score >= c.threshold {
+		r.Kind = Screenshot
+	}
+	return r
+}
+
+// Read collects what Classify needs from the file at path, which is in
+// the named format. Camera EXIF is read from JPEG and PNG files, and the
+// image size from JPEG, PNG, GIF, BMP and WebP files; formats that cannot
+// hold EXIF at all count as read.
+func Read(path, format string) (Image, error) {
+	img := Image{Name: filepath.Base(path), Format: format}
+	f, err := os.Open(path)
+	if err != nil {
+		return img, err
+	}
+	defer f.Close()
+
+	switch format {
+	case "jpeg":
+		info, err := fileutils.ReadJPEGInfo(f)
+		if err != nil {
+			return img, err
+		}
+		img.Width, img.Height, img.EXIF, img.EXIFRead = info.Width, info.Height, info.EXIF, true
+	case "png":
+		meta, err := fileutils.ReadPNGMetadata(f)
+		if err != nil {
+			return img, err
+		}
+		img.Width, img.Height, img.EXIF, img.EXIFRead = meta.Width, meta.Height, meta.EXIF, true
+	case "gif", "bmp", "webp":
+		header := make([]byte, 32)
+		n, err := io.ReadFull(f, header)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return img, err
+		}
+		img.Width, img.Height, img.EXIFRead = headerSize(format, header[:n])
+	}
+	return img, nil
+}
+
+// headerSize reads the image size from the first bytes of a GIF, BMP or
+// WebP file. exifRead is false for WebP files that may carry EXIF.
+func headerSize(format string, h []byte) (width, height int, exifRead bool) {
+	le16 := func(i int) int { return int(binary.LittleEndian.Uint16(h[i:])) }
+	le24 := func(i int) int { return int(h[i]) | int(h[i+1])<<8 | int(h[i+2])<<16 }
+	switch {
+	case format == "gif" && len(h) >= 10:
+		return le16(6), le16(8), true
+	case format == "bmp" && len(h) >= 26:
+		if binary.LittleEndian.Uint32(h[14:]) == 12 {
+			return le16(18), le16(20), true
+		}
+		w, hgt := int32(binary.LittleEndian.Uint32(h[18:])), int32(binary.LittleEndian.Uint32(h[22:]))
+		if hgt < 0 {
+			// Top-down bitmaps have a negative height
+			hgt = -hgt
+		}
+		return int(w), int(hgt), true
+	case format == "webp" && len(h) >= 30:
+		switch string(h[12:16]) {
+		case "VP8X":
+			// Bit 3 of the flags says whether an EXIF chunk follows
+			return le24(24) + 1, le24(27) + 1, h[20]&0x08 == 0
+		case "VP8 ":
+			return le16(26) & 0x3FFF, le16(28) & 0x3FFF, true
+		case "VP8L":
+			bits := binary.LittleEndian.Uint32(h[21:])
+			return int(bits&0x3FFF) + 1, int(bits>>14&0x3FFF) + 1, true
+		}
+	}
+	return 0, 0, false
+}
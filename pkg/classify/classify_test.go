@@ -0,0 +1,123 @@
+package classify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/screenshot-sorter/pkg/fileutils"
+)
+
+func TestClassify(t *testing.T) {
+	camera := &fileutils.EXIF{Make: "Apple", Model: "iPhone 15", Exposure: true}
+	tests := []struct {
+		name string
+		img  Image
+		want Kind
+	}{
+		{"iPhone screenshot", Image{Name: "IMG_0042.PNG", Format: "png", Width: 1179, Height: 2556, EXIFRead: true}, Screenshot},
+		{"iPhone photo", Image{Name: "IMG_0043.HEIC", Format: "heic"}, Photo},
+		{"Pixel photo", Image{Name: "PXL_20230401_102233123.jpg", Format: "jpeg", Width: 4080, Height: 3072, EXIF: camera, EXIFRead: true}, Photo},
+		{"Android screenshot", Image{Name: "Screenshot_20230401-102233_Chrome.jpg", Format: "jpeg", Width: 1080, Height: 2400, EXIFRead: true}, Screenshot},
+		{"macOS screenshot", Image{Name: "Screenshot 2023-04-01 at 10.22.33.png", Format: "png", Width: 1280, Height: 720, EXIFRead: true}, Screenshot},
+		{"German screenshot", Image{Name: "Bildschirmfoto vom 2023-04-01.png", Format: "png", EXIFRead: true}, Screenshot},
+		{"landscape screenshot", Image{Name: "capture.jpg", Format: "jpeg", Width: 2532, Height: 1170, EXIFRead: true}, Screenshot},
+		{"DSLR photo", Image{Name: "DSC01234.JPG", Format: "jpeg", Width: 6000, Height: 4000, EXIF: &fileutils.EXIF{Make: "SONY"}, EXIFRead: true}, Photo},
+		{"forwarded photo", Image{Name: "IMG-20230401-WA0001.jpg", Format: "jpeg", Width: 1600, Height: 1200, EXIFRead: true}, Photo},
+		// A screenshot that went through a camera app keeps its name
+		{"screenshot with camera EXIF", Image{Name: "Screenshot_20230401.png", Format: "png", EXIF: camera, EXIFRead: true}, Photo},
+		{"nothing known", Image{Name: "download"}, Screenshot},
+	}
+	c, err := New(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		if got := c.Classify(tt.img); got.Kind != tt.want {
+			t.Errorf("Classify(%s) = %v, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	got := c.Classify(Image{Name: "PXL_1.jpg", Format: "jpeg", EXIF: camera, EXIFRead: true})
+	if want := "photo (0.00: camera EXIF, exposure settings, camera name)"; got.String() != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if got.Score <= 0 || got.Score >= 0.01 {
+		t.Errorf("Score = %v, want just above 0", got.Score)
+	}
+
+	// A stricter threshold sends doubtful files to the photos
+	strict, err := New(0.9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doubtful := Image{Name: "capture.jpg", Format: "jpeg", EXIFRead: true}
+	if c.Classify(doubtful).Kind != Screenshot || strict.Classify(doubtful).Kind != Photo {
+		t.Errorf("Classify(doubtful) = %v by default and %v with 0.9", c.Classify(doubtful), strict.Classify(doubtful))
+	}
+
+	for _, threshold := range []float64{-0.5, 1, 2} {
+		if _, err := New(threshold); err == nil {
+			t.Errorf("New(%v) should fail", threshold)
+		}
+	}
+}
+
+// pngFile builds a PNG file of the given size with no other chunks
+func pngFile(width, height uint32) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	chunk := func(typ string, data []byte) {
+		binary.Write(&buf, binary.BigEndian, uint32(len(data)))
+		buf.WriteString(typ)
+		buf.Write(data)
+		binary.Write(&buf, binary.BigEndian, crc32.Update(crc32.ChecksumIEEE([]byte(typ)), crc32.IEEETable, data))
+	}
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], width)
+	binary.BigEndian.PutUint32(ihdr[4:], height)
+	chunk("IHDR", ihdr)
+	chunk("IEND", nil)
+	return buf.Bytes()
+}
+
+func TestRead(t *testing.T) {
+	dir := t.TempDir()
+	vp8x := []byte("RIFF\x00\x00\x00\x00WEBPVP8X\x0A\x00\x00\x00\x08\x00\x00\x00")
+	vp8x = append(vp8x, 0x9F, 0x05, 0x00, 0x1B, 0x0B, 0x00) // 1440x2844, with EXIF
+	tests := []struct {
+		name, format  string
+		data          []byte
+		width, height int
+		exifRead      bool
+	}{
+		{"shot.png", "png", pngFile(1170, 2532), 1170, 2532, true},
+		{"shot.jpg", "jpeg", []byte("\xFF\xD8\xFF\xC0\x00\x0B\x08\x04\x38\x07\x80\x01\x01\x11\x00\xFF\xDA\x00\x02"), 1920, 1080, true},
+		{"anim.gif", "gif", []byte("GIF89a\x00\x05\xD0\x02\x00"), 1280, 720, true},
+		{"shot.bmp", "bmp", append([]byte("BM\x00\x00\x00\x00\x00\x00\x00\x00\x36\x00\x00\x00\x28\x00\x00\x00"), 0x00, 0x04, 0, 0, 0x00, 0xFD, 0xFF, 0xFF), 1024, 768, true},
+		{"shot.webp", "webp", vp8x, 1440, 2844, false},
+		{"photo.heic", "heic", []byte("\x00\x00\x00\x18ftypheic"), 0, 0, false},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		if err := os.WriteFile(path, tt.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		img, err := Read(path, tt.format)
+		if err != nil {
+			t.Errorf("Read(%s) error = %v", tt.name, err)
+			continue
+		}
+		if img.Name != tt.name || img.Width != tt.width || img.Height != tt.height || img.EXIFRead != tt.exifRead {
+			t.Errorf("Read(%s) = %+v, want %dx%d, EXIFRead %v", tt.name, img, tt.width, tt.height, tt.exifRead)
+		}
+	}
+
+	if _, err := Read(filepath.Join(dir, "missing.png"), "png"); err == nil || !strings.Contains(err.Error(), "missing.png") {
+		t.Errorf("Read() of a missing file error = %v", err)
+	}
+}
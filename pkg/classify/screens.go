@@ -0,0 +1,36 @@
+package classify
+
+// screenSizes lists the resolutions of common phone, tablet and computer
+// screens, smaller side first. Camera sensors come in other sizes, so an
+// image of exactly one of these is likely a screenshot.
+var screenSizes = map[[2]int]bool{
+	// iPhone
+	{640, 1136}: true, {750, 1334}: true, {828, 1792}: true, {1080, 1920}: true,
+	{1125, 2436}: true, {1170, 2532}: true, {1179, 2556}: true, {1206, 2622}: true,
+	{1242, 2208}: true, {1242, 2688}: true, {1284, 2778}: true, {1290, 2796}: true,
+	{1320, 2868}: true,
+	// Android
+	{720, 1280}: true, {720, 1600}: true, {1080, 2160}: true, {1080, 2280}: true,
+	{1080, 2340}: true, {1080, 2400}: true, {1080, 2408}: true, {1080, 2412}: true,
+	{1280, 2856}: true, {1344, 2992}: true, {1440, 2560}: true, {1440, 2960}: true,
+	{1440, 3040}: true, {1440, 3088}: true, {1440, 3120}: true, {1440, 3200}: true,
+	// Tablets
+	{1200, 1920}: true, {1536, 2048}: true, {1600, 2560}: true, {1620, 2160}: true,
+	{1640, 2360}: true, {1668, 2224}: true, {1668, 2388}: true, {1800, 2880}: true,
+	{2048, 2732}: true,
+	// Computers, apart from the sizes they share with the above
+	{768, 1024}: true, {800, 1280}: true, {1024, 1280}: true, {768, 1366}: true,
+	{900, 1440}: true, {864, 1536}: true, {900, 1600}: true, {1050, 1680}: true,
+	{1080, 2560}: true, {1504, 2256}: true, {1664, 2496}: true, {1824, 2736}: true,
+	{1864, 2880}: true, {1920, 2880}: true, {1964, 3024}: true, {2234, 3456}: true,
+	{1440, 3440}: true, {2160, 3840}: true, {2880, 5120}: true,
+}
+
+// screenSize reports whether an image of this size, in either orientation,
+// fills a common screen
+func screenSize(width, height int) bool {
+	if width > height {
+		width, height = height, width
+	}
+	return screenSizes[[2]int{width, height}]
+}
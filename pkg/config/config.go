@@ -0,0 +1,190 @@
+// Package config reads the sorter's configuration file. The file is TOML,
+// with settings named after the command-line flags, at the top for every
+// run and under [profile.<name>] for runs with that profile.
+//
+//	target = "~/Pictures"
+//	recursive = true
+//
+//	[profile.phone-dump]
+//	source = "/mnt/phone/DCIM"
+//	mode = "copy"
+//	time-sources = ["exif", "filename", "mtime"]
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+
+	"github.com/BurntSushi/toml"
+)
+
+// FileName is the configuration file within a config directory
+var FileName = filepath.Join("screenshot-sorter", "config.toml")
+
+// Setting is one key = value line
+type Setting struct {
+	// Values holds the value as it would be given on the command line, or
+	// one entry per element if the value is an array
+	Values []string
+	Array  bool
+	// Key names the setting in error messages, e.g. "mode" or
+	// "profile.phone-dump.mode"
+	Key string
+}
+
+// File is a parsed configuration file
+type File struct {
+	Path string
+	// Settings are the settings at the top of the file
+	Settings map[string]Setting
+	// Profiles holds the settings of each [profile.<name>] table
+	Profiles map[string]map[string]Setting
+}
+
+// SyntaxError is a line of a configuration file that cannot be parsed
+type SyntaxError struct {
+	Line int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// Load reads and parses the configuration file at path
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration: %w", err)
+	}
+	f, err := Parse(data)
+	if err != nil {
+		var se *SyntaxError
+		if errors.As(err, &se) {
+			return nil, fmt.Errorf("%s:%d: %s", path, se.Line, se.Msg)
+		}
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	f.Path = path
+	return f, nil
+}
+
+// Find returns the first configuration file that exists, looking in the
+// user's config directory and then, except on Windows and macOS, in each
+// of $XDG_CONFIG_DIRS (default /etc/xdg). It returns "" if there is none.
+func Find() string {
+	var dirs []string
+	if dir, err := os.UserConfigDir(); err == nil {
+		dirs = append(dirs, dir)
+	}
+	if runtime.GOOS != "windows" && runtime.GOOS != "darwin" {
+		system := os.Getenv("XDG_CONFIG_DIRS")
+		if system == "" {
+			system = "/etc/xdg"
+		}
+		dirs = append(dirs, filepath.SplitList(system)...)
+	}
+	for _, dir := range dirs {
+		// Relative entries are invalid under the XDG spec
+		if !filepath.IsAbs(dir) {
+			continue
+		}
+		path := filepath.Join(dir, FileName)
+		if fi, err := os.Stat(path); err == nil && fi.Mode().IsRegular() {
+			return path
+		}
+	}
+	return ""
+}
+
+// Parse parses the content of a configuration file
+func Parse(data []byte) (*File, error) {
+	var raw map[string]any
+	if _, err := toml.Decode(string(data), &raw); err != nil {
+		var pe toml.ParseError
+		if errors.As(err, &pe) {
+			return nil, &SyntaxError{Line: pe.Position.Line, Msg: pe.Message}
+		}
+		return nil, err
+	}
+
+	f := &File{Settings: make(map[string]Setting), Profiles: make(map[string]map[string]Setting)}
+	for _, key := range sortedKeys(raw) {
+		table, ok := raw[key].(map[string]any)
+		if !ok {
+			s, err := setting(key, raw[key])
+			if err != nil {
+				return nil, err
+			}
+			f.Settings[key] = s
+			continue
+		}
+		if key != "profile" {
+			return nil, fmt.Errorf("unknown table [%s], expected [profile.<name>]", key)
+		}
+		for _, name := range sortedKeys(table) {
+			values, ok := table[name].(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("profile.%s is not a table, expected [profile.%s]", name, name)
+			}
+			profile := make(map[string]Setting)
+			for _, key := range sortedKeys(values) {
+				s, err := setting("profile."+name+"."+key, values[key])
+				if err != nil {
+					return nil, err
+				}
+				profile[key] = s
+			}
+			f.Profiles[name] = profile
+		}
+	}
+	return f, nil
+}
+
+// setting converts a decoded value, a string, number, boolean or array of
+// those, into a Setting
+func setting(key string, value any) (Setting, error) {
+	list, ok := value.([]any)
+	if !ok {
+		v, err := scalar(key, value)
+		return Setting{Values: []string{v}, Key: key}, err
+	}
+	s := Setting{Values: []string{}, Array: true, Key: key}
+	for _, elem := range list {
+		v, err := scalar(key, elem)
+		if err != nil {
+			return s, err
+		}
+		s.Values = append(s.Values, v)
+	}
+	return s, nil
+}
+
+// scalar formats a value as it would be given on the command line
+func scalar(key string, value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("%s: expected a string, number, boolean or array of those", key)
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
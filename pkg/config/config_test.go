@@ -0,0 +1,107 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	data := `# Settings for every run
+target = "~/Pictures"   # trailing comment
+recursive = true
+rate = 1_000
+"on-conflict" = 'skip'
+
+[profile.desktop]
+source = "C:\\Users\\me\\Desktop"
+
+[ profile . "phone-dump" ]
+mode = "copy"
+filename-pattern = [
+  '^IMG_(?P<year>\d{4})(?P<month>\d{2})',  # camera
+  "^PXL_",
+]
+burst = 0x10
+rate = 0.5
+empty = []
+`
+	f, err := Parse([]byte(data))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	want := &File{
+		Settings: map[string]Setting{
+			"target":      {Values: []string{"~/Pictures"}, Key: "target"},
+			"recursive":   {Values: []string{"true"}, Key: "recursive"},
+			"rate":        {Values: []string{"1000"}, Key: "rate"},
+			"on-conflict": {Values: []string{"skip"}, Key: "on-conflict"},
+		},
+		Profiles: map[string]map[string]Setting{
+			"desktop": {
+				"source": {Values: []string{`C:\Users\me\Desktop`}, Key: "profile.desktop.source"},
+			},
+			"phone-dump": {
+				"mode":             {Values: []string{"copy"}, Key: "profile.phone-dump.mode"},
+				"filename-pattern": {Values: []string{`^IMG_(?P<year>\d{4})(?P<month>\d{2})`, "^PXL_"}, Array: true, Key: "profile.phone-dump.filename-pattern"},
+				"burst":            {Values: []string{"16"}, Key: "profile.phone-dump.burst"},
+				"rate":             {Values: []string{"0.5"}, Key: "profile.phone-dump.rate"},
+				"empty":            {Values: []string{}, Array: true, Key: "profile.phone-dump.empty"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("Parse() = %+v, want %+v", f, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		// Syntax errors name the line
+		{"mode = copy", "line 1: "},
+		{"rate = 1\nrate = 2", "line 2: "},
+		{"[profile.a]\nmode = 'copy'\n[profile.a]", "line 3: "},
+		// Anything else names the setting
+		{"[settings]", "unknown table [settings], expected [profile.<name>]"},
+		{"profile.a = 'copy'", "profile.a is not a table, expected [profile.a]"},
+		{"since = 2023-04-01", "since: expected a string, number, boolean or array of those"},
+		{"[profile.a]\ntime-sources = [['exif']]", "profile.a.time-sources: expected a string, number, boolean or array of those"},
+	}
+	for _, tt := range tests {
+		_, err := Parse([]byte(tt.data))
+		if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("Parse(%q) error = %v, want %q", tt.data, err, tt.want)
+		}
+	}
+}
+
+func TestLoadAndFind(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		t.Skip("XDG directories are only searched on Linux and other Unix systems")
+	}
+	home := t.TempDir()
+	system := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	t.Setenv("XDG_CONFIG_DIRS", "relative:"+system)
+	if path := Find(); path != "" {
+		t.Fatalf("Find() = %q with no configuration file", path)
+	}
+
+	path := filepath.Join(system, FileName)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(path, []byte("mode = copy\n"), 0644)
+	if found := Find(); found != path {
+		t.Fatalf("Find() = %q, want %q", found, path)
+	}
+	if _, err := Load(path); err == nil || !strings.HasPrefix(err.Error(), path+":1: ") {
+		t.Errorf("Load() error = %v, want one naming %s:1", err, path)
+	}
+}
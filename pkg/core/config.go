@@ -0,0 +1,290 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/screenshot-sorter/pkg/classify"
+	"github.com/screenshot-sorter/pkg/fileutils"
+	"github.com/screenshot-sorter/pkg/format"
+	"github.com/screenshot-sorter/pkg/ignore"
+	"github.com/screenshot-sorter/pkg/layout"
+	"github.com/screenshot-sorter/pkg/throttle"
+)
+
+// DefaultWorkers is the number of files processed at a time unless
+// Config.Workers says otherwise. Most of the time goes into waiting for
+// the file system, so it does not depend on the number of CPUs.
+const DefaultWorkers = 8
+
+// DefaultRate is the number of filesystem operations per second the
+// command line allows unless -rate says otherwise
+const DefaultRate = 100
+
+// Config holds the program configuration
+type Config struct {
+	DryRun    bool
+	Verbose   bool
+	Recursive bool
+	TargetDir string
+	SourceDir string
+	Version   bool
+	// Layout is the destination path template relative to the target
+	// directory, e.g. "{year}/{month:02}". Empty means layout.Default.
+	Layout string
+	// FilenamePatterns are regular expressions with named groups (year,
+	// month, day and optionally hour, minute, second, ampm) that extract
+	// the capture time from a file name. They take precedence over the
+	// built-in patterns and over filesystem times.
+	FilenamePatterns []string
+	// TimeSources lists the resolvers to consult, in order, by name.
+	// Empty means fileutils.DefaultTimeSources.
+	TimeSources []string
+	// TimeResolvers are additional resolvers supplied by library users.
+	// They can be referenced by name in TimeSources; any that are not
+	// referenced are tried before all other sources. They must be safe
+	// for concurrent use.
+	TimeResolvers []fileutils.TimeResolver
+	// TimeZone is the zone used for bucketing and collision suffixes: an
+	// IANA name such as "Europe/Berlin", "utc", or "local" (the default).
+	// Naive times from file names and metadata are read in this zone;
+	// times that carry their own offset are converted to it.
+	TimeZone string
+	// Mode selects how files reach the target: "move" (the default),
+	// "copy", "hardlink" or "reflink"
+	Mode string
+	// OnConflict is the ConflictPolicy applied when the destination name
+	// is taken. Empty means ConflictTimestamp.
+	OnConflict string
+	// RemoveDuplicates deletes a source file that is identical to the
+	// file already at its destination, instead of leaving it in place.
+	// It only applies in move mode.
+	RemoveDuplicates bool
+	// NoJournal turns off the undo journal that each run otherwise writes
+	// under <TargetDir>/.screenshot-sorter/journal
+	NoJournal bool
+	// Resume skips the files and directories that the checkpoint of an
+	// interrupted run shows as finished, after checking they are unchanged
+	Resume bool
+	// Workers is the number of files ProcessDirectory works on at a time.
+	// Zero means DefaultWorkers.
+	Workers int
+	// Rate limits filesystem operations per second, Burst is how many may
+	// start at once, and Bandwidth limits the bytes copied per second.
+	// Zero means no limit, and a Burst of 1. Adaptive lowers the rate
+	// while operations are slower than usual, e.g. on a busy network share.
+	Rate      float64
+	Burst     int
+	Bandwidth int64
+	Adaptive  bool
+	// Output selects how the end-of-run summary is printed: "text" (the
+	// default) or "json"
+	Output string
+	// Include, if set, limits the files processed to those whose path
+	// relative to the source matches one of these doublestar patterns.
+	// Exclude leaves out the files and directories that match one of its
+	// patterns; excluded directories are not descended into. .sortignore
+	// files found along the way leave out more; see package ignore.
+	Include []string
+	Exclude []string
+	// Formats are image formats supplied by library users, on top of
+	// format.Builtin. They are detected before the built-in formats, and
+	// one named like a built-in format replaces it.
+	Formats []format.Format
+	// FixExtensions gives files whose content is in another format than
+	// their extension says, or that have no extension, the extension of
+	// their format at the destination
+	FixExtensions bool
+	// Photos is the PhotoPolicy for camera photos, which package classify
+	// tells apart from screenshots. Empty means PhotosSort, which
+	// classifies nothing.
+	Photos string
+	// PhotoTarget is where PhotosSeparate sorts photos, with the same
+	// layout, mirroring the directories below TargetDir
+	PhotoTarget string
+	// ScreenshotThreshold is the score from which a file counts as a
+	// screenshot. Zero means classify.DefaultThreshold.
+	ScreenshotThreshold float64
+}
+
+// Validate checks the configuration for errors that would otherwise only
+// surface once processing has started
+func (c *Config) Validate() error {
+	if _, err := c.parse(); err != nil {
+		return err
+	}
+	switch c.Output {
+	case "", "text":
+	case "json":
+		// Verbose messages would corrupt the JSON on standard output
+		if c.Verbose {
+			return fmt.Errorf("-verbose cannot be combined with JSON output")
+		}
+	default:
+		return fmt.Errorf("invalid output format %q (valid: text, json)", c.Output)
+	}
+	return nil
+}
+
+// settings is what a processor works with once its Config is parsed
+type settings struct {
+	layout   *layout.Template
+	formats  *format.Registry
+	resolver *fileutils.ResolverChain
+	location *time.Location
+	mode     fileutils.TransferMode
+	conflict ConflictPolicy
+	workers  int
+	throttle *throttle.Throttle
+	filter   *ignore.Filter
+	photos   PhotoPolicy
+	// classifier tells photos from screenshots; nil under PhotosSort
+	classifier *classify.Classifier
+}
+
+// parse checks the configuration and turns it into settings
+func (c *Config) parse() (*settings, error) {
+	s := &settings{}
+	var err error
+	if s.layout, err = c.parseLayout(); err != nil {
+		return nil, err
+	}
+	if s.formats, err = c.parseFormats(); err != nil {
+		return nil, err
+	}
+	if s.resolver, err = c.parseTimeSources(s.formats); err != nil {
+		return nil, err
+	}
+	if s.location, err = c.parseTimeZone(); err != nil {
+		return nil, err
+	}
+	if s.mode, err = fileutils.ParseTransferMode(c.Mode); err != nil {
+		return nil, err
+	}
+	if s.conflict, err = ParseConflictPolicy(c.OnConflict); err != nil {
+		return nil, err
+	}
+	if s.workers, err = c.parseWorkers(); err != nil {
+		return nil, err
+	}
+	if s.throttle, err = c.parseThrottle(); err != nil {
+		return nil, err
+	}
+	if s.filter, err = c.parseFilter(); err != nil {
+		return nil, err
+	}
+	if s.photos, s.classifier, err = c.parsePhotos(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (c *Config) parseLayout() (*layout.Template, error) {
+	if c.Layout == "" {
+		return layout.Parse(layout.Default)
+	}
+	return layout.Parse(c.Layout)
+}
+
+func (c *Config) parseFilenamePatterns() ([]*fileutils.FilenamePattern, error) {
+	var patterns []*fileutils.FilenamePattern
+	for i, expr := range c.FilenamePatterns {
+		pattern, err := fileutils.NewFilenamePattern(fmt.Sprintf("custom-%d", i+1), expr)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, pattern)
+	}
+	return append(patterns, fileutils.BuiltinFilenamePatterns()...), nil
+}
+
+// parseTimeSources builds the resolver chain from TimeSources and
+// TimeResolvers, identifying the content of files with formats
+func (c *Config) parseTimeSources(formats *format.Registry) (*fileutils.ResolverChain, error) {
+	patterns, err := c.parseFilenamePatterns()
+	if err != nil {
+		return nil, err
+	}
+
+	custom := make(map[string]fileutils.TimeResolver)
+	for _, r := range c.TimeResolvers {
+		if _, dup := custom[r.Name()]; dup {
+			return nil, fmt.Errorf("duplicate time resolver %q", r.Name())
+		}
+		custom[r.Name()] = r
+	}
+
+	names := c.TimeSources
+	if len(names) == 0 {
+		names = fileutils.DefaultTimeSources
+	}
+
+	var resolvers []fileutils.TimeResolver
+	used := make(map[string]bool)
+	for _, name := range names {
+		if used[name] {
+			return nil, fmt.Errorf("time source %q listed more than once", name)
+		}
+		used[name] = true
+
+		if r, ok := custom[name]; ok {
+			resolvers = append(resolvers, r)
+			continue
+		}
+		r, err := fileutils.NewBuiltinResolver(name, patterns, formats)
+		if err != nil {
+			return nil, err
+		}
+		resolvers = append(resolvers, r)
+	}
+
+	// Custom resolvers that were not placed explicitly go first
+	var unplaced []fileutils.TimeResolver
+	for _, r := range c.TimeResolvers {
+		if !used[r.Name()] {
+			unplaced = append(unplaced, r)
+		}
+	}
+	return fileutils.NewResolverChain(append(unplaced, resolvers...)...), nil
+}
+
+func (c *Config) parseWorkers() (int, error) {
+	switch {
+	case c.Workers < 0:
+		return 0, fmt.Errorf("invalid number of workers %d", c.Workers)
+	case c.Workers == 0:
+		return DefaultWorkers, nil
+	}
+	return c.Workers, nil
+}
+
+func (c *Config) parseFilter() (*ignore.Filter, error) {
+	return ignore.NewFilter(c.Include, c.Exclude)
+}
+
+func (c *Config) parseFormats() (*format.Registry, error) {
+	return format.NewRegistry(c.Formats...)
+}
+
+func (c *Config) parseThrottle() (*throttle.Throttle, error) {
+	tc := throttle.Config{Rate: c.Rate, Burst: c.Burst, Bandwidth: c.Bandwidth, Adaptive: c.Adaptive}
+	if err := tc.Validate(); err != nil {
+		return nil, err
+	}
+	return throttle.New(tc), nil
+}
+
+func (c *Config) parseTimeZone() (*time.Location, error) {
+	switch strings.ToLower(c.TimeZone) {
+	case "", "local":
+		return time.Local, nil
+	case "utc":
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(c.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone %q: %w", c.TimeZone, err)
+	}
+	return loc, nil
+}
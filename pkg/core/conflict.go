@@ -0,0 +1,213 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/screenshot-sorter/pkg/fileutils"
+)
+
+// ConflictPolicy decides what happens when a file with the same name
+// already exists at the destination
+type ConflictPolicy string
+
+const (
+	// ConflictTimestamp appends the capture time, e.g. name_20230401_102233.png
+	ConflictTimestamp ConflictPolicy = "timestamp"
+	// ConflictSkip leaves the incoming file where it is
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictOverwrite replaces the existing file
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictCounter appends a counter, e.g. name_1.png
+	ConflictCounter ConflictPolicy = "counter"
+	// ConflictHash appends a short content hash, e.g. name_1a2b3c4d.png
+	ConflictHash ConflictPolicy = "hash"
+	// ConflictKeepNewer replaces the existing file only if the incoming
+	// one has a newer modification time
+	ConflictKeepNewer ConflictPolicy = "keep-newer"
+	// ConflictSkipIdentical skips files whose content matches the existing
+	// file and timestamps the name otherwise
+	ConflictSkipIdentical ConflictPolicy = "skip-if-identical"
+)
+
+// ConflictPolicies lists the valid policies
+var ConflictPolicies = []ConflictPolicy{
+	ConflictTimestamp, ConflictSkip, ConflictOverwrite, ConflictCounter,
+	ConflictHash, ConflictKeepNewer, ConflictSkipIdentical,
+}
+
+// maxNameAttempts bounds the search for a free destination name
+const maxNameAttempts = 10000
+
+// maxTransferAttempts bounds how often a file goes back through the policy
+// because its destination was taken while it was being transferred
+const maxTransferAttempts = 10
+
+// ParseConflictPolicy validates a policy name. The empty string means
+// ConflictTimestamp.
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	if s == "" {
+		return ConflictTimestamp, nil
+	}
+	for _, c := range ConflictPolicies {
+		if strings.EqualFold(s, string(c)) {
+			return c, nil
+		}
+	}
+	names := make([]string, len(ConflictPolicies))
+	for i, c := range ConflictPolicies {
+		names[i] = string(c)
+	}
+	return "", fmt.Errorf("invalid conflict policy %q (valid: %s)", s, strings.Join(names, ", "))
+}
+
+// conflictOutcome is the decision for one file
+type conflictOutcome struct {
+	// path is the destination to use, or for a duplicate the file it
+	// duplicates
+	path string
+	// skip leaves the source untouched
+	skip bool
+	// replace means path exists and will be overwritten
+	replace bool
+	// duplicate means the source is identical to an existing file
+	duplicate bool
+	// reason explains a skip in verbose output
+	reason string
+}
+
+// resolveConflict applies the configured policy to targetPath. When the
+// name is free it is used as is; otherwise the policy picks another name,
+// replaces the existing file or skips the source.
+func (p *ImageProcessor) resolveConflict(sourcePath, targetPath string, srcInfo os.FileInfo, fileTime time.Time) (conflictOutcome, error) {
+	existing, existingPath, err := p.statDest(targetPath)
+	if os.IsNotExist(err) {
+		return conflictOutcome{path: targetPath}, nil
+	}
+	if err != nil {
+		return conflictOutcome{}, err
+	}
+	if os.SameFile(srcInfo, existing) {
+		return conflictOutcome{skip: true, reason: "already at destination"}, nil
+	}
+
+	dir := filepath.Dir(targetPath)
+	name := filepath.Base(targetPath)
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	switch p.conflict {
+	case ConflictSkip:
+		return conflictOutcome{skip: true, reason: "destination exists"}, nil
+
+	case ConflictOverwrite:
+		return conflictOutcome{path: targetPath, replace: true}, nil
+
+	case ConflictKeepNewer:
+		if srcInfo.ModTime().After(existing.ModTime()) {
+			return conflictOutcome{path: targetPath, replace: true}, nil
+		}
+		return conflictOutcome{skip: true, reason: "destination is newer"}, nil
+
+	case ConflictSkipIdentical:
+		identical, err := sameContent(sourcePath, existingPath, srcInfo, existing)
+		if err != nil {
+			return conflictOutcome{}, err
+		}
+		if identical {
+			return conflictOutcome{path: targetPath, skip: true, duplicate: true, reason: "identical file exists"}, nil
+		}
+		return p.freeName(dir, timestampNames(base, ext, fileTime), "", srcInfo)
+
+	case ConflictCounter:
+		return p.freeName(dir, func(n int) string {
+			return fmt.Sprintf("%s_%d%s", base, n, ext)
+		}, "", srcInfo)
+
+	case ConflictHash:
+		sum, err := fileutils.HashFile(sourcePath)
+		if err != nil {
+			return conflictOutcome{}, fmt.Errorf("failed to hash %s: %w", sourcePath, err)
+		}
+		short := hex.EncodeToString(sum)[:8]
+		return p.freeName(dir, func(n int) string {
+			if n == 1 {
+				return fmt.Sprintf("%s_%s%s", base, short, ext)
+			}
+			return fmt.Sprintf("%s_%s_%d%s", base, short, n, ext)
+		}, sourcePath, srcInfo)
+	}
+
+	return p.freeName(dir, timestampNames(base, ext, fileTime), "", srcInfo)
+}
+
+// statDest returns the file occupying path and the path its content can
+// be read from. While a plan is being built, names claimed by earlier
+// operations count as taken by the files that will be placed there.
+func (p *ImageProcessor) statDest(path string) (os.FileInfo, string, error) {
+	if source, ok := p.claims[path]; ok {
+		fi, err := os.Stat(source)
+		return fi, source, err
+	}
+	fi, err := os.Stat(path)
+	return fi, path, err
+}
+
+// timestampNames generates name_20230401_102233.ext, then
+// name_20230401_102233_2.ext and so on
+func timestampNames(base, ext string, fileTime time.Time) func(int) string {
+	timestamp := fileTime.Format("20060102_150405")
+	return func(n int) string {
+		if n == 1 {
+			return fmt.Sprintf("%s_%s%s", base, timestamp, ext)
+		}
+		return fmt.Sprintf("%s_%s_%d%s", base, timestamp, n, ext)
+	}
+}
+
+// freeName tries candidate names until one is unused. When sourcePath is
+// set, an existing candidate with identical content ends the search as a
+// duplicate instead.
+func (p *ImageProcessor) freeName(dir string, candidate func(n int) string, sourcePath string, srcInfo os.FileInfo) (conflictOutcome, error) {
+	for n := 1; n <= maxNameAttempts; n++ {
+		path := filepath.Join(dir, candidate(n))
+		existing, existingPath, err := p.statDest(path)
+		if os.IsNotExist(err) {
+			return conflictOutcome{path: path}, nil
+		}
+		if err != nil {
+			return conflictOutcome{}, err
+		}
+		if sourcePath != "" {
+			identical, err := sameContent(sourcePath, existingPath, srcInfo, existing)
+			if err != nil {
+				return conflictOutcome{}, err
+			}
+			if identical {
+				return conflictOutcome{path: path, skip: true, duplicate: true, reason: "identical file exists"}, nil
+			}
+		}
+	}
+	return conflictOutcome{}, fmt.Errorf("no free name found in %s after %d attempts", dir, maxNameAttempts)
+}
+
+// sameContent compares sizes first and hashes only when they match
+func sameContent(a, b string, aInfo, bInfo os.FileInfo) (bool, error) {
+	if aInfo.Size() != bInfo.Size() {
+		return false, nil
+	}
+	aSum, err := fileutils.HashFile(a)
+	if err != nil {
+		return false, fmt.Errorf("failed to hash %s: %w", a, err)
+	}
+	bSum, err := fileutils.HashFile(b)
+	if err != nil {
+		return false, fmt.Errorf("failed to hash %s: %w", b, err)
+	}
+	return bytes.Equal(aSum, bSum), nil
+}
@@ -0,0 +1,248 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConflictPolicies(t *testing.T) {
+	// Set test environment to use ModTime
+	os.Setenv("SCREENSHOT_SORTER_TEST_USE_MODTIME", "1")
+	defer os.Unsetenv("SCREENSHOT_SORTER_TEST_USE_MODTIME")
+
+	fileTime := time.Date(2023, 4, 1, 10, 22, 33, 0, time.UTC)
+	older := fileTime.Add(-time.Hour)
+	newer := fileTime.Add(time.Hour)
+
+	tests := []struct {
+		name             string
+		policy           string
+		removeDuplicates bool
+		incoming         string
+		existing         string
+		existingTime     time.Time
+		// extra files already in the bucket besides test.png
+		occupied []string
+		// want lists the bucket's files and their contents afterwards
+		want         map[string]string
+		sourceRemain bool
+	}{
+		{
+			name: "timestamp", policy: "timestamp",
+			incoming: "new", existing: "old", existingTime: older,
+			want: map[string]string{"test.png": "old", "test_20230401_102233.png": "new"},
+		},
+		{
+			name: "timestamp loops until free", policy: "",
+			incoming: "new", existing: "old", existingTime: older,
+			occupied: []string{"test_20230401_102233.png", "test_20230401_102233_2.png"},
+			want: map[string]string{
+				"test.png": "old", "test_20230401_102233.png": "x", "test_20230401_102233_2.png": "x",
+				"test_20230401_102233_3.png": "new",
+			},
+		},
+		{
+			name: "skip", policy: "skip",
+			incoming: "new", existing: "old", existingTime: older,
+			want:         map[string]string{"test.png": "old"},
+			sourceRemain: true,
+		},
+		{
+			name: "overwrite", policy: "overwrite",
+			incoming: "new", existing: "old", existingTime: newer,
+			want: map[string]string{"test.png": "new"},
+		},
+		{
+			name: "counter", policy: "counter",
+			incoming: "new", existing: "old", existingTime: older,
+			occupied: []string{"test_1.png"},
+			want:     map[string]string{"test.png": "old", "test_1.png": "x", "test_2.png": "new"},
+		},
+		{
+			name: "keep-newer replaces older", policy: "keep-newer",
+			incoming: "new", existing: "old", existingTime: older,
+			want: map[string]string{"test.png": "new"},
+		},
+		{
+			name: "keep-newer keeps newer", policy: "keep-newer",
+			incoming: "new", existing: "old", existingTime: newer,
+			want:         map[string]string{"test.png": "old"},
+			sourceRemain: true,
+		},
+		{
+			name: "skip-if-identical leaves duplicate", policy: "skip-if-identical",
+			incoming: "same", existing: "same", existingTime: older,
+			want:         map[string]string{"test.png": "same"},
+			sourceRemain: true,
+		},
+		{
+			name: "skip-if-identical removes duplicate", policy: "skip-if-identical", removeDuplicates: true,
+			incoming: "same", existing: "same", existingTime: older,
+			want: map[string]string{"test.png": "same"},
+		},
+		{
+			name: "skip-if-identical renames different content", policy: "skip-if-identical",
+			incoming: "new!", existing: "old!", existingTime: older,
+			want: map[string]string{"test.png": "old!", "test_20230401_102233.png": "new!"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			bucket := filepath.Join(tempDir, "2023")
+			if err := os.MkdirAll(bucket, 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			existing := filepath.Join(bucket, "test.png")
+			writeTestFile(t, existing, tt.existing, tt.existingTime)
+			for _, name := range tt.occupied {
+				writeTestFile(t, filepath.Join(bucket, name), "x", older)
+			}
+			source := filepath.Join(tempDir, "test.png")
+			writeTestFile(t, source, tt.incoming, fileTime)
+
+			config := &Config{
+				TargetDir:        tempDir,
+				TimeZone:         "utc",
+				OnConflict:       tt.policy,
+				RemoveDuplicates: tt.removeDuplicates,
+			}
+			processor := NewImageProcessor(config)
+			fileInfo, err := os.Stat(source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := processor.ProcessFile(context.Background(), tempDir, tempDir, FileInfoDirEntry{info: fileInfo}); err != nil {
+				t.Fatalf("ProcessFile() error = %v", err)
+			}
+
+			got := readBucket(t, bucket)
+			if !equalContents(got, tt.want) {
+				t.Errorf("bucket = %v, want %v", got, tt.want)
+			}
+			_, err = os.Stat(source)
+			if remains := err == nil; remains != tt.sourceRemain {
+				t.Errorf("source remains = %v, want %v", remains, tt.sourceRemain)
+			}
+		})
+	}
+}
+
+func TestConflictHash(t *testing.T) {
+	tempDir := t.TempDir()
+	bucket := filepath.Join(tempDir, "2023")
+	if err := os.MkdirAll(bucket, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(bucket, "Screenshot_20230401-102233.png"), "old", time.Now())
+
+	config := &Config{TargetDir: tempDir, OnConflict: "hash"}
+	processor := NewImageProcessor(config)
+
+	// The first copy gets a hash suffix; an identical second copy is
+	// recognised as a duplicate of it
+	for i := 0; i < 2; i++ {
+		source := filepath.Join(tempDir, "Screenshot_20230401-102233.png")
+		writeTestFile(t, source, "new", time.Now())
+		fileInfo, err := os.Stat(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := processor.ProcessFile(context.Background(), tempDir, tempDir, FileInfoDirEntry{info: fileInfo}); err != nil {
+			t.Fatalf("ProcessFile() error = %v", err)
+		}
+	}
+
+	got := readBucket(t, bucket)
+	if len(got) != 2 {
+		t.Fatalf("bucket = %v, want 2 files", got)
+	}
+	for name := range got {
+		if name != "Screenshot_20230401-102233.png" && !strings.HasPrefix(name, "Screenshot_20230401-102233_") {
+			t.Errorf("unexpected file %s", name)
+		}
+		if name != "Screenshot_20230401-102233.png" && len(name) != len("Screenshot_20230401-102233_12345678.png") {
+			t.Errorf("hash suffix has unexpected length: %s", name)
+		}
+	}
+}
+
+func TestConflictOverwriteHardlink(t *testing.T) {
+	tempDir := t.TempDir()
+	bucket := filepath.Join(tempDir, "2023")
+	if err := os.MkdirAll(bucket, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(bucket, "Screenshot_20230401-102233.png"), "old", time.Now())
+	source := filepath.Join(tempDir, "Screenshot_20230401-102233.png")
+	writeTestFile(t, source, "new", time.Now())
+
+	config := &Config{TargetDir: tempDir, OnConflict: "overwrite", Mode: "hardlink"}
+	processor := NewImageProcessor(config)
+	fileInfo, err := os.Stat(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := processor.ProcessFile(context.Background(), tempDir, tempDir, FileInfoDirEntry{info: fileInfo}); err != nil {
+		t.Fatalf("ProcessFile() error = %v", err)
+	}
+
+	want := map[string]string{"Screenshot_20230401-102233.png": "new"}
+	if got := readBucket(t, bucket); !equalContents(got, want) {
+		t.Errorf("bucket = %v, want %v", got, want)
+	}
+}
+
+func TestParseConflictPolicy(t *testing.T) {
+	if p, err := ParseConflictPolicy(""); err != nil || p != ConflictTimestamp {
+		t.Errorf("ParseConflictPolicy(\"\") = %v, %v", p, err)
+	}
+	if _, err := ParseConflictPolicy("coin-flip"); err == nil {
+		t.Error("ParseConflictPolicy() should reject unknown policies")
+	}
+}
+
+func writeTestFile(t *testing.T, path, content string, mtime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readBucket(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[e.Name()] = string(data)
+	}
+	return files
+}
+
+func equalContents(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
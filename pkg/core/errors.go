@@ -0,0 +1,122 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/screenshot-sorter/pkg/ignore"
+)
+
+// Operations reported in ProcessError.Op, besides the transfer modes
+const (
+	OpReadDir    = "read directory"
+	OpStat       = "stat"
+	OpResolve    = "resolve conflict"
+	OpMkdir      = "create directory"
+	OpRemove     = "remove duplicate"
+	OpJournal    = "journal"
+	OpUndo       = "undo"
+	OpCheckpoint = "checkpoint"
+	OpIgnore     = "read " + ignore.FileName
+)
+
+// ErrInterrupted is returned when a run stops early because its context
+// was canceled. The error also matches the context's error.
+var ErrInterrupted = errors.New("run interrupted")
+
+// interrupted is the error of a run stopped by canceling ctx
+func interrupted(ctx context.Context) error {
+	return fmt.Errorf("%w: %w", ErrInterrupted, ctx.Err())
+}
+
+// canceled reports whether err comes from a canceled context, in which case
+// the file or directory it concerns was left untouched
+func canceled(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// ProcessError is a failure to process a single file or directory
+type ProcessError struct {
+	// Path is the file or directory that could not be processed
+	Path string
+	// Op is the step that failed: one of the Op constants or a transfer
+	// mode such as "move"
+	Op string
+	// Dest is the destination involved, if any
+	Dest string
+	Err  error
+}
+
+func (e *ProcessError) Error() string {
+	if e.Dest != "" {
+		return fmt.Sprintf("%s %s to %s: %v", e.Op, e.Path, e.Dest, e.Err)
+	}
+	return fmt.Sprintf("%s %s: %v", e.Op, e.Path, e.Err)
+}
+
+func (e *ProcessError) Unwrap() error {
+	return e.Err
+}
+
+// asProcessError returns err as a ProcessError, wrapping it with path and
+// op if it is not one already
+func asProcessError(err error, path, op string) *ProcessError {
+	var pe *ProcessError
+	if errors.As(err, &pe) {
+		return pe
+	}
+	return &ProcessError{Path: path, Op: op, Err: err}
+}
+
+// RunError is returned when a run completed but some files or directories
+// could not be processed. Errors that stop a run altogether, such as an
+// invalid configuration or an unreadable source directory, are returned
+// as they are instead.
+type RunError struct {
+	Errors []*ProcessError
+}
+
+func (e *RunError) Error() string {
+	if len(e.Errors) == 1 {
+		return e.Errors[0].Error()
+	}
+	return fmt.Sprintf("%d items could not be processed; first: %v", len(e.Errors), e.Errors[0])
+}
+
+// Unwrap lets errors.Is and errors.As look at every failure
+func (e *RunError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, pe := range e.Errors {
+		errs[i] = pe
+	}
+	return errs
+}
+
+// Summary lists the failures, one per line, up to limit of them; limit 0
+// lists them all
+func (e *RunError) Summary(limit int) string {
+	var b strings.Builder
+	if len(e.Errors) == 1 {
+		b.WriteString("1 item could not be processed:\n")
+	} else {
+		fmt.Fprintf(&b, "%d items could not be processed:\n", len(e.Errors))
+	}
+	for i, pe := range e.Errors {
+		if limit > 0 && i == limit {
+			fmt.Fprintf(&b, "  ... and %d more\n", len(e.Errors)-limit)
+			break
+		}
+		fmt.Fprintf(&b, "  %v\n", pe)
+	}
+	return b.String()
+}
+
+// runError returns nil for no failures and a RunError otherwise
+func runError(failures []*ProcessError) error {
+	if len(failures) == 0 {
+		return nil
+	}
+	return &RunError{Errors: failures}
+}
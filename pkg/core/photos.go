@@ -0,0 +1,85 @@
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/screenshot-sorter/pkg/classify"
+)
+
+// PhotoPolicy decides what happens to camera photos found among the
+// screenshots
+type PhotoPolicy string
+
+const (
+	// PhotosSort sorts photos with the screenshots. Nothing is classified.
+	PhotosSort PhotoPolicy = "sort"
+	// PhotosSkip leaves photos where they are
+	PhotosSkip PhotoPolicy = "skip"
+	// PhotosSeparate sorts photos into Config.PhotoTarget instead of the
+	// target
+	PhotosSeparate PhotoPolicy = "separate"
+)
+
+// PhotoPolicies lists the valid policies
+var PhotoPolicies = []PhotoPolicy{PhotosSort, PhotosSkip, PhotosSeparate}
+
+// ReasonPhoto is the skip reason of camera photos under PhotosSkip
+const ReasonPhoto = "camera photo"
+
+// ParsePhotoPolicy validates a policy name. The empty string means
+// PhotosSort.
+func ParsePhotoPolicy(s string) (PhotoPolicy, error) {
+	if s == "" {
+		return PhotosSort, nil
+	}
+	for _, p := range PhotoPolicies {
+		if strings.EqualFold(s, string(p)) {
+			return p, nil
+		}
+	}
+	names := make([]string, len(PhotoPolicies))
+	for i, p := range PhotoPolicies {
+		names[i] = string(p)
+	}
+	return "", fmt.Errorf("invalid photo policy %q (valid: %s)", s, strings.Join(names, ", "))
+}
+
+// parsePhotos checks the photo settings and returns the classifier to
+// use, which is nil unless photos are told apart
+func (c *Config) parsePhotos() (PhotoPolicy, *classify.Classifier, error) {
+	policy, err := ParsePhotoPolicy(c.Photos)
+	if err != nil {
+		return "", nil, err
+	}
+	switch {
+	case policy == PhotosSeparate && c.PhotoTarget == "":
+		return "", nil, fmt.Errorf("-photos separate needs -photo-target")
+	case policy != PhotosSeparate && c.PhotoTarget != "":
+		return "", nil, fmt.Errorf("-photo-target only applies to -photos separate")
+	case c.PhotoTarget != "" && c.TargetDir != "" && absPath(c.PhotoTarget) == absPath(c.TargetDir):
+		return "", nil, fmt.Errorf("-photo-target must be another directory than the target")
+	}
+	classifier, err := classify.New(c.ScreenshotThreshold)
+	if err != nil || policy == PhotosSort {
+		return policy, nil, err
+	}
+	return policy, classifier, nil
+}
+
+// photo reports whether the file was classified as a camera photo
+func (c *candidate) photo() bool {
+	return c.class != nil && c.class.Kind == classify.Photo
+}
+
+// photoDir is where camera photos go instead of targetDir: the photo
+// target, mirroring the subdirectory of root, the run's target, that
+// targetDir is
+func (p *ImageProcessor) photoDir(root, targetDir string) string {
+	rel, ok := within(root, targetDir)
+	if !ok {
+		return p.config.PhotoTarget
+	}
+	return filepath.Join(p.config.PhotoTarget, rel)
+}
@@ -0,0 +1,302 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// tasksPerWorker bounds the tasks in flight, and so the memory held by
+// the reorder buffers while a slow file holds up the ones after it
+const tasksPerWorker = 64
+
+// pipeline runs ProcessDirectory in four stages:
+//
+//  1. the walker lists directories and numbers what it finds in walk order;
+//  2. Workers resolvers stat each file and resolve its capture time;
+//  3. a dispatcher hands the files to movers in walk order, chaining each
+//     behind the previous file for the same destination directory, so
+//     conflicts in a directory are resolved exactly as a sequential run
+//     would resolve them, while different directories proceed in
+//     parallel, and journal each transfer as soon as it is done;
+//  4. the collector puts the results back in walk order, then prints the
+//     verbose output and updates the summary.
+//
+// Once ctx is canceled the walker stops and every file that has not started
+// its transfer fails with the context's error, draining the stages.
+type pipeline struct {
+	ctx     context.Context
+	p       *ImageProcessor
+	summary *Summary
+	// rootTarget is the target of the whole run, for bucket names
+	rootTarget string
+	resume     *resume
+}
+
+// task is a file moving through the pipeline, a directory the walker
+// could not read or left alone, or the end of a directory
+type task struct {
+	seq       int
+	sourceDir string
+	targetDir string
+	entry     os.DirEntry
+	// sorted is set when the file lies in sorted output, where its own
+	// directory can be the destination of other files
+	sorted    bool
+	candidate *candidate
+	// moved is closed when the mover is done with the task, releasing the
+	// next task for the same directories
+	moved  chan struct{}
+	log    *fileLog
+	result *FileResult
+	err    error
+	// resumed is set for files an earlier run finished
+	resumed bool
+	// excluded is set for files and directories the walk left out
+	excluded bool
+	// doneDir is set when the walker has reported everything in it
+	doneDir string
+}
+
+// run processes sourceDir and returns the errors that stop the walk
+func (pl *pipeline) run(sourceDir, targetDir string) error {
+	p := pl.p
+	workers := p.workers
+
+	// slots limits the tasks between the walker and the collector
+	slots := make(chan struct{}, workers*tasksPerWorker)
+	walked := make(chan *task, workers)
+	resolved := make(chan *task, workers)
+	done := make(chan *task, workers)
+
+	var walkErr error
+	go func() {
+		defer close(walked)
+		seq := 0
+		emit := func(t *task) {
+			slots <- struct{}{}
+			t.seq = seq
+			seq++
+			walked <- t
+		}
+		w := &walker{
+			ctx:        pl.ctx,
+			p:          p,
+			rootTarget: targetDir,
+			file: func(sourceDir, targetDir string, entry os.DirEntry, sorted bool) {
+				// Files this run put there are not sorted twice
+				if p.placed.has(filepath.Join(sourceDir, entry.Name())) {
+					return
+				}
+				if pl.resume.finished(pl.ctx, p, sourceDir, entry) {
+					emit(&task{resumed: true, log: p.newLog(pl.rootTarget)})
+					return
+				}
+				emit(&task{sourceDir: sourceDir, targetDir: targetDir, entry: entry, sorted: sorted, log: p.newLog(pl.rootTarget)})
+			},
+			skip: func(dir string) {
+				t := &task{log: p.newLog(pl.rootTarget)}
+				t.log.leftAlone(dir)
+				emit(t)
+			},
+			exclude: func(path string) {
+				t := &task{excluded: true, log: p.newLog(pl.rootTarget)}
+				t.log.printf("Excluding %s\n", path)
+				emit(t)
+			},
+			fail: func(err *ProcessError) {
+				emit(&task{err: err, log: p.newLog(pl.rootTarget)})
+			},
+			done: func(dir string) {
+				emit(&task{doneDir: dir, log: p.newLog(pl.rootTarget)})
+			},
+		}
+		walkErr = w.walk(sourceDir, targetDir)
+	}()
+
+	var resolvers sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		resolvers.Add(1)
+		go func() {
+			defer resolvers.Done()
+			for t := range walked {
+				if t.entry != nil {
+					pl.inspect(t)
+				}
+				resolved <- t
+			}
+		}()
+	}
+	go func() {
+		resolvers.Wait()
+		close(resolved)
+	}()
+
+	go pl.dispatch(resolved, done)
+
+	collected := newReorder()
+	for t := range done {
+		collected.push(t, func(t *task) {
+			pl.collect(t)
+			<-slots
+		})
+	}
+	return walkErr
+}
+
+// inspect resolves the capture time of a file
+func (pl *pipeline) inspect(t *task) {
+	t.candidate, t.err = pl.p.inspect(pl.ctx, t.sourceDir, t.entry, t.log)
+	if t.candidate == nil && t.err == nil {
+		t.result = unsupported(t.sourceDir, t.entry)
+	}
+}
+
+// dispatch starts a mover for each resolved file, in walk order, and closes
+// done once every task has been passed on
+func (pl *pipeline) dispatch(resolved <-chan *task, done chan<- *task) {
+	p := pl.p
+	movers := make(chan struct{}, p.workers)
+	// last holds the moved channel of the latest task for each directory
+	last := make(map[string]chan struct{})
+	var wg sync.WaitGroup
+
+	order := newReorder()
+	for t := range resolved {
+		order.push(t, func(t *task) {
+			if t.candidate == nil {
+				done <- t
+				return
+			}
+
+			dirs := []string{filepath.Dir(p.targetPath(t.candidate, pl.rootTarget, t.targetDir))}
+			// A file in sorted output may move out of a directory that other
+			// files are being sorted into
+			if t.sorted && t.sourceDir != dirs[0] {
+				dirs = append(dirs, t.sourceDir)
+			}
+			var wait []chan struct{}
+			for _, dir := range dirs {
+				if prev := last[dir]; prev != nil {
+					wait = append(wait, prev)
+				}
+			}
+			t.moved = make(chan struct{})
+			for _, dir := range dirs {
+				last[dir] = t.moved
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for _, prev := range wait {
+					<-prev
+				}
+				movers <- struct{}{}
+				t.result, t.err = p.processFile(pl.ctx, t.candidate, t.targetDir, t.log)
+				<-movers
+				close(t.moved)
+				done <- t
+			}()
+		})
+	}
+	wg.Wait()
+	close(done)
+}
+
+// collect reports a finished task
+func (pl *pipeline) collect(t *task) {
+	pl.p.flush(t.log)
+	switch {
+	case t.resumed:
+		pl.summary.Resumed++
+		return
+	case t.excluded:
+		pl.summary.Excluded++
+		return
+	case t.doneDir != "":
+		pl.resume.dir(pl.ctx, pl.p, t.doneDir)
+		return
+	case t.entry != nil:
+		pl.resume.file(t)
+	}
+
+	switch {
+	case canceled(t.err):
+		// The run was interrupted before the file was transferred
+	case t.err != nil:
+		if t.entry != nil {
+			pl.summary.Scanned++
+		}
+		path := t.sourceDir
+		if t.entry != nil {
+			path = filepath.Join(t.sourceDir, t.entry.Name())
+		}
+		pl.summary.Errors = append(pl.summary.Errors, asProcessError(t.err, path, "process"))
+	case t.result != nil:
+		pl.summary.add(t.result, pl.rootTarget)
+	}
+}
+
+// reorder releases tasks in sequence order
+type reorder struct {
+	next    int
+	pending map[int]*task
+}
+
+func newReorder() *reorder {
+	return &reorder{pending: make(map[int]*task)}
+}
+
+// push adds t and calls release for every task that is now next in line
+func (r *reorder) push(t *task, release func(*task)) {
+	r.pending[t.seq] = t
+	for {
+		t, ok := r.pending[r.next]
+		if !ok {
+			return
+		}
+		delete(r.pending, r.next)
+		r.next++
+		release(t)
+	}
+}
+
+// pathSet is a set of paths safe for concurrent use. A nil pathSet is
+// empty and ignores additions.
+type pathSet struct {
+	mu    sync.Mutex
+	paths map[string]bool
+}
+
+func newPathSet() *pathSet {
+	return &pathSet{paths: make(map[string]bool)}
+}
+
+func (s *pathSet) add(path string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paths[absPath(path)] = true
+}
+
+func (s *pathSet) remove(path string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.paths, absPath(path))
+}
+
+func (s *pathSet) has(path string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paths[absPath(path)]
+}
@@ -0,0 +1,162 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/screenshot-sorter/pkg/journal"
+)
+
+// TestProcessDirectoryWorkers sorts the same files with one worker and
+// with many. The names chosen for colliding files depend on the order in
+// which they are handled, and the journal lists every change, those in
+// the same bucket in order.
+func TestProcessDirectoryWorkers(t *testing.T) {
+	os.Setenv("SCREENSHOT_SORTER_TEST_USE_MODTIME", "1")
+	defer os.Unsetenv("SCREENSHOT_SORTER_TEST_USE_MODTIME")
+
+	type outcome struct {
+		bucket map[string]string
+		// entries holds the journaled changes of each bucket
+		entries map[string][]string
+		summary *Summary
+	}
+	run := func(workers int) outcome {
+		sourceDir := t.TempDir()
+		targetDir := t.TempDir()
+		fileTime := time.Date(2023, 4, 1, 10, 22, 33, 0, time.UTC)
+		if err := os.Mkdir(filepath.Join(targetDir, "2023"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		// Each file is renamed to the name of the next one: x.png to
+		// x_1.png, x_1.png to x_1_1.png and so on
+		writeTestFile(t, filepath.Join(targetDir, "2023", "x.png"), "old", fileTime)
+		name := "x"
+		for i := 0; i < 10; i++ {
+			writeTestFile(t, filepath.Join(sourceDir, name+".png"), fmt.Sprint(i), fileTime)
+			name += "_1"
+		}
+		// Other files go to other buckets in parallel
+		for i := 0; i < 30; i++ {
+			writeTestFile(t, filepath.Join(sourceDir, fmt.Sprintf("y%02d.png", i)), "y", fileTime.AddDate(-1-i%3, 0, 0))
+		}
+
+		processor := NewImageProcessor(&Config{
+			TargetDir: targetDir, TimeZone: "utc", OnConflict: string(ConflictCounter), Workers: workers,
+		})
+		s, err := processor.ProcessDirectory(context.Background(), sourceDir, targetDir)
+		if err != nil {
+			t.Fatalf("ProcessDirectory() with %d workers error = %v", workers, err)
+		}
+		entries, err := journal.Read(targetDir, processor.RunID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		order := make(map[string][]string)
+		for _, e := range entries {
+			src, _ := filepath.Rel(sourceDir, e.Source)
+			dst, _ := filepath.Rel(targetDir, e.Dest)
+			order[filepath.Dir(dst)] = append(order[filepath.Dir(dst)], src+" -> "+dst)
+		}
+		return outcome{readBucket(t, filepath.Join(targetDir, "2023")), order, s}
+	}
+
+	want := run(1)
+	if len(want.entries["2023"]) != 10 || want.summary.Transferred != 40 || want.summary.Conflicts != 10 || want.bucket["x_1_1_1.png"] != "2" {
+		t.Fatalf("sequential run journaled %d entries in 2023 with %d conflicts", len(want.entries["2023"]), want.summary.Conflicts)
+	}
+	for attempt := 0; attempt < 5; attempt++ {
+		got := run(16)
+		if !equalContents(got.bucket, want.bucket) {
+			t.Errorf("bucket = %v, want %v", got.bucket, want.bucket)
+		}
+		for bucket, entries := range want.entries {
+			if fmt.Sprint(got.entries[bucket]) != fmt.Sprint(entries) {
+				t.Fatalf("journal of %s = %v, want %v", bucket, got.entries[bucket], entries)
+			}
+		}
+		if got.summary.Transferred != want.summary.Transferred || got.summary.Conflicts != want.summary.Conflicts {
+			t.Errorf("summary = %+v, want %+v", got.summary, want.summary)
+		}
+	}
+}
+
+// TestMoverJournals checks that a transfer is in the journal once the
+// mover is done with it, before the collector reports it, so a run killed
+// while files wait to be reported in walk order can still be undone.
+func TestMoverJournals(t *testing.T) {
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+	writeTestFile(t, filepath.Join(sourceDir, "test.png"), "data", time.Now())
+	entries, err := os.ReadDir(sourceDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewImageProcessor(&Config{TargetDir: targetDir, TimeZone: "utc"})
+	l := p.newLog(targetDir)
+	c, err := p.inspect(context.Background(), sourceDir, entries[0], l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.processFile(context.Background(), c, targetDir, l); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := journal.Read(targetDir, p.RunID()); err != nil || len(got) != 1 {
+		t.Errorf("journal before the collector = %+v, %v; want the transfer", got, err)
+	}
+}
+
+// TestProcessDirectoryInterrupted cancels a throttled run part way. Every
+// file must end up either where it was or in the target, journaled, and
+// the summary must count exactly the files that moved.
+func TestProcessDirectoryInterrupted(t *testing.T) {
+	os.Setenv("SCREENSHOT_SORTER_TEST_USE_MODTIME", "1")
+	defer os.Unsetenv("SCREENSHOT_SORTER_TEST_USE_MODTIME")
+
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+	fileTime := time.Date(2023, 4, 1, 10, 22, 33, 0, time.UTC)
+	const files = 40
+	for i := 0; i < files; i++ {
+		writeTestFile(t, filepath.Join(sourceDir, fmt.Sprintf("f%02d.png", i)), fmt.Sprint(i), fileTime)
+	}
+
+	processor := NewImageProcessor(&Config{TargetDir: targetDir, TimeZone: "utc", Workers: 4, Rate: 100})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+	s, err := processor.ProcessDirectory(ctx, sourceDir, targetDir)
+	if !errors.Is(err, ErrInterrupted) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("ProcessDirectory() error = %v, want ErrInterrupted", err)
+	}
+	if s == nil || !s.Interrupted {
+		t.Fatalf("summary = %+v, want Interrupted", s)
+	}
+
+	moved := readBucket(t, filepath.Join(targetDir, "2023"))
+	left := readBucket(t, sourceDir)
+	if len(moved)+len(left) != files {
+		t.Errorf("%d files moved and %d left, want %d in all", len(moved), len(left), files)
+	}
+	if len(moved) == 0 || len(left) == 0 {
+		t.Errorf("%d files moved and %d left, want the run to stop part way", len(moved), len(left))
+	}
+	if s.Transferred != len(moved) || len(s.Errors) != 0 {
+		t.Errorf("summary counts %d transferred and %d errors, %d files moved", s.Transferred, len(s.Errors), len(moved))
+	}
+	entries, err := journal.Read(targetDir, processor.RunID())
+	if err != nil || len(entries) != len(moved) {
+		t.Errorf("journal has %d entries, %v; %d files moved", len(entries), err, len(moved))
+	}
+}
+
+func TestConfig_InvalidWorkers(t *testing.T) {
+	if err := (&Config{Workers: -1}).Validate(); err == nil {
+		t.Error("Validate() should reject a negative number of workers")
+	}
+}
@@ -0,0 +1,436 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/screenshot-sorter/pkg/fileutils"
+)
+
+// planVersion is the format version written to plan files
+const planVersion = 1
+
+// Action is what an operation does to its source file
+type Action string
+
+const (
+	// ActionMove, ActionCopy, ActionHardlink and ActionReflink transfer the
+	// source to Dest with the transfer mode of the same name
+	ActionMove     = Action(fileutils.ModeMove)
+	ActionCopy     = Action(fileutils.ModeCopy)
+	ActionHardlink = Action(fileutils.ModeHardlink)
+	ActionReflink  = Action(fileutils.ModeReflink)
+	// ActionRemove deletes the source as a duplicate of Dest
+	ActionRemove Action = "remove"
+	// ActionSkip leaves the source alone
+	ActionSkip Action = "skip"
+)
+
+// Decision records how the conflict policy treated an operation
+type Decision string
+
+const (
+	// DecisionRenamed means the planned name was taken and another was chosen
+	DecisionRenamed Decision = "renamed"
+	// DecisionReplace means the file at Dest is overwritten
+	DecisionReplace Decision = "replace"
+	// DecisionSkip means the policy left the source where it is
+	DecisionSkip Decision = "skip"
+	// DecisionDuplicate means Dest already has identical content
+	DecisionDuplicate Decision = "duplicate"
+)
+
+// FileState is the size and modification time a file had when it was
+// planned for
+type FileState struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// Operation is one planned change
+type Operation struct {
+	Action Action `json:"action"`
+	Source string `json:"source"`
+	Dest   string `json:"dest"`
+	// Size and ModTime describe the source when it was planned
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	// Time is the resolved capture time and TimeSource the resolver that
+	// produced it
+	Time       time.Time `json:"time"`
+	TimeSource string    `json:"time_source"`
+	Fallback   bool      `json:"fallback,omitempty"`
+	// Format is the format of the source, and Mismatch is set when its
+	// extension belongs to another format
+	Format   string `json:"format,omitempty"`
+	Mismatch bool   `json:"mismatch,omitempty"`
+	// Kind is "screenshot" or "photo" and Score the confidence that the
+	// file is a screenshot, when files are classified
+	Kind  string  `json:"kind,omitempty"`
+	Score float64 `json:"score,omitempty"`
+	// Decision is empty when the destination name was free
+	Decision Decision `json:"decision,omitempty"`
+	Reason   string   `json:"reason,omitempty"`
+	// Existing describes the file at Dest that is replaced or duplicated
+	Existing *FileState `json:"existing,omitempty"`
+}
+
+func (op Operation) resolved() fileutils.ResolvedTime {
+	return fileutils.ResolvedTime{Time: op.Time, Source: op.TimeSource, Fallback: op.Fallback}
+}
+
+// Plan is the list of operations a run would perform, saved for review
+// and applied later
+type Plan struct {
+	Version     int         `json:"version"`
+	Created     time.Time   `json:"created"`
+	SourceDir   string      `json:"source"`
+	TargetDir   string      `json:"target"`
+	PhotoTarget string      `json:"photo_target,omitempty"`
+	Mode        string      `json:"mode"`
+	OnConflict  string      `json:"on_conflict"`
+	Layout      string      `json:"layout"`
+	Operations  []Operation `json:"operations"`
+}
+
+// Roots returns the directories the plan writes to: its target and, if
+// photos go elsewhere, the photo target
+func (plan *Plan) Roots() []string {
+	if plan.PhotoTarget == "" {
+		return []string{plan.TargetDir}
+	}
+	return []string{plan.TargetDir, plan.PhotoTarget}
+}
+
+// ReadPlan loads a plan written by Plan.WriteFile
+func ReadPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("failed to parse plan %s: %w", path, err)
+	}
+	if plan.Version != planVersion {
+		return nil, fmt.Errorf("plan %s has unsupported version %d", path, plan.Version)
+	}
+	for i, op := range plan.Operations {
+		switch op.Action {
+		case ActionMove, ActionCopy, ActionHardlink, ActionReflink, ActionRemove, ActionSkip:
+		default:
+			return nil, fmt.Errorf("plan %s: operation %d has unknown action %q", path, i+1, op.Action)
+		}
+		if !filepath.IsAbs(op.Source) || !filepath.IsAbs(op.Dest) {
+			return nil, fmt.Errorf("plan %s: operation %d does not use absolute paths", path, i+1)
+		}
+		// Apply locks the roots, so nothing may be written elsewhere
+		inRoot := false
+		for _, root := range plan.Roots() {
+			if _, ok := within(root, op.Dest); ok {
+				inRoot = true
+			}
+		}
+		if !inRoot {
+			return nil, fmt.Errorf("plan %s: operation %d writes outside the plan's target", path, i+1)
+		}
+	}
+	return &plan, nil
+}
+
+// Write encodes the plan as indented JSON
+func (plan *Plan) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(plan)
+}
+
+// WriteFile saves the plan, replacing path atomically
+func (plan *Plan) WriteFile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if err := plan.Write(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// PlanDirectory works out what ProcessDirectory would do without changing
+// anything. Every path in the plan is absolute. Files that cannot be
+// planned are left out of the plan and returned in a *RunError along
+// with it. An incomplete plan is of no use, so if ctx is canceled
+// PlanDirectory returns no plan and an error matching ErrInterrupted.
+func (p *ImageProcessor) PlanDirectory(ctx context.Context, sourceDir, targetDir string) (*Plan, error) {
+	if p.initErr != nil {
+		return nil, p.initErr
+	}
+	if targetDir == "" {
+		targetDir = sourceDir
+	}
+	sourceDir, targetDir = absPath(sourceDir), absPath(targetDir)
+
+	plan := &Plan{
+		Version:    planVersion,
+		Created:    time.Now(),
+		SourceDir:  sourceDir,
+		TargetDir:  targetDir,
+		Mode:       string(p.mode),
+		OnConflict: string(p.conflict),
+		Layout:     p.layout.String(),
+		Operations: []Operation{},
+	}
+	if p.photos == PhotosSeparate {
+		plan.PhotoTarget = absPath(p.config.PhotoTarget)
+	}
+
+	p.claims = make(map[string]string)
+	defer func() { p.claims = nil }()
+
+	var failures []*ProcessError
+	w := &walker{
+		ctx:        ctx,
+		p:          p,
+		rootTarget: targetDir,
+		file: func(sourceDir, targetDir string, entry os.DirEntry, _ bool) {
+			if err := p.planEntry(ctx, plan, sourceDir, targetDir, entry); err != nil && !canceled(err) {
+				failures = append(failures, asProcessError(err, filepath.Join(sourceDir, entry.Name()), "process"))
+			}
+		},
+		skip: func(dir string) {
+			l := p.newLog(targetDir)
+			l.leftAlone(dir)
+			p.flush(l)
+		},
+		fail: func(err *ProcessError) { failures = append(failures, err) },
+	}
+	if err := w.walk(sourceDir, targetDir); err != nil && !canceled(err) {
+		return nil, err
+	}
+	if ctx.Err() != nil {
+		return nil, interrupted(ctx)
+	}
+	return plan, runError(failures)
+}
+
+// planEntry adds the operation for one directory entry to plan
+func (p *ImageProcessor) planEntry(ctx context.Context, plan *Plan, sourceDir, targetDir string, entry os.DirEntry) error {
+	l := p.newLog(plan.TargetDir)
+	defer p.flush(l)
+	c, err := p.inspect(ctx, sourceDir, entry, l)
+	if c == nil || err != nil {
+		return err
+	}
+	op, err := p.planFile(ctx, c, plan.TargetDir, targetDir)
+	if err != nil {
+		return err
+	}
+	if op.Action != ActionSkip && op.Action != ActionRemove {
+		p.claims[op.Dest] = op.Source
+	}
+	plan.Operations = append(plan.Operations, op)
+	return nil
+}
+
+// DriftPolicy decides what Apply does with operations whose files changed
+// after the plan was made
+type DriftPolicy string
+
+const (
+	// DriftStop checks the whole plan before changing anything and applies
+	// nothing if any operation has drifted
+	DriftStop DriftPolicy = "stop"
+	// DriftSkip applies every operation that still matches and skips the
+	// rest
+	DriftSkip DriftPolicy = "skip"
+)
+
+// ParseDriftPolicy validates a policy name. The empty string means
+// DriftStop.
+func ParseDriftPolicy(s string) (DriftPolicy, error) {
+	switch DriftPolicy(strings.ToLower(s)) {
+	case "", DriftStop:
+		return DriftStop, nil
+	case DriftSkip:
+		return DriftSkip, nil
+	}
+	return "", fmt.Errorf("invalid drift policy %q (valid: stop, skip)", s)
+}
+
+// ErrDrift is returned by Apply when it stops because the file system no
+// longer matches the plan
+var ErrDrift = errors.New("plan no longer matches the file system")
+
+// Drift is an operation that no longer matches the file system
+type Drift struct {
+	Operation Operation
+	Reason    string
+}
+
+// ApplyResult summarizes an applied plan
+type ApplyResult struct {
+	// Applied counts the operations carried out
+	Applied int
+	// Skipped counts the operations the plan itself skips
+	Skipped int
+	// Drifted lists the operations that no longer matched the file system
+	Drifted []Drift
+	// Failed lists operations that matched but could not be carried out
+	Failed []*ProcessError
+}
+
+// Apply carries out a saved plan exactly, without consulting the layout,
+// time sources or conflict policy again. Before each operation it checks
+// that the source still has the planned size and modification time and
+// that the destination is as the plan expects; operations that fail the
+// check are handled according to onDrift. Operations that fail for other
+// reasons do not stop the run; they are returned in a *RunError.
+//
+// Canceling ctx stops Apply after the operation in progress; the result
+// then covers the operations carried out so far and the error matches
+// ErrInterrupted.
+func (p *ImageProcessor) Apply(ctx context.Context, plan *Plan, onDrift DriftPolicy) (*ApplyResult, error) {
+	if p.initErr != nil {
+		return nil, p.initErr
+	}
+	result := &ApplyResult{}
+
+	if onDrift == DriftStop {
+		// Later operations may depend on the destinations of earlier ones
+		created := make(map[string]string)
+		for _, op := range plan.Operations {
+			if op.Action == ActionSkip {
+				continue
+			}
+			if ctx.Err() != nil {
+				return result, interrupted(ctx)
+			}
+			if reason := checkDrift(op, created); reason != "" {
+				result.Drifted = append(result.Drifted, Drift{Operation: op, Reason: reason})
+			}
+			if op.Action != ActionRemove {
+				created[op.Dest] = op.Source
+			}
+		}
+		if len(result.Drifted) > 0 {
+			return result, fmt.Errorf("%w: %d of %d operations", ErrDrift, len(result.Drifted), len(plan.Operations))
+		}
+	}
+
+	for _, op := range plan.Operations {
+		if op.Action == ActionSkip {
+			result.Skipped++
+			continue
+		}
+
+		var reason string
+		err := p.throttle.Do(ctx, func() error {
+			reason = checkDrift(op, nil)
+			if reason == "" && op.Action == ActionRemove {
+				reason = checkDuplicate(op)
+			}
+			return nil
+		})
+		if err != nil {
+			return result, errors.Join(interrupted(ctx), runError(result.Failed))
+		}
+		if reason == "" {
+			l := p.newLog(plan.TargetDir)
+			done, err := p.execute(ctx, op, l)
+			p.flush(l)
+			switch {
+			case canceled(err):
+				return result, errors.Join(interrupted(ctx), runError(result.Failed))
+			case errors.Is(err, fs.ErrExist):
+				reason = "destination is now occupied"
+			case err != nil:
+				result.Failed = append(result.Failed, asProcessError(err, op.Source, string(op.Action)))
+				continue
+			default:
+				if done {
+					result.Applied++
+				}
+				continue
+			}
+		}
+
+		result.Drifted = append(result.Drifted, Drift{Operation: op, Reason: reason})
+		if onDrift == DriftStop {
+			return result, fmt.Errorf("%w: %s: %s", ErrDrift, op.Source, reason)
+		}
+		if p.config.Verbose {
+			fmt.Printf("Skipping %s: %s\n", op.Source, reason)
+		}
+	}
+	return result, runError(result.Failed)
+}
+
+// checkDrift compares the file system with what op expects and describes
+// the first difference. created maps destinations of operations that
+// have not run yet to the sources that will be placed there.
+func checkDrift(op Operation, created map[string]string) string {
+	fi, err := os.Lstat(op.Source)
+	if err != nil {
+		return "source is missing"
+	}
+	if fi.Size() != op.Size || !fi.ModTime().Equal(op.ModTime) {
+		return "source changed since the plan was made"
+	}
+
+	dest, err := stateOf(op.Dest, created)
+	if op.Existing == nil {
+		if err == nil {
+			return "destination is now occupied"
+		}
+		return ""
+	}
+	if err != nil {
+		return "destination is missing"
+	}
+	if dest.Size() != op.Existing.Size || !dest.ModTime().Equal(op.Existing.ModTime) {
+		return "destination changed since the plan was made"
+	}
+	return ""
+}
+
+// stateOf stats path, or the file that an earlier operation will place
+// there
+func stateOf(path string, created map[string]string) (os.FileInfo, error) {
+	if source, ok := created[path]; ok {
+		return os.Lstat(source)
+	}
+	return os.Lstat(path)
+}
+
+// checkDuplicate makes sure a source is still identical to its destination
+// before it is deleted
+func checkDuplicate(op Operation) string {
+	srcInfo, err := os.Stat(op.Source)
+	if err != nil {
+		return "source is missing"
+	}
+	destInfo, err := os.Stat(op.Dest)
+	if err != nil {
+		return "destination is missing"
+	}
+	identical, err := sameContent(op.Source, op.Dest, srcInfo, destInfo)
+	if err != nil || !identical {
+		return "source is no longer identical to the destination"
+	}
+	return ""
+}
@@ -0,0 +1,244 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// setupPlanTest creates a source directory whose test.png collides with a
+// file already in the target, and whose second file collides with the
+// name test.png is renamed to
+func setupPlanTest(t *testing.T) (sourceDir, targetDir string, fileTime time.Time) {
+	t.Helper()
+	os.Setenv("SCREENSHOT_SORTER_TEST_USE_MODTIME", "1")
+	t.Cleanup(func() { os.Unsetenv("SCREENSHOT_SORTER_TEST_USE_MODTIME") })
+
+	fileTime = time.Date(2023, 4, 1, 10, 22, 33, 0, time.UTC)
+	sourceDir = t.TempDir()
+	targetDir = t.TempDir()
+	if err := os.MkdirAll(filepath.Join(targetDir, "2023"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(targetDir, "2023", "test.png"), "old", fileTime)
+	writeTestFile(t, filepath.Join(sourceDir, "test.png"), "a", fileTime)
+	writeTestFile(t, filepath.Join(sourceDir, "test_20230401_102233.png"), "b", fileTime)
+	writeTestFile(t, filepath.Join(sourceDir, "notes.txt"), "ignored", fileTime)
+	return sourceDir, targetDir, fileTime
+}
+
+func TestPlanDirectory(t *testing.T) {
+	sourceDir, targetDir, fileTime := setupPlanTest(t)
+
+	processor := NewImageProcessor(&Config{TargetDir: targetDir, TimeZone: "utc"})
+	plan, err := processor.PlanDirectory(context.Background(), sourceDir, targetDir)
+	if err != nil {
+		t.Fatalf("PlanDirectory() error = %v", err)
+	}
+
+	// Nothing is touched while planning
+	if got := readBucket(t, sourceDir); len(got) != 3 {
+		t.Errorf("source changed while planning: %v", got)
+	}
+
+	bucket := filepath.Join(targetDir, "2023")
+	want := []Operation{
+		{Action: ActionMove, Source: filepath.Join(sourceDir, "test.png"),
+			Dest: filepath.Join(bucket, "test_20230401_102233.png"), Decision: DecisionRenamed},
+		// The name planned for test.png counts as taken
+		{Action: ActionMove, Source: filepath.Join(sourceDir, "test_20230401_102233.png"),
+			Dest: filepath.Join(bucket, "test_20230401_102233_20230401_102233.png"), Decision: DecisionRenamed},
+	}
+	if len(plan.Operations) != len(want) {
+		t.Fatalf("plan has %d operations, want %d: %+v", len(plan.Operations), len(want), plan.Operations)
+	}
+	for i, op := range plan.Operations {
+		w := want[i]
+		if op.Action != w.Action || op.Source != w.Source || op.Dest != w.Dest || op.Decision != w.Decision {
+			t.Errorf("operation %d = %+v, want %+v", i, op, w)
+		}
+		if !op.Time.Equal(fileTime) || op.TimeSource == "" || !op.Fallback || op.Size != 1 {
+			t.Errorf("operation %d time = %v from %s (fallback %v), size %d", i, op.Time, op.TimeSource, op.Fallback, op.Size)
+		}
+	}
+}
+
+func TestApplyPlan(t *testing.T) {
+	sourceDir, targetDir, _ := setupPlanTest(t)
+
+	processor := NewImageProcessor(&Config{TargetDir: targetDir, TimeZone: "utc"})
+	plan, err := processor.PlanDirectory(context.Background(), sourceDir, targetDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err := plan.WriteFile(path); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	loaded, err := ReadPlan(path)
+	if err != nil {
+		t.Fatalf("ReadPlan() error = %v", err)
+	}
+
+	result, err := NewImageProcessor(&Config{TargetDir: targetDir}).Apply(context.Background(), loaded, DriftStop)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if result.Applied != 2 || len(result.Drifted) != 0 || len(result.Failed) != 0 {
+		t.Errorf("Apply() = %+v", result)
+	}
+	want := map[string]string{
+		"test.png":                 "old",
+		"test_20230401_102233.png": "a",
+		"test_20230401_102233_20230401_102233.png": "b",
+	}
+	if got := readBucket(t, filepath.Join(targetDir, "2023")); !equalContents(got, want) {
+		t.Errorf("bucket = %v, want %v", got, want)
+	}
+}
+
+func TestApplyPlanSkippedPhotos(t *testing.T) {
+	sourceDir, targetDir, _ := setupPlanTest(t)
+	photo := filepath.Join(sourceDir, "IMG_0001.heic")
+	writeTestFile(t, photo, "\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic", time.Now())
+
+	processor := NewImageProcessor(&Config{TargetDir: targetDir, TimeZone: "utc", Photos: "skip"})
+	plan, err := processor.PlanDirectory(context.Background(), sourceDir, targetDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err := plan.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := ReadPlan(path)
+	if err != nil {
+		t.Fatalf("ReadPlan() error = %v", err)
+	}
+
+	result, err := NewImageProcessor(&Config{TargetDir: targetDir}).Apply(context.Background(), loaded, DriftStop)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if result.Applied != 2 || result.Skipped != 1 {
+		t.Errorf("Apply() = %+v, want 2 applied and the photo skipped", result)
+	}
+	if _, err := os.Stat(photo); err != nil {
+		t.Errorf("skipped photo moved: %v", err)
+	}
+}
+
+func TestApplyPlanPhotoTarget(t *testing.T) {
+	sourceDir, targetDir, _ := setupPlanTest(t)
+	photoDir := filepath.Join(t.TempDir(), "photos")
+	writeTestFile(t, filepath.Join(sourceDir, "IMG_0001.heic"), "\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic", time.Now())
+
+	config := &Config{TargetDir: targetDir, TimeZone: "utc", Photos: "separate", PhotoTarget: photoDir}
+	plan, err := NewImageProcessor(config).PlanDirectory(context.Background(), sourceDir, targetDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Apply locks every root the plan writes to
+	if got := plan.Roots(); len(got) != 2 || got[0] != targetDir || got[1] != photoDir {
+		t.Errorf("Roots() = %v, want %s and %s", got, targetDir, photoDir)
+	}
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err := plan.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := ReadPlan(path)
+	if err != nil {
+		t.Fatalf("ReadPlan() error = %v", err)
+	}
+	if loaded.PhotoTarget != photoDir {
+		t.Errorf("PhotoTarget = %q, want %q", loaded.PhotoTarget, photoDir)
+	}
+}
+
+func TestApplyPlanDrift(t *testing.T) {
+	for _, policy := range []DriftPolicy{DriftStop, DriftSkip} {
+		t.Run(string(policy), func(t *testing.T) {
+			sourceDir, targetDir, fileTime := setupPlanTest(t)
+			processor := NewImageProcessor(&Config{TargetDir: targetDir, TimeZone: "utc"})
+			plan, err := processor.PlanDirectory(context.Background(), sourceDir, targetDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The second file is edited after planning
+			writeTestFile(t, filepath.Join(sourceDir, "test_20230401_102233.png"), "edited", fileTime)
+
+			result, err := NewImageProcessor(&Config{TargetDir: targetDir}).Apply(context.Background(), plan, policy)
+			if len(result.Drifted) != 1 || result.Drifted[0].Operation.Source != filepath.Join(sourceDir, "test_20230401_102233.png") {
+				t.Fatalf("Drifted = %+v", result.Drifted)
+			}
+
+			bucket := readBucket(t, filepath.Join(targetDir, "2023"))
+			switch policy {
+			case DriftStop:
+				if !errors.Is(err, ErrDrift) {
+					t.Errorf("Apply() error = %v, want ErrDrift", err)
+				}
+				if result.Applied != 0 || len(bucket) != 1 {
+					t.Errorf("stop policy applied operations: %+v, bucket %v", result, bucket)
+				}
+			case DriftSkip:
+				if err != nil {
+					t.Errorf("Apply() error = %v", err)
+				}
+				if result.Applied != 1 || bucket["test_20230401_102233.png"] != "a" {
+					t.Errorf("skip policy should apply the rest: %+v, bucket %v", result, bucket)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyPlanOccupied(t *testing.T) {
+	sourceDir, targetDir, fileTime := setupPlanTest(t)
+	processor := NewImageProcessor(&Config{TargetDir: targetDir, TimeZone: "utc"})
+	plan, err := processor.PlanDirectory(context.Background(), sourceDir, targetDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Someone else takes the planned name
+	occupied := filepath.Join(targetDir, "2023", "test_20230401_102233.png")
+	writeTestFile(t, occupied, "theirs", fileTime)
+
+	result, err := NewImageProcessor(&Config{TargetDir: targetDir}).Apply(context.Background(), plan, DriftSkip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Drifted) != 1 || result.Drifted[0].Reason != "destination is now occupied" {
+		t.Errorf("Drifted = %+v", result.Drifted)
+	}
+	if data, _ := os.ReadFile(occupied); string(data) != "theirs" {
+		t.Errorf("occupied destination overwritten with %q", data)
+	}
+}
+
+func TestReadPlanInvalid(t *testing.T) {
+	dir := t.TempDir()
+	for name, contents := range map[string]string{
+		"version.json":  `{"version": 99, "operations": []}`,
+		"action.json":   `{"version": 1, "operations": [{"action": "shred", "source": "/a", "dest": "/b"}]}`,
+		"relative.json": `{"version": 1, "operations": [{"action": "move", "source": "a", "dest": "/b"}]}`,
+		"outside.json":  `{"version": 1, "target": "/t", "operations": [{"action": "move", "source": "/a", "dest": "/b"}]}`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadPlan(path); err == nil {
+			t.Errorf("ReadPlan(%s) should fail", name)
+		}
+	}
+
+	if _, err := ParseDriftPolicy("ignore"); err == nil {
+		t.Error("ParseDriftPolicy() should reject unknown policies")
+	}
+}
@@ -2,137 +2,766 @@ package core
 
 import (
 	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/screenshot-sorter/pkg/checkpoint"
+	"github.com/screenshot-sorter/pkg/classify"
 	"github.com/screenshot-sorter/pkg/fileutils"
-	"golang.org/x/time/rate"
+	"github.com/screenshot-sorter/pkg/format"
+	"github.com/screenshot-sorter/pkg/ignore"
+	"github.com/screenshot-sorter/pkg/journal"
 )
 
 // ImageProcessor handles the core image processing functionality
 type ImageProcessor struct {
-	limiter *rate.Limiter
-	config  *Config
+	settings
+	config *Config
+	// runID names the undo journals, each opened under the target of its
+	// run on the first change there; runID is empty when no journal is kept
+	runID    string
+	journals map[string]*journal.Journal
+	// journalMu guards journals, which concurrent ProcessFile calls share
+	journalMu sync.Mutex
+	// claims maps destinations taken by operations of the plan being
+	// built to their sources; nil outside PlanDirectory
+	claims map[string]string
+	// placed holds the destinations files were transferred to by the
+	// running ProcessDirectory, so its walk does not pick them up again;
+	// nil outside ProcessDirectory
+	placed  *pathSet
+	initErr error
 }
 
-// Config holds the program configuration
-type Config struct {
-	DryRun    bool
-	Verbose   bool
-	Recursive bool
-	TargetDir string
-	SourceDir string
-	Version   bool
-}
-
-// SupportedFormats defines the image file extensions that the program will process
-var SupportedFormats = map[string]bool{
-	".png":  true,
-	".jpg":  true,
-	".jpeg": true,
-	".gif":  true,
-	".bmp":  true,
+// transferVerbs describes each transfer mode in verbose output
+var transferVerbs = map[fileutils.TransferMode]string{
+	fileutils.ModeMove:     "Moving",
+	fileutils.ModeCopy:     "Copying",
+	fileutils.ModeHardlink: "Hardlinking",
+	fileutils.ModeReflink:  "Reflinking",
 }
 
 // NewImageProcessor creates a new image processor instance
 func NewImageProcessor(config *Config) *ImageProcessor {
-	return &ImageProcessor{
-		limiter: rate.NewLimiter(rate.Limit(100), 1), // 100 ops/sec
-		config:  config,
+	p := &ImageProcessor{
+		config: config,
+	}
+	// An invalid configuration is reported by the first Process* call, so
+	// callers that skipped Validate still get a clear error
+	if s, err := config.parse(); err != nil {
+		p.initErr = err
+	} else {
+		p.settings = *s
 	}
+	if !config.DryRun && !config.NoJournal {
+		p.runID = journal.NewRunID(time.Now())
+	}
+	return p
 }
 
-// ProcessDirectory handles the processing of a directory
-func (p *ImageProcessor) ProcessDirectory(sourceDir, targetDir string) error {
-	// Check if directory exists
-	entries, err := os.ReadDir(sourceDir)
-	if err != nil {
-		return fmt.Errorf("failed to read directory %s: %w", sourceDir, err)
+// RunID identifies this run in the undo journal. It is empty until a
+// change has been journaled.
+func (p *ImageProcessor) RunID() string {
+	p.journalMu.Lock()
+	defer p.journalMu.Unlock()
+	for _, j := range p.journals {
+		if j.Written() {
+			return j.RunID()
+		}
 	}
+	return ""
+}
 
-	// If targetDir is empty, use sourceDir
+// ProcessDirectory handles the processing of a directory and summarizes
+// the run. Up to Config.Workers files are processed at a time; output and
+// the summary still follow the order of the walk, while each transfer is
+// journaled as soon as it is done.
+// Files and subdirectories that cannot be processed do not stop the run;
+// they are listed in the summary and returned together in a *RunError
+// once everything else is done. Any other error means the run could not
+// be carried out at all.
+//
+// Canceling ctx stops the run early: files already being transferred are
+// finished, or rolled back if the transfer cannot complete, and the rest
+// are left untouched. The summary then covers what was done, has
+// Interrupted set and is returned with an error matching ErrInterrupted.
+//
+// Unless DryRun is set, progress is recorded in a checkpoint under the
+// target until the run completes without errors. With Resume set, files
+// and directories the checkpoint shows as finished, and that have not
+// changed since, are skipped and counted in Summary.Resumed.
+func (p *ImageProcessor) ProcessDirectory(ctx context.Context, sourceDir, targetDir string) (*Summary, error) {
+	if p.initErr != nil {
+		return nil, p.initErr
+	}
 	if targetDir == "" {
 		targetDir = sourceDir
 	}
 
+	rs, err := p.openCheckpoint(sourceDir, targetDir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := newSummary(time.Now(), p.config.DryRun, string(p.mode))
+	p.placed = newPathSet()
+	defer func() { p.placed = nil }()
+
+	pl := &pipeline{ctx: ctx, p: p, summary: s, rootTarget: targetDir, resume: rs}
+	err = pl.run(sourceDir, targetDir)
+	complete := err == nil && ctx.Err() == nil && len(s.Errors) == 0
+	if cerr := rs.close(targetDir, complete); cerr != nil && err == nil {
+		s.Errors = append(s.Errors, &ProcessError{Path: checkpoint.Path(targetDir), Op: OpCheckpoint, Err: cerr})
+	}
+	if err != nil && !canceled(err) {
+		return nil, err
+	}
+	s.Elapsed = time.Since(s.Started)
+	s.RunID = p.RunID()
+	if ctx.Err() != nil {
+		s.Interrupted = true
+		return s, errors.Join(interrupted(ctx), runError(s.Errors))
+	}
+	return s, runError(s.Errors)
+}
+
+// walker walks a source tree, reporting what it finds in walk order
+type walker struct {
+	ctx context.Context
+	p   *ImageProcessor
+	// rootTarget is the target of the whole walk
+	rootTarget string
+	// file is called for each file along with its target directory;
+	// sorted is set when the file's directory belongs to sorted output
+	file func(sourceDir, targetDir string, entry os.DirEntry, sorted bool)
+	// skip is called for each subdirectory left alone
+	skip func(dir string)
+	// exclude, if set, is called for each file and subdirectory left out
+	// by -include, -exclude or a .sortignore file
+	exclude func(path string)
+	// fail is called for each subdirectory that cannot be read
+	fail func(err *ProcessError)
+	// done, if set, is called for each directory once all of its entries
+	// have been reported
+	done func(dir string)
+}
+
+// walk visits each file in sourceDir, and in its subdirectories when
+// Recursive is set, along with the matching target directory. It only
+// returns errors that stop the walk, such as an unreadable sourceDir or
+// the cancellation of w.ctx.
+func (w *walker) walk(sourceDir, targetDir string) error {
+	return w.walkDir(sourceDir, targetDir, "", nil, false)
+}
+
+// walkDir walks one directory, found at rel below the root of the walk,
+// where the .sortignore rules of the directories above are in force.
+// sorted is set inside directories the layout produced, where targetDir
+// stays the root of that sorted tree so files that are already in the
+// right bucket resolve to themselves and are left alone.
+func (w *walker) walkDir(sourceDir, targetDir, rel string, rules *ignore.Rules, sorted bool) error {
+	p := w.p
+
+	// Check if directory exists
+	var entries []os.DirEntry
+	err := p.throttle.Do(w.ctx, func() (err error) {
+		entries, err = os.ReadDir(sourceDir)
+		return err
+	})
+	if err != nil {
+		if canceled(err) {
+			return err
+		}
+		return &ProcessError{Path: sourceDir, Op: OpReadDir, Err: err}
+	}
+	for _, entry := range entries {
+		if entry.Name() == ignore.FileName && !entry.IsDir() {
+			if rules, err = p.loadIgnore(w.ctx, rules, sourceDir, rel); err != nil {
+				if canceled(err) {
+					return err
+				}
+				return &ProcessError{Path: filepath.Join(sourceDir, ignore.FileName), Op: OpIgnore, Err: err}
+			}
+		}
+	}
+
 	for _, entry := range entries {
-		// Rate limit operations
-		if err := p.limiter.Wait(context.Background()); err != nil {
-			return fmt.Errorf("rate limiter error: %w", err)
+		if err := w.ctx.Err(); err != nil {
+			return err
 		}
 		fullPath := filepath.Join(sourceDir, entry.Name())
+		entryRel := path.Join(rel, entry.Name())
 
-		if entry.IsDir() {
-			if p.config.Recursive {
-				targetSubDir := filepath.Join(targetDir, entry.Name())
-				if err := p.ProcessDirectory(fullPath, targetSubDir); err != nil {
-					if p.config.Verbose {
-						fmt.Printf("Error processing directory %s: %v\n", fullPath, err)
-					}
-				}
+		if !entry.IsDir() {
+			if p.leftOut(rules, entryRel, false) {
+				w.excluded(fullPath)
+				continue
 			}
+			w.file(sourceDir, targetDir, entry, sorted)
 			continue
 		}
 
-		if _, err := p.ProcessFile(sourceDir, targetDir, entry); err != nil {
-			if p.config.Verbose {
-				fmt.Printf("Error processing file %s: %v\n", fullPath, err)
+		// Never sort the sorter's own state
+		if entry.Name() == journal.DirName || !p.config.Recursive {
+			continue
+		}
+		if p.leftOut(rules, entryRel, true) {
+			w.excluded(fullPath)
+			continue
+		}
+		subTarget, subSorted, ok := p.descend(fullPath, targetDir, w.rootTarget, sorted)
+		if !ok {
+			w.skip(fullPath)
+			continue
+		}
+		if err := w.walkDir(fullPath, subTarget, entryRel, rules, subSorted); err != nil {
+			var pe *ProcessError
+			if !errors.As(err, &pe) {
+				return err
 			}
+			w.fail(pe)
 		}
 	}
 
+	if w.done != nil {
+		w.done(sourceDir)
+	}
 	return nil
 }
 
-// ProcessFile handles the processing of a single file
-func (p *ImageProcessor) ProcessFile(sourceDir, targetDir string, entry os.DirEntry) (bool, error) {
-	// Check if it's a supported image format
-	ext := strings.ToLower(filepath.Ext(entry.Name()))
-	if !SupportedFormats[ext] {
-		return false, nil
+// excluded reports a file or directory left out of the walk
+func (w *walker) excluded(path string) {
+	if w.exclude != nil {
+		w.exclude(path)
 	}
+}
+
+// leftOut reports whether -include, -exclude or a .sortignore file leaves
+// out the file or directory at rel below the root of the walk
+func (p *ImageProcessor) leftOut(rules *ignore.Rules, rel string, isDir bool) bool {
+	if p.filter.Excluded(rel, isDir) || rules.Ignored(rel, isDir) {
+		return true
+	}
+	return !isDir && !p.filter.Included(rel)
+}
 
-	// Get file info for timestamp
-	fileInfo, err := entry.Info()
+// loadIgnore returns rules with the .sortignore file of dir, found at rel
+// below the root of the walk, on top. A directory without one keeps rules.
+func (p *ImageProcessor) loadIgnore(ctx context.Context, rules *ignore.Rules, dir, rel string) (*ignore.Rules, error) {
+	file := filepath.Join(dir, ignore.FileName)
+	var data []byte
+	err := p.throttle.Do(ctx, func() (err error) {
+		data, err = os.ReadFile(file)
+		return err
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return rules, nil
+	}
 	if err != nil {
-		return false, fmt.Errorf("failed to get file info for %s: %w", entry.Name(), err)
+		return rules, err
+	}
+	return rules.Add(rel, file, data)
+}
+
+// descend decides how to walk the subdirectory dir. The target directory
+// itself, and directories below a target whose path matches the layout,
+// hold output of earlier runs: their files are sorted against the root of
+// that output rather than mirrored into a new subtree, which would nest
+// 2023/2023. Other subdirectories of sorted output belong to the user and
+// are not descended into (ok is false).
+func (p *ImageProcessor) descend(dir, targetDir, rootTarget string, sorted bool) (subTarget string, subSorted, ok bool) {
+	// Photos sorted out earlier are left where they are
+	if p.config.PhotoTarget != "" && absPath(dir) == absPath(p.config.PhotoTarget) {
+		return "", false, false
+	}
+	if absPath(dir) == absPath(rootTarget) {
+		return dir, true, true
+	}
+	if rel, err := filepath.Rel(absPath(targetDir), absPath(dir)); err == nil && rel != ".." &&
+		!strings.HasPrefix(rel, ".."+string(filepath.Separator)) && p.layout.MatchDir(rel) {
+		return targetDir, true, true
+	}
+	if sorted {
+		return "", false, false
+	}
+	return filepath.Join(targetDir, filepath.Base(dir)), false, true
+}
+
+// TargetDirFor returns the target directory for the files in dir, which
+// lies at or below sourceDir, as ProcessDirectory from sourceDir to
+// targetDir would choose it. ok is false for directories such a run does
+// not descend into, including every subdirectory unless Recursive is set
+// and those left out by -exclude or a .sortignore file.
+func (p *ImageProcessor) TargetDirFor(sourceDir, targetDir, dir string) (target string, ok bool) {
+	target, _, _, ok = p.locate(sourceDir, targetDir, dir)
+	return target, ok
+}
+
+// FileTargetDir returns the target directory for the file at file, below
+// sourceDir, as ProcessDirectory from sourceDir to targetDir would choose
+// it. ok is false if such a run would leave the file out, because of its
+// directory as with TargetDirFor, or because of -include, -exclude or a
+// .sortignore file.
+func (p *ImageProcessor) FileTargetDir(sourceDir, targetDir, file string) (target string, ok bool) {
+	target, rules, rel, ok := p.locate(sourceDir, targetDir, filepath.Dir(file))
+	if !ok || p.leftOut(rules, path.Join(rel, filepath.Base(file)), false) {
+		return "", false
+	}
+	return target, true
+}
+
+// locate follows the walk from sourceDir down to dir. It returns the
+// target directory of dir, the .sortignore rules in force there and its
+// slash-separated path relative to sourceDir.
+func (p *ImageProcessor) locate(sourceDir, targetDir, dir string) (target string, rules *ignore.Rules, rel string, ok bool) {
+	if p.initErr != nil {
+		return "", nil, "", false
+	}
+	if targetDir == "" {
+		targetDir = sourceDir
+	}
+	relDir, err := filepath.Rel(absPath(sourceDir), absPath(dir))
+	if err != nil || relDir == ".." || strings.HasPrefix(relDir, ".."+string(filepath.Separator)) {
+		return "", nil, "", false
+	}
+	ctx := context.Background()
+	if rules, err = p.loadIgnore(ctx, nil, sourceDir, ""); err != nil {
+		return "", nil, "", false
+	}
+	if relDir == "." {
+		return targetDir, rules, "", true
+	}
+	if !p.config.Recursive {
+		return "", nil, "", false
+	}
+
+	current, target, sorted := sourceDir, targetDir, false
+	for _, name := range strings.Split(relDir, string(filepath.Separator)) {
+		rel = path.Join(rel, name)
+		if name == journal.DirName || p.leftOut(rules, rel, true) {
+			return "", nil, "", false
+		}
+		current = filepath.Join(current, name)
+		if target, sorted, ok = p.descend(current, target, targetDir, sorted); !ok {
+			return "", nil, "", false
+		}
+		if rules, err = p.loadIgnore(ctx, rules, current, rel); err != nil {
+			return "", nil, "", false
+		}
+	}
+	return target, rules, rel, true
+}
+
+// ProcessFile handles the processing of a single file. If ctx is canceled
+// before the file is transferred, it is left untouched and the error
+// matches ctx.Err(). When targetDir lies within Config.TargetDir, as the
+// directories FileTargetDir returns do, the file belongs to a run on
+// Config.TargetDir; otherwise targetDir is the target of its run.
+func (p *ImageProcessor) ProcessFile(ctx context.Context, sourceDir, targetDir string, entry os.DirEntry) (*FileResult, error) {
+	if p.initErr != nil {
+		return nil, p.initErr
+	}
+	target := targetDir
+	if p.config.TargetDir != "" {
+		if _, ok := within(p.config.TargetDir, targetDir); ok {
+			target = p.config.TargetDir
+		}
+	}
+
+	l := p.newLog(target)
+	c, err := p.inspect(ctx, sourceDir, entry, l)
+	var res *FileResult
+	switch {
+	case err != nil:
+	case c == nil:
+		res = unsupported(sourceDir, entry)
+	default:
+		res, err = p.processFile(ctx, c, targetDir, l)
+	}
+	p.flush(l)
+	return res, err
+}
+
+// processFile plans and carries out the transfer of an inspected file
+func (p *ImageProcessor) processFile(ctx context.Context, c *candidate, targetDir string, l *fileLog) (*FileResult, error) {
+	// Claim the destination through the conflict policy. The check and the
+	// transfer are separate steps, so another process can take the name in
+	// between; transfers never overwrite a file the policy did not choose to
+	// replace, and a name taken in the meantime goes back through the policy.
+	for attempt := 1; ; attempt++ {
+		op, err := p.planFile(ctx, c, l.target, targetDir)
+		if err != nil {
+			return nil, err
+		}
+		done, err := p.execute(ctx, op, l)
+		if err == nil {
+			return &FileResult{Operation: op, Done: done}, nil
+		}
+		if canceled(err) {
+			l.printf("Left %s untouched: interrupted\n", c.path)
+			return nil, err
+		}
+		if !errors.Is(err, fs.ErrExist) || attempt == maxTransferAttempts {
+			return nil, err
+		}
+		l.printf("%s was created by someone else, resolving the conflict again\n", op.Dest)
+	}
+}
+
+// unsupported is the result of a file that is not a supported image
+func unsupported(sourceDir string, entry os.DirEntry) *FileResult {
+	return &FileResult{
+		Operation:   Operation{Action: ActionSkip, Source: filepath.Join(sourceDir, entry.Name()), Reason: ReasonUnsupported},
+		Unsupported: true,
+	}
+}
+
+// Considers reports whether ProcessFile looks at files with this name:
+// those with the extension of a known format, and those without an
+// extension, whose content decides
+func (p *ImageProcessor) Considers(name string) bool {
+	if p.initErr != nil {
+		return false
+	}
+	ext := format.Ext(name)
+	_, known := p.formats.ByExtension(ext)
+	return known || ext == ""
+}
+
+// candidate is a supported file whose capture time has been resolved
+type candidate struct {
+	path     string
+	info     os.FileInfo
+	resolved fileutils.ResolvedTime
+	format   format.Format
+	// mismatch is set when the content is in another format than the
+	// extension says
+	mismatch bool
+	// name is the file name at the destination
+	name string
+	// class tells whether the file is a screenshot or a photo; nil under
+	// PhotosSort
+	class *classify.Result
+}
+
+// inspect identifies the format of a directory entry and resolves its
+// capture time. It returns nil for files that are not supported images.
+func (p *ImageProcessor) inspect(ctx context.Context, sourceDir string, entry os.DirEntry, l *fileLog) (*candidate, error) {
+	// Files with the extension of another kind of file are not read at all
+	ext := format.Ext(entry.Name())
+	named, known := p.formats.ByExtension(ext)
+	if !known && ext != "" {
+		return nil, nil
 	}
 
 	// Get source and target paths
 	sourcePath := filepath.Join(sourceDir, entry.Name())
 
-	// Get file's actual timestamp
-	fileTime := fileutils.GetFileTime(fileInfo)
-	year := fileTime.Format("2006")
-	yearDir := filepath.Join(targetDir, year)
+	// Stat the file, identify its content and resolve its capture time
+	// through the configured source chain, one metadata read as far as the
+	// throttle is concerned
+	var fileInfo os.FileInfo
+	var detected format.Format
+	var identified bool
+	var detectErr error
+	var resolved fileutils.ResolvedTime
+	var warnings []error
+	var class *classify.Result
+	err := p.throttle.Do(ctx, func() (err error) {
+		if fileInfo, err = entry.Info(); err != nil {
+			return err
+		}
+		detected, identified, detectErr = p.formats.DetectFile(sourcePath)
+		if !identified && !known {
+			return nil
+		}
+		resolved, warnings = p.resolver.Resolve(sourcePath, fileInfo, p.location)
+		if p.classifier != nil {
+			f := named
+			if identified {
+				f = detected
+			}
+			// What cannot be read is left out of the verdict
+			img, err := classify.Read(sourcePath, f.Name)
+			if err != nil {
+				warnings = append(warnings, fmt.Errorf("classify: %w", err))
+			}
+			result := p.classifier.Classify(img)
+			class = &result
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, &ProcessError{Path: sourcePath, Op: OpStat, Err: err}
+	}
+	if detectErr != nil {
+		l.printf("Could not read %s to identify its format: %v\n", sourcePath, detectErr)
+	}
+
+	// The content decides, unless it is in no known format: then the
+	// extension is taken at its word
+	c := &candidate{path: sourcePath, info: fileInfo, resolved: resolved, format: named, name: entry.Name(), class: class}
+	switch {
+	case !identified && !known:
+		return nil, nil
+	case identified:
+		c.format = detected
+		c.mismatch = known && detected.Name != named.Name
+	}
+	if c.mismatch {
+		l.printf("%s holds %s data, not %s as its extension says\n", sourcePath, detected.Name, named.Name)
+	}
+	if p.config.FixExtensions && (c.mismatch || ext == "") {
+		c.name = c.format.Rename(c.name)
+	}
+	for _, w := range warnings {
+		l.printf("Ignoring unreadable metadata in %s: %v\n", sourcePath, w)
+	}
+	if c.class != nil {
+		l.printf("Classified %s as %s\n", sourcePath, c.class)
+	}
+	return c, nil
+}
+
+// operation describes what is done with c
+func (c *candidate) operation(action Action, dest string, fileTime time.Time, reason string) Operation {
+	op := Operation{
+		Action:     action,
+		Source:     c.path,
+		Dest:       dest,
+		Size:       c.info.Size(),
+		ModTime:    c.info.ModTime(),
+		Time:       fileTime,
+		TimeSource: c.resolved.Source,
+		Fallback:   c.resolved.Fallback,
+		Format:     c.format.Name,
+		Mismatch:   c.mismatch,
+		Reason:     reason,
+	}
+	if c.class != nil {
+		op.Kind, op.Score = string(c.class.Kind), c.class.Score
+	}
+	return op
+}
+
+// targetPath is where a file goes unless the name is taken; root is the
+// target of the run and targetDir the directory within it the file is
+// sorted into. Every time-derived path component uses the configured
+// zone, so the bucket and any collision suffix always agree on the date.
+func (p *ImageProcessor) targetPath(c *candidate, root, targetDir string) string {
+	if c.photo() && p.photos == PhotosSeparate {
+		targetDir = p.photoDir(root, targetDir)
+	}
+	return filepath.Join(targetDir, p.layout.Expand(c.resolved.Time.In(p.location)), c.name)
+}
+
+// planFile decides where a file goes and what happens if the name is taken
+func (p *ImageProcessor) planFile(ctx context.Context, c *candidate, root, targetDir string) (Operation, error) {
+	fileTime := c.resolved.Time.In(p.location)
+	targetPath := p.targetPath(c, root, targetDir)
+	if c.photo() && p.photos == PhotosSkip {
+		// Plans need a destination for every operation; a skipped photo
+		// gets the one it would have had
+		return c.operation(ActionSkip, targetPath, fileTime, ReasonPhoto), nil
+	}
+
+	var outcome conflictOutcome
+	err := p.throttle.Do(ctx, func() (err error) {
+		outcome, err = p.resolveConflict(c.path, targetPath, c.info, fileTime)
+		return err
+	})
+	if err != nil {
+		return Operation{}, &ProcessError{Path: c.path, Op: OpResolve, Dest: targetPath, Err: err}
+	}
+
+	op := c.operation("", outcome.path, fileTime, outcome.reason)
+	if op.Dest == "" {
+		op.Dest = targetPath
+	}
+
+	switch {
+	case outcome.duplicate:
+		op.Decision = DecisionDuplicate
+		op.Action = ActionSkip
+		// Duplicates are removed from the source when configured to, but
+		// only in move mode, where the source is not meant to keep a copy
+		if p.config.RemoveDuplicates && p.mode == fileutils.ModeMove {
+			op.Action = ActionRemove
+		}
+	case outcome.skip:
+		op.Decision = DecisionSkip
+		op.Action = ActionSkip
+	case outcome.replace:
+		op.Decision = DecisionReplace
+		op.Action = Action(p.mode)
+	default:
+		if op.Dest != targetPath {
+			op.Decision = DecisionRenamed
+		}
+		op.Action = Action(p.mode)
+	}
 
-	if !p.config.DryRun {
-		if err := os.MkdirAll(yearDir, 0755); err != nil {
-			return false, fmt.Errorf("failed to create directory %s: %w", yearDir, err)
+	// Remember what a replaced or duplicated file looked like, so applying
+	// a saved plan can tell whether it has changed since
+	if op.Decision == DecisionReplace || op.Decision == DecisionDuplicate {
+		fi, _, err := p.statDest(op.Dest)
+		if err != nil {
+			return Operation{}, &ProcessError{Path: c.path, Op: OpResolve, Dest: op.Dest, Err: err}
 		}
+		op.Existing = &FileState{Size: fi.Size(), ModTime: fi.ModTime()}
 	}
+	return op, nil
+}
+
+// execute carries out a planned operation. What it prints and the changes
+// to journal go to l. Once a transfer has started, canceling ctx only
+// interrupts a bandwidth-limited copy, which is then rolled back.
+func (p *ImageProcessor) execute(ctx context.Context, op Operation, l *fileLog) (bool, error) {
+	switch op.Action {
+	case ActionSkip:
+		l.printf("Skipping %s: %s\n", op.Source, op.Reason)
+		return false, nil
+
+	case ActionRemove:
+		l.printf("Removing duplicate %s: %s\n", op.Source, op.Reason)
+		if p.config.DryRun {
+			return true, nil
+		}
+		if err := p.throttle.Do(ctx, func() error { return os.Remove(op.Source) }); err != nil {
+			return false, &ProcessError{Path: op.Source, Op: OpRemove, Err: err}
+		}
+		return true, p.journalEntry(l, journal.OpRemove, op.Source, op.Dest, false)
+	}
+
+	mode := fileutils.TransferMode(op.Action)
+	replace := op.Decision == DecisionReplace
+	l.printf("%s %s to %s (%s)\n", transferVerbs[mode], op.Source, op.Dest, op.resolved())
+	if p.config.DryRun {
+		return true, nil
+	}
+
+	err := p.throttle.DoCopy(ctx, func() (copied bool, err error) {
+		if err := os.MkdirAll(filepath.Dir(op.Dest), 0755); err != nil {
+			return false, &ProcessError{Path: filepath.Dir(op.Dest), Op: OpMkdir, Err: err}
+		}
+		// Mark the destination before it exists, so a walk that lists it
+		// afterwards knows to leave it alone
+		p.placed.add(op.Dest)
+		opts := fileutils.TransferOptions{Replace: replace, Reader: func(r io.Reader) io.Reader {
+			copied = true
+			return p.throttle.Reader(ctx, r)
+		}}
+		if err := fileutils.Transfer(mode, op.Source, op.Dest, opts); err != nil {
+			p.placed.remove(op.Dest)
+			return copied, &ProcessError{Path: op.Source, Op: string(mode), Dest: op.Dest, Err: err}
+		}
+		return copied, nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, p.journalEntry(l, journal.Op(op.Action), op.Source, op.Dest, replace)
+}
+
+// fileLog collects what processing one file prints, so files processed
+// concurrently can be reported in walk order
+type fileLog struct {
+	// target is the target of the file's run, which holds its journal
+	target  string
+	verbose bool
+	out     strings.Builder
+}
+
+func (p *ImageProcessor) newLog(target string) *fileLog {
+	return &fileLog{target: target, verbose: p.config.Verbose}
+}
 
-	// Generate target path and handle conflicts
-	targetPath := filepath.Join(yearDir, entry.Name())
-	if _, err := os.Stat(targetPath); err == nil {
-		// File exists, append timestamp from the original file
-		ext := filepath.Ext(entry.Name())
-		base := strings.TrimSuffix(entry.Name(), ext)
-		timestamp := fileTime.UTC().Format("20060102_150405")
-		targetPath = filepath.Join(yearDir, fmt.Sprintf("%s_%s%s", base, timestamp, ext))
+// printf adds verbose output
+func (l *fileLog) printf(format string, args ...interface{}) {
+	if l.verbose {
+		fmt.Fprintf(&l.out, format, args...)
 	}
+}
+
+// leftAlone reports a subdirectory the walk does not descend into
+func (l *fileLog) leftAlone(dir string) {
+	l.printf("Leaving %s alone: not part of the layout\n", dir)
+}
 
-	if p.config.Verbose {
-		fmt.Printf("Moving %s to %s\n", sourcePath, targetPath)
+// journalEntry records a completed change in the undo journal of l's run
+// right away, along with the size and hash undo needs to tell whether the
+// destination was modified since
+func (p *ImageProcessor) journalEntry(l *fileLog, op journal.Op, sourcePath, targetPath string, replaced bool) error {
+	if p.runID == "" {
+		return nil
 	}
+	fi, err := os.Lstat(targetPath)
+	if err != nil {
+		return &ProcessError{Path: sourcePath, Op: OpJournal, Dest: targetPath, Err: err}
+	}
+	sum, err := fileutils.HashFile(targetPath)
+	if err != nil {
+		return &ProcessError{Path: sourcePath, Op: OpJournal, Dest: targetPath, Err: err}
+	}
+	err = p.record(l.target, journal.Entry{
+		Op:       op,
+		Source:   absPath(sourcePath),
+		Dest:     absPath(targetPath),
+		Size:     fi.Size(),
+		SHA256:   hex.EncodeToString(sum),
+		Replaced: replaced,
+		Time:     time.Now(),
+	})
+	if err != nil {
+		err = fmt.Errorf("file was transferred but could not be recorded for undo: %w", err)
+		return &ProcessError{Path: sourcePath, Op: OpJournal, Dest: targetPath, Err: err}
+	}
+	return nil
+}
+
+// flush prints the output collected in l
+func (p *ImageProcessor) flush(l *fileLog) {
+	fmt.Print(l.out.String())
+}
 
-	if !p.config.DryRun {
-		if err := os.Rename(sourcePath, targetPath); err != nil {
-			return false, fmt.Errorf("failed to move file %s to %s: %w", sourcePath, targetPath, err)
+// record adds e to the undo journal under target
+func (p *ImageProcessor) record(target string, e journal.Entry) error {
+	p.journalMu.Lock()
+	j := p.journals[target]
+	if j == nil {
+		if p.journals == nil {
+			p.journals = make(map[string]*journal.Journal)
 		}
+		j = journal.Open(target, p.runID)
+		p.journals[target] = j
 	}
+	p.journalMu.Unlock()
+	return j.Record(e)
+}
 
-	return true, nil
+// absPath makes journal entries independent of the working directory
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// within returns the path of dir relative to root, if dir is root or lies
+// below it
+func within(root, dir string) (rel string, ok bool) {
+	rel, err := filepath.Rel(absPath(root), absPath(dir))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
 }
@@ -1,13 +1,20 @@
 package core
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/screenshot-sorter/pkg/fileutils"
+	"github.com/screenshot-sorter/pkg/format"
+	"github.com/screenshot-sorter/pkg/ignore"
+	"github.com/screenshot-sorter/pkg/journal"
 )
 
 type FileInfoDirEntry struct {
@@ -42,7 +49,7 @@ func TestImageProcessor_ProcessFile(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	fileTime := fileutils.GetFileTime(fileInfo)
+	fileTime := fileutils.GetFileTime(testFilePath, fileInfo)
 	expectedYear := fileTime.Format("2006")
 
 	config := &Config{
@@ -53,12 +60,12 @@ func TestImageProcessor_ProcessFile(t *testing.T) {
 
 	processor := NewImageProcessor(config)
 	dirEntry := FileInfoDirEntry{info: fileInfo}
-	processed, err := processor.ProcessFile(tempDir, tempDir, dirEntry)
+	result, err := processor.ProcessFile(context.Background(), tempDir, tempDir, dirEntry)
 
 	if err != nil {
 		t.Errorf("ProcessFile() error = %v", err)
 	}
-	if !processed {
+	if result == nil || !result.Done {
 		t.Error("ProcessFile() file was not processed")
 	}
 
@@ -70,6 +77,11 @@ func TestImageProcessor_ProcessFile(t *testing.T) {
 }
 
 func TestImageProcessor_ProcessFileWithCustomTime(t *testing.T) {
+	// Set test environment to use ModTime, since the birth time of a
+	// freshly created file cannot be changed
+	os.Setenv("SCREENSHOT_SORTER_TEST_USE_MODTIME", "1")
+	defer os.Unsetenv("SCREENSHOT_SORTER_TEST_USE_MODTIME")
+
 	// Create temporary test directory
 	tempDir, err := os.MkdirTemp("", "screenshot-sorter-test")
 	if err != nil {
@@ -117,18 +129,18 @@ func TestImageProcessor_ProcessFileWithCustomTime(t *testing.T) {
 		}
 
 		dirEntry := FileInfoDirEntry{info: fileInfo}
-		processed, err := processor.ProcessFile(tempDir, tempDir, dirEntry)
+		result, err := processor.ProcessFile(context.Background(), tempDir, tempDir, dirEntry)
 		if err != nil {
 			t.Errorf("ProcessFile() error = %v", err)
 			continue
 		}
-		if !processed {
+		if result == nil || !result.Done {
 			t.Errorf("ProcessFile() file %s was not processed", tf.name)
 			continue
 		}
 
 		// Check if file was moved to the correct year folder
-		expectedPath := filepath.Join(tempDir, fileutils.GetFileTime(fileInfo).Format("2006"), tf.name)
+		expectedPath := filepath.Join(tempDir, fileutils.GetFileTime(filePath, fileInfo).Format("2006"), tf.name)
 		if _, err := os.Stat(expectedPath); os.IsNotExist(err) {
 			t.Errorf("File %s was not moved to the expected location: %s", tf.name, expectedPath)
 		}
@@ -155,7 +167,7 @@ func TestImageProcessor_ProcessFileWithConflictingTimes(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	year := fileutils.GetFileTime(fileInfo1).Format("2006")
+	year := fileutils.GetFileTime(testFile1, fileInfo1).Format("2006")
 	yearDir := filepath.Join(tempDir, year)
 	if err := os.MkdirAll(yearDir, 0755); err != nil {
 		t.Fatal(err)
@@ -175,12 +187,12 @@ func TestImageProcessor_ProcessFileWithConflictingTimes(t *testing.T) {
 
 	processor := NewImageProcessor(config)
 	dirEntry := FileInfoDirEntry{info: fileInfo1}
-	processed, err := processor.ProcessFile(tempDir, tempDir, dirEntry)
+	result, err := processor.ProcessFile(context.Background(), tempDir, tempDir, dirEntry)
 
 	if err != nil {
 		t.Errorf("ProcessFile() error = %v", err)
 	}
-	if !processed {
+	if result == nil || !result.Done {
 		t.Error("ProcessFile() file was not processed")
 	}
 
@@ -266,7 +278,7 @@ func TestImageProcessor_ProcessDirectoryRecursive(t *testing.T) {
 	}
 
 	processor := NewImageProcessor(config)
-	if err := processor.ProcessDirectory(tempDir, tempDir); err != nil {
+	if _, err := processor.ProcessDirectory(context.Background(), tempDir, tempDir); err != nil {
 		t.Errorf("ProcessDirectory() error = %v", err)
 	}
 
@@ -336,7 +348,7 @@ func TestImageProcessor_FileConflictWithTimestamp(t *testing.T) {
 	processor := NewImageProcessor(config)
 	fileInfo1, _ := os.Stat(file1)
 	dirEntry1 := FileInfoDirEntry{info: fileInfo1}
-	if _, err := processor.ProcessFile(tempDir, tempDir, dirEntry1); err != nil {
+	if _, err := processor.ProcessFile(context.Background(), tempDir, tempDir, dirEntry1); err != nil {
 		t.Fatal(err)
 	}
 
@@ -352,7 +364,7 @@ func TestImageProcessor_FileConflictWithTimestamp(t *testing.T) {
 	// Process second file
 	fileInfo2, _ := os.Stat(file2)
 	dirEntry2 := FileInfoDirEntry{info: fileInfo2}
-	if _, err := processor.ProcessFile(tempDir, tempDir, dirEntry2); err != nil {
+	if _, err := processor.ProcessFile(context.Background(), tempDir, tempDir, dirEntry2); err != nil {
 		t.Fatal(err)
 	}
 
@@ -367,8 +379,8 @@ func TestImageProcessor_FileConflictWithTimestamp(t *testing.T) {
 		t.Errorf("Expected 2 files, got %d", len(files))
 	}
 
-	// Convert expected timestamp to UTC for comparison
-	timestampTime := time2.UTC()
+	// The suffix uses the same zone as the year folder, local by default
+	timestampTime := time2.Local()
 	expectedTimestamp := timestampTime.Format("20060102_150405")
 	expectedNames := map[string]bool{
 		"test.png": true,
@@ -392,3 +404,675 @@ func TestImageProcessor_FileConflictWithTimestamp(t *testing.T) {
 		}
 	}
 }
+
+func TestImageProcessor_ProcessFileWithLayout(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "screenshot-sorter-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	testFile := filepath.Join(tempDir, "test.png")
+	if err := os.WriteFile(testFile, []byte("test content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fileInfo, err := os.Stat(testFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileTime := fileutils.GetFileTime(testFile, fileInfo)
+
+	config := &Config{
+		Layout:    "{year}/{month:02}-{monthname}",
+		TargetDir: tempDir,
+	}
+
+	processor := NewImageProcessor(config)
+	if _, err := processor.ProcessFile(context.Background(), tempDir, tempDir, FileInfoDirEntry{info: fileInfo}); err != nil {
+		t.Fatalf("ProcessFile() error = %v", err)
+	}
+
+	expectedPath := filepath.Join(tempDir, fileTime.Format("2006"), fileTime.Format("01-January"), "test.png")
+	if _, err := os.Stat(expectedPath); os.IsNotExist(err) {
+		t.Errorf("File was not moved to the expected location: %s", expectedPath)
+	}
+}
+
+func TestImageProcessor_InvalidLayout(t *testing.T) {
+	config := &Config{Layout: "{year}/{bogus}"}
+	if err := config.Validate(); err == nil {
+		t.Error("Validate() should reject an unknown layout token")
+	}
+
+	processor := NewImageProcessor(config)
+	if _, err := processor.ProcessDirectory(context.Background(), os.TempDir(), os.TempDir()); err == nil {
+		t.Error("ProcessDirectory() should fail with an invalid layout")
+	}
+}
+
+func TestImageProcessor_ProcessFileWithFilenameTime(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "screenshot-sorter-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	files := map[string]string{
+		"Screenshot 2019-04-01 at 10.22.33.png": "2019",
+		"shot_2017.04.01.png":                   "2017",
+	}
+	for name := range files {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte("test content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := &Config{
+		TargetDir:        tempDir,
+		FilenamePatterns: []string{`^shot_(?P<year>\d{4})\.(?P<month>\d{2})\.(?P<day>\d{2})`},
+	}
+
+	processor := NewImageProcessor(config)
+	for name, year := range files {
+		fileInfo, err := os.Stat(filepath.Join(tempDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := processor.ProcessFile(context.Background(), tempDir, tempDir, FileInfoDirEntry{info: fileInfo}); err != nil {
+			t.Fatalf("ProcessFile() error = %v", err)
+		}
+
+		expectedPath := filepath.Join(tempDir, year, name)
+		if _, err := os.Stat(expectedPath); os.IsNotExist(err) {
+			t.Errorf("File was not moved to the expected location: %s", expectedPath)
+		}
+	}
+}
+
+type fixedResolver struct {
+	t time.Time
+}
+
+func (r fixedResolver) Name() string { return "fixed" }
+
+func (r fixedResolver) Resolve(string, os.FileInfo, *time.Location) (time.Time, bool, error) {
+	return r.t, true, nil
+}
+
+func TestImageProcessor_CustomTimeResolver(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "screenshot-sorter-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	testFile := filepath.Join(tempDir, "Screenshot_20230401-102233.png")
+	if err := os.WriteFile(testFile, []byte("test content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		sources []string
+		year    string
+	}{
+		// Unplaced custom resolvers run before the built-in sources
+		{"unplaced", nil, "2012"},
+		{"after filename", []string{"filename", "fixed", "mtime"}, "2023"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{
+				TargetDir:     tempDir,
+				TimeSources:   tt.sources,
+				TimeResolvers: []fileutils.TimeResolver{fixedResolver{time.Date(2012, 1, 1, 0, 0, 0, 0, time.Local)}},
+			}
+			if err := config.Validate(); err != nil {
+				t.Fatal(err)
+			}
+
+			fileInfo, err := os.Stat(testFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			processor := NewImageProcessor(config)
+			if _, err := processor.ProcessFile(context.Background(), tempDir, tempDir, FileInfoDirEntry{info: fileInfo}); err != nil {
+				t.Fatalf("ProcessFile() error = %v", err)
+			}
+
+			movedPath := filepath.Join(tempDir, tt.year, filepath.Base(testFile))
+			if _, err := os.Stat(movedPath); err != nil {
+				t.Fatalf("File was not moved to the expected location: %s", movedPath)
+			}
+			// Put the file back for the next case
+			if err := os.Rename(movedPath, testFile); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestConfig_InvalidTimeSources(t *testing.T) {
+	for _, sources := range [][]string{{"exif", "sundial"}, {"exif", "exif"}} {
+		config := &Config{TimeSources: sources}
+		if err := config.Validate(); err == nil {
+			t.Errorf("Validate() should reject time sources %v", sources)
+		}
+	}
+}
+
+func TestImageProcessor_TimeZone(t *testing.T) {
+	// Set test environment to use ModTime
+	os.Setenv("SCREENSHOT_SORTER_TEST_USE_MODTIME", "1")
+	defer os.Unsetenv("SCREENSHOT_SORTER_TEST_USE_MODTIME")
+
+	// 23:30 UTC on New Year's Eve is already 2023 in Tokyo
+	fileTime := time.Date(2022, 12, 31, 23, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		timeZone string
+		year     string
+		suffix   string
+	}{
+		{"utc", "2022", "20221231_233000"},
+		{"Asia/Tokyo", "2023", "20230101_083000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.timeZone, func(t *testing.T) {
+			tempDir := t.TempDir()
+			config := &Config{TargetDir: tempDir, TimeZone: tt.timeZone}
+			if err := config.Validate(); err != nil {
+				t.Skipf("time zone unavailable: %v", err)
+			}
+			processor := NewImageProcessor(config)
+
+			// Process two same-named files so the second gets a suffix
+			for i := 0; i < 2; i++ {
+				testFile := filepath.Join(tempDir, "test.png")
+				if err := os.WriteFile(testFile, []byte("test content"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chtimes(testFile, fileTime, fileTime); err != nil {
+					t.Fatal(err)
+				}
+				fileInfo, err := os.Stat(testFile)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := processor.ProcessFile(context.Background(), tempDir, tempDir, FileInfoDirEntry{info: fileInfo}); err != nil {
+					t.Fatalf("ProcessFile() error = %v", err)
+				}
+			}
+
+			for _, name := range []string{"test.png", "test_" + tt.suffix + ".png"} {
+				expectedPath := filepath.Join(tempDir, tt.year, name)
+				if _, err := os.Stat(expectedPath); os.IsNotExist(err) {
+					t.Errorf("File was not moved to the expected location: %s", expectedPath)
+				}
+			}
+		})
+	}
+
+	if err := (&Config{TimeZone: "Mars/Olympus_Mons"}).Validate(); err == nil {
+		t.Error("Validate() should reject an unknown time zone")
+	}
+}
+
+func TestImageProcessor_CopyMode(t *testing.T) {
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+
+	testFile := filepath.Join(sourceDir, "Screenshot_20230401-102233.png")
+	if err := os.WriteFile(testFile, []byte("test content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Config{Mode: "copy", SourceDir: sourceDir, TargetDir: targetDir}
+	processor := NewImageProcessor(config)
+	if _, err := processor.ProcessDirectory(context.Background(), sourceDir, targetDir); err != nil {
+		t.Fatalf("ProcessDirectory() error = %v", err)
+	}
+
+	if _, err := os.Stat(testFile); err != nil {
+		t.Error("Original should be left in place in copy mode")
+	}
+	copied := filepath.Join(targetDir, "2023", filepath.Base(testFile))
+	if _, err := os.Stat(copied); err != nil {
+		t.Errorf("File was not copied to the expected location: %s", copied)
+	}
+
+	if err := (&Config{Mode: "teleport"}).Validate(); err == nil {
+		t.Error("Validate() should reject an unknown mode")
+	}
+}
+
+func TestImageProcessor_Journal(t *testing.T) {
+	os.Setenv("SCREENSHOT_SORTER_TEST_USE_MODTIME", "1")
+	defer os.Unsetenv("SCREENSHOT_SORTER_TEST_USE_MODTIME")
+
+	tempDir := t.TempDir()
+	source := filepath.Join(tempDir, "test.png")
+	writeTestFile(t, source, "data", time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC))
+
+	processor := NewImageProcessor(&Config{TargetDir: tempDir, TimeZone: "utc"})
+	if processor.RunID() != "" {
+		t.Error("RunID() should be empty before anything is journaled")
+	}
+	if _, err := processor.ProcessDirectory(context.Background(), tempDir, tempDir); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := journal.Read(tempDir, processor.RunID())
+	if err != nil {
+		t.Fatalf("journal.Read() error = %v", err)
+	}
+	if len(entries) != 1 || entries[0].Op != journal.OpMove || filepath.Base(entries[0].Dest) != "test.png" || entries[0].Size != 4 {
+		t.Fatalf("journal = %+v", entries)
+	}
+
+	// Undo puts the file back
+	if _, err := journal.Undo(context.Background(), tempDir, processor.RunID(), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(source); err != nil {
+		t.Errorf("source not restored: %v", err)
+	}
+}
+
+func TestImageProcessor_JournalUnderRunTarget(t *testing.T) {
+	os.Setenv("SCREENSHOT_SORTER_TEST_USE_MODTIME", "1")
+	defer os.Unsetenv("SCREENSHOT_SORTER_TEST_USE_MODTIME")
+
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+	writeTestFile(t, filepath.Join(sourceDir, "test.png"), "data", time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC))
+
+	// Library callers need not set Config.TargetDir
+	processor := NewImageProcessor(&Config{TimeZone: "utc"})
+	if _, err := processor.ProcessDirectory(context.Background(), sourceDir, targetDir); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := journal.Read(targetDir, processor.RunID()); err != nil || len(entries) != 1 {
+		t.Fatalf("journal.Read() = %+v, %v", entries, err)
+	}
+	if _, err := os.Stat(journal.DirName); err == nil {
+		t.Errorf("journal written to the working directory")
+	}
+}
+
+func TestImageProcessor_ProcessFileTargets(t *testing.T) {
+	os.Setenv("SCREENSHOT_SORTER_TEST_USE_MODTIME", "1")
+	defer os.Unsetenv("SCREENSHOT_SORTER_TEST_USE_MODTIME")
+
+	// Concurrent calls for different targets each journal to their own
+	processor := NewImageProcessor(&Config{TimeZone: "utc"})
+	targets := make([]string, 8)
+	var wg sync.WaitGroup
+	for i := range targets {
+		sourceDir := t.TempDir()
+		targets[i] = t.TempDir()
+		writeTestFile(t, filepath.Join(sourceDir, "test.png"), "data", time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC))
+		entries, err := os.ReadDir(sourceDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func(sourceDir, targetDir string, entry os.DirEntry) {
+			defer wg.Done()
+			if _, err := processor.ProcessFile(context.Background(), sourceDir, targetDir, entry); err != nil {
+				t.Errorf("ProcessFile() error = %v", err)
+			}
+		}(sourceDir, targets[i], entries[0])
+	}
+	wg.Wait()
+
+	for _, targetDir := range targets {
+		entries, err := journal.Read(targetDir, processor.RunID())
+		if err != nil || len(entries) != 1 || entries[0].Dest != filepath.Join(targetDir, "2023", "test.png") {
+			t.Errorf("journal of %s = %+v, %v", targetDir, entries, err)
+		}
+	}
+}
+
+func TestImageProcessor_RerunIsIdempotent(t *testing.T) {
+	os.Setenv("SCREENSHOT_SORTER_TEST_USE_MODTIME", "1")
+	defer os.Unsetenv("SCREENSHOT_SORTER_TEST_USE_MODTIME")
+
+	april := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	march := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tempDir := t.TempDir()
+	writeTestFile(t, filepath.Join(tempDir, "a.png"), "a", april)
+	writeTestFile(t, filepath.Join(tempDir, "b.png"), "b", march)
+	if err := os.MkdirAll(filepath.Join(tempDir, "2023", "04", "vacation"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// Already sorted, but into the wrong bucket by hand
+	writeTestFile(t, filepath.Join(tempDir, "2023", "04", "misplaced.png"), "m", march)
+	// A folder of the user's own inside the sorted tree
+	writeTestFile(t, filepath.Join(tempDir, "2023", "04", "vacation", "beach.png"), "v", march)
+
+	config := &Config{
+		Recursive: true,
+		SourceDir: tempDir,
+		TargetDir: tempDir,
+		Layout:    "{year}/{month:02}",
+		TimeZone:  "utc",
+	}
+	want := []string{
+		filepath.Join("2021", "03", "b.png"),
+		filepath.Join("2021", "03", "misplaced.png"),
+		filepath.Join("2023", "04", "a.png"),
+		filepath.Join("2023", "04", "vacation", "beach.png"),
+	}
+
+	for run := 1; run <= 2; run++ {
+		if _, err := NewImageProcessor(config).ProcessDirectory(context.Background(), tempDir, tempDir); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		filepath.WalkDir(tempDir, func(path string, d os.DirEntry, err error) error {
+			if d.IsDir() && d.Name() == journal.DirName {
+				return filepath.SkipDir
+			}
+			if !d.IsDir() {
+				rel, _ := filepath.Rel(tempDir, path)
+				got = append(got, rel)
+			}
+			return nil
+		})
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("run %d: files = %v, want %v", run, got, want)
+		}
+	}
+}
+
+func TestImageProcessor_TargetInsideSource(t *testing.T) {
+	os.Setenv("SCREENSHOT_SORTER_TEST_USE_MODTIME", "1")
+	defer os.Unsetenv("SCREENSHOT_SORTER_TEST_USE_MODTIME")
+
+	tempDir := t.TempDir()
+	targetDir := filepath.Join(tempDir, "sorted")
+	fileTime := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	writeTestFile(t, filepath.Join(tempDir, "a.png"), "a", fileTime)
+
+	config := &Config{Recursive: true, SourceDir: tempDir, TargetDir: targetDir, TimeZone: "utc"}
+	for run := 1; run <= 2; run++ {
+		if _, err := NewImageProcessor(config).ProcessDirectory(context.Background(), tempDir, targetDir); err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]string{"a.png": "a"}
+		if got := readBucket(t, filepath.Join(targetDir, "2023")); !equalContents(got, want) {
+			t.Errorf("run %d: bucket = %v, want %v", run, got, want)
+		}
+		if _, err := os.Stat(filepath.Join(targetDir, "sorted")); !os.IsNotExist(err) {
+			t.Errorf("run %d: target was sorted into itself", run)
+		}
+	}
+}
+
+func TestImageProcessor_TargetDirFor(t *testing.T) {
+	sourceDir := t.TempDir()
+	targetDir := filepath.Join(sourceDir, "sorted")
+	tests := []struct {
+		dir       string
+		recursive bool
+		want      string
+		ok        bool
+	}{
+		{dir: sourceDir, want: targetDir, ok: true},
+		{dir: filepath.Join(sourceDir, "trip")},
+		{dir: filepath.Join(sourceDir, "trip"), recursive: true, want: filepath.Join(targetDir, "trip"), ok: true},
+		{dir: filepath.Join(sourceDir, "trip", "day1"), recursive: true, want: filepath.Join(targetDir, "trip", "day1"), ok: true},
+		{dir: targetDir, recursive: true, want: targetDir, ok: true},
+		{dir: filepath.Join(targetDir, "2023"), recursive: true, want: targetDir, ok: true},
+		{dir: filepath.Join(targetDir, "albums"), recursive: true},
+		{dir: filepath.Join(sourceDir, journal.DirName), recursive: true},
+		{dir: filepath.Dir(sourceDir), recursive: true},
+	}
+	for _, tt := range tests {
+		p := NewImageProcessor(&Config{Recursive: tt.recursive, TimeZone: "utc"})
+		got, ok := p.TargetDirFor(sourceDir, targetDir, tt.dir)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("TargetDirFor(%s, recursive %v) = %q, %v; want %q, %v", tt.dir, tt.recursive, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestImageProcessor_IncludeExclude(t *testing.T) {
+	os.Setenv("SCREENSHOT_SORTER_TEST_USE_MODTIME", "1")
+	defer os.Unsetenv("SCREENSHOT_SORTER_TEST_USE_MODTIME")
+
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+	fileTime := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	for _, dir := range []string{"node_modules/pkg", ".stversions", "trip/Unsorted keep", "trip/drafts"} {
+		if err := os.MkdirAll(filepath.Join(sourceDir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{
+		"a.png", "b.jpg", "node_modules/pkg/c.png", ".stversions/d.png",
+		"trip/e.png", "trip/Unsorted keep/f.png", "trip/drafts/g.png", "trip/drafts/keep.png",
+	} {
+		writeTestFile(t, filepath.Join(sourceDir, name), name, fileTime)
+	}
+	os.WriteFile(filepath.Join(sourceDir, ignore.FileName), []byte(".stversions/\n"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "trip", ignore.FileName), []byte("drafts/*\n!keep.png\nUnsorted keep/\n"), 0644)
+
+	config := &Config{
+		TargetDir: targetDir, Recursive: true, TimeZone: "utc",
+		Include: []string{"*.png"}, Exclude: []string{"node_modules"},
+	}
+	p := NewImageProcessor(config)
+	s, err := p.ProcessDirectory(context.Background(), sourceDir, targetDir)
+	if err != nil {
+		t.Fatalf("ProcessDirectory() error = %v", err)
+	}
+	for _, moved := range []string{"2023/a.png", "trip/2023/e.png", "trip/drafts/2023/keep.png"} {
+		if _, err := os.Stat(filepath.Join(targetDir, moved)); err != nil {
+			t.Errorf("%s should have been sorted: %v", moved, err)
+		}
+	}
+	for _, left := range []string{"b.jpg", "node_modules/pkg/c.png", ".stversions/d.png", "trip/Unsorted keep/f.png", "trip/drafts/g.png"} {
+		if _, err := os.Stat(filepath.Join(sourceDir, left)); err != nil {
+			t.Errorf("%s should have been left out: %v", left, err)
+		}
+	}
+	// b.jpg, the two .sortignore files, node_modules, .stversions,
+	// Unsorted keep and drafts/g.png; excluded directories count once
+	if s.Excluded != 7 {
+		t.Errorf("Excluded = %d, want 7", s.Excluded)
+	}
+
+	// Watch mode asks about single files and directories
+	if _, ok := p.TargetDirFor(sourceDir, targetDir, filepath.Join(sourceDir, "node_modules", "pkg")); ok {
+		t.Error("TargetDirFor() accepted a directory below an excluded one")
+	}
+	if _, ok := p.FileTargetDir(sourceDir, targetDir, filepath.Join(sourceDir, "trip", "drafts", "g.png")); ok {
+		t.Error("FileTargetDir() accepted a file ignored by .sortignore")
+	}
+	if dir, ok := p.FileTargetDir(sourceDir, targetDir, filepath.Join(sourceDir, "trip", "drafts", "keep.png")); !ok || dir != filepath.Join(targetDir, "trip", "drafts") {
+		t.Errorf("FileTargetDir() = %q, %v for a file brought back by !keep.png", dir, ok)
+	}
+
+	if err := (&Config{Exclude: []string{"[z-a"}}).Validate(); err == nil {
+		t.Error("Validate() should reject an invalid -exclude pattern")
+	}
+}
+
+func TestImageProcessor_DetectsFormats(t *testing.T) {
+	os.Setenv("SCREENSHOT_SORTER_TEST_USE_MODTIME", "1")
+	defer os.Unsetenv("SCREENSHOT_SORTER_TEST_USE_MODTIME")
+
+	fileTime := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	files := map[string]string{
+		"shot.PNG.jpg":                      "\x89PNG\r\n\x1a\nrest of a png",
+		"download":                          "RIFF\x24\x00\x00\x00WEBPVP8 ",
+		"Screenshot 2023-04-01 at 10.15.32": "\xFF\xD8\xFF\xE0",
+		"notes":                             "just text",
+		"photo.heic":                        "\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic",
+		"mislabeled.txt":                    "\x89PNG\r\n\x1a\n",
+		"unknown.png":                       "no signature",
+	}
+
+	for _, fix := range []bool{false, true} {
+		sourceDir := t.TempDir()
+		targetDir := t.TempDir()
+		for name, content := range files {
+			writeTestFile(t, filepath.Join(sourceDir, name), content, fileTime)
+		}
+		config := &Config{TargetDir: targetDir, TimeZone: "utc", FixExtensions: fix}
+		s, err := NewImageProcessor(config).ProcessDirectory(context.Background(), sourceDir, targetDir)
+		if err != nil {
+			t.Fatalf("ProcessDirectory() error = %v", err)
+		}
+
+		// Content decides for files without an extension; files with the
+		// extension of another kind of file are never read, and a known
+		// extension is trusted when the content is in no known format
+		want := []string{"Screenshot 2023-04-01 at 10.15.32", "download", "photo.heic", "shot.PNG.jpg", "unknown.png"}
+		if fix {
+			want = []string{"Screenshot 2023-04-01 at 10.15.32.jpg", "download.webp", "photo.heic", "shot.PNG.png", "unknown.png"}
+		}
+		var got []string
+		entries, _ := os.ReadDir(filepath.Join(targetDir, "2023"))
+		for _, e := range entries {
+			got = append(got, e.Name())
+		}
+		if strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("FixExtensions %v: sorted %q, want %q", fix, got, want)
+		}
+		if s.Mismatched != 1 || s.Skipped[ReasonUnsupported] != 2 {
+			t.Errorf("FixExtensions %v: Mismatched = %d, unsupported = %d; want 1, 2", fix, s.Mismatched, s.Skipped[ReasonUnsupported])
+		}
+	}
+
+	// Library users add formats without touching anything shared
+	config := &Config{Formats: []format.Format{{Name: "dng", Extensions: []string{".dng"}}}}
+	if !NewImageProcessor(config).Considers("raw.DNG") || NewImageProcessor(&Config{}).Considers("raw.dng") {
+		t.Error("Considers() should only accept .dng where the format was added")
+	}
+	if err := (&Config{Formats: []format.Format{{Name: "dng"}}}).Validate(); err == nil {
+		t.Error("Validate() should reject a format without extensions")
+	}
+}
+
+func TestImageProcessor_Photos(t *testing.T) {
+	os.Setenv("SCREENSHOT_SORTER_TEST_USE_MODTIME", "1")
+	defer os.Unsetenv("SCREENSHOT_SORTER_TEST_USE_MODTIME")
+
+	fileTime := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	files := map[string]string{
+		"Screenshot_20230401.gif": "GIF89a\x00\x05\xD0\x02\x00",
+		"IMG_0001.heic":           "\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic",
+	}
+
+	for _, policy := range PhotoPolicies {
+		sourceDir := t.TempDir()
+		targetDir := t.TempDir()
+		photoDir := filepath.Join(t.TempDir(), "photos")
+		for name, content := range files {
+			writeTestFile(t, filepath.Join(sourceDir, name), content, fileTime)
+		}
+		config := &Config{TargetDir: targetDir, TimeZone: "utc", Photos: string(policy)}
+		if policy == PhotosSeparate {
+			config.PhotoTarget = photoDir
+		}
+		s, err := NewImageProcessor(config).ProcessDirectory(context.Background(), sourceDir, targetDir)
+		if err != nil {
+			t.Fatalf("%s: ProcessDirectory() error = %v", policy, err)
+		}
+
+		if _, err := os.Stat(filepath.Join(targetDir, "2023", "Screenshot_20230401.gif")); err != nil {
+			t.Errorf("%s: screenshot not sorted: %v", policy, err)
+		}
+		photo := map[PhotoPolicy]string{
+			PhotosSort:     filepath.Join(targetDir, "2023", "IMG_0001.heic"),
+			PhotosSkip:     filepath.Join(sourceDir, "IMG_0001.heic"),
+			PhotosSeparate: filepath.Join(photoDir, "2023", "IMG_0001.heic"),
+		}[policy]
+		if _, err := os.Stat(photo); err != nil {
+			t.Errorf("%s: photo not at %s: %v", policy, photo, err)
+		}
+
+		// Nothing is classified when photos are sorted like the rest
+		wantPhotos, wantSkipped := 1, 0
+		if policy == PhotosSort {
+			wantPhotos = 0
+		}
+		if policy == PhotosSkip {
+			wantSkipped = 1
+		}
+		if s.Photos != wantPhotos || s.Skipped[ReasonPhoto] != wantSkipped {
+			t.Errorf("%s: Photos = %d, skipped = %d; want %d, %d", policy, s.Photos, s.Skipped[ReasonPhoto], wantPhotos, wantSkipped)
+		}
+	}
+
+	// Photos mirror the run's target, which library callers pass in
+	// rather than set in Config.TargetDir
+	sourceDir := t.TempDir()
+	photoDir := filepath.Join(t.TempDir(), "photos")
+	if err := os.Mkdir(filepath.Join(sourceDir, "trip"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(sourceDir, "trip", "IMG_0001.heic"), files["IMG_0001.heic"], fileTime)
+	config := &Config{TimeZone: "utc", Recursive: true, Photos: "separate", PhotoTarget: photoDir}
+	if _, err := NewImageProcessor(config).ProcessDirectory(context.Background(), sourceDir, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(photoDir, "trip", "2023", "IMG_0001.heic")); err != nil {
+		t.Errorf("photo not mirrored below the photo target: %v", err)
+	}
+
+	for _, config := range []*Config{
+		{Photos: "separate"},
+		{Photos: "skip", PhotoTarget: "photos"},
+		{Photos: "separate", PhotoTarget: "shots", TargetDir: "shots"},
+		{Photos: "keep"},
+		{Photos: "skip", ScreenshotThreshold: 1.5},
+	} {
+		if err := config.Validate(); err == nil {
+			t.Errorf("Validate(%+v) should fail", config)
+		}
+	}
+}
+
+func TestImageProcessor_CollectsFailures(t *testing.T) {
+	os.Setenv("SCREENSHOT_SORTER_TEST_USE_MODTIME", "1")
+	defer os.Unsetenv("SCREENSHOT_SORTER_TEST_USE_MODTIME")
+
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+	writeTestFile(t, filepath.Join(sourceDir, "bad.png"), "bad", time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC))
+	writeTestFile(t, filepath.Join(sourceDir, "good.png"), "good", time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC))
+	// A file where the 2021 bucket should be makes bad.png unsortable
+	writeTestFile(t, filepath.Join(targetDir, "2021"), "in the way", time.Now())
+
+	processor := NewImageProcessor(&Config{TargetDir: targetDir, TimeZone: "utc"})
+	_, err := processor.ProcessDirectory(context.Background(), sourceDir, targetDir)
+
+	var runErr *RunError
+	if !errors.As(err, &runErr) {
+		t.Fatalf("ProcessDirectory() error = %v, want *RunError", err)
+	}
+	if len(runErr.Errors) != 1 || runErr.Errors[0].Op != OpResolve || runErr.Errors[0].Path != filepath.Join(sourceDir, "bad.png") {
+		t.Fatalf("Errors = %v", runErr.Errors)
+	}
+	if !strings.Contains(runErr.Summary(0), "bad.png") {
+		t.Errorf("Summary() = %q", runErr.Summary(0))
+	}
+	// The failure does not stop the rest of the run
+	if _, err := os.Stat(filepath.Join(targetDir, "2022", "good.png")); err != nil {
+		t.Errorf("good.png was not sorted: %v", err)
+	}
+
+	// An unreadable source is fatal, not a partial failure
+	_, err = processor.ProcessDirectory(context.Background(), filepath.Join(sourceDir, "missing"), targetDir)
+	if err == nil || errors.As(err, &runErr) {
+		t.Errorf("ProcessDirectory(context.Background(), missing) error = %v, want a fatal error", err)
+	}
+}
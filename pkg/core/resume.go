@@ -0,0 +1,182 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/screenshot-sorter/pkg/checkpoint"
+)
+
+// resume records the progress of a ProcessDirectory run in a checkpoint
+// and, when resuming, decides which files an earlier run already finished
+type resume struct {
+	// state is the checkpoint being resumed, nil for a fresh run
+	state *checkpoint.State
+	// writer is nil in dry runs
+	writer *checkpoint.Writer
+	// finishedDirs caches, for the walker, whether each directory was
+	// finished and has not changed since
+	finishedDirs map[string]bool
+	// incomplete holds, for the collector, the directories with files
+	// that were not processed
+	incomplete map[string]bool
+}
+
+// fingerprint captures what decides which files are processed and where
+// each goes, so a checkpoint is only resumed by a run that would make the
+// same decisions
+func (p *ImageProcessor) fingerprint() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%q %q %q %q %q %q %v %v %q %q %q %v %q %q %v", p.layout, p.mode, p.conflict, p.location,
+		p.resolver.Names(), p.config.FilenamePatterns, p.config.Recursive, p.config.RemoveDuplicates,
+		p.config.Include, p.config.Exclude, p.formats.Names(), p.config.FixExtensions,
+		p.photos, p.config.PhotoTarget, p.config.ScreenshotThreshold)
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// openCheckpoint loads the checkpoint to resume, if Resume is set and there
+// is one, and opens the checkpoint of this run
+func (p *ImageProcessor) openCheckpoint(sourceDir, targetDir string) (*resume, error) {
+	r := &resume{finishedDirs: make(map[string]bool), incomplete: make(map[string]bool)}
+	header := checkpoint.Header{
+		Started:   time.Now(),
+		SourceDir: absPath(sourceDir),
+		TargetDir: absPath(targetDir),
+		Settings:  p.fingerprint(),
+		RunID:     p.runID,
+	}
+
+	if p.config.Resume {
+		state, err := checkpoint.Load(targetDir)
+		if err != nil {
+			return nil, fmt.Errorf("cannot resume: %w", err)
+		}
+		if state != nil {
+			if state.SourceDir != header.SourceDir || state.TargetDir != header.TargetDir || state.Settings != header.Settings {
+				return nil, fmt.Errorf("cannot resume: the checkpoint in %s was made with other directories or settings; run without -resume to start over", targetDir)
+			}
+			r.state = state
+			// Keep journaling to the interrupted run, so one undo reverses
+			// the whole import
+			if p.runID != "" && state.RunID != "" {
+				p.runID = state.RunID
+			}
+		}
+	}
+
+	if p.config.DryRun {
+		return r, nil
+	}
+	var err error
+	if r.state != nil {
+		r.writer, err = checkpoint.Append(targetDir)
+	} else {
+		r.writer, err = checkpoint.Create(targetDir, header)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return r, nil
+}
+
+// finished reports whether the checkpoint being resumed shows that the
+// file was already processed, and it has not changed since. Only the
+// walker calls it.
+func (r *resume) finished(ctx context.Context, p *ImageProcessor, dir string, entry os.DirEntry) bool {
+	if r.state == nil {
+		return false
+	}
+
+	// A directory whose modification time is unchanged has had no files
+	// added or removed since it was finished
+	done, ok := r.finishedDirs[dir]
+	if !ok {
+		if rec, ok := r.state.Dirs[absPath(dir)]; ok {
+			p.throttle.Do(ctx, func() error {
+				fi, err := os.Stat(dir)
+				done = err == nil && fi.ModTime().Equal(rec.ModTime)
+				return nil
+			})
+		}
+		r.finishedDirs[dir] = done
+	}
+	if done {
+		return true
+	}
+
+	rec, ok := r.state.Files[absPath(filepath.Join(dir, entry.Name()))]
+	if !ok {
+		return false
+	}
+	consistent := false
+	p.throttle.Do(ctx, func() error {
+		fi, err := entry.Info()
+		if err != nil || fi.Size() != rec.Size || !fi.ModTime().Equal(rec.ModTime) {
+			return nil
+		}
+		// The copy or link must still be there
+		if rec.Done {
+			dest, err := os.Lstat(rec.Dest)
+			if err != nil || dest.Size() != rec.Size {
+				return nil
+			}
+		}
+		consistent = true
+		return nil
+	})
+	return consistent
+}
+
+// file records a processed file. Only the collector calls it.
+func (r *resume) file(t *task) {
+	if t.err != nil || t.result == nil {
+		r.incomplete[t.sourceDir] = true
+		return
+	}
+	if r.writer == nil || t.candidate == nil {
+		return
+	}
+	r.writer.Add(checkpoint.Record{
+		Kind:    checkpoint.KindFile,
+		Path:    absPath(t.candidate.path),
+		ModTime: t.candidate.info.ModTime(),
+		Size:    t.candidate.info.Size(),
+		Dest:    absPath(t.result.Dest),
+		Done:    t.result.Done,
+	})
+}
+
+// dir records a directory once every file in it was processed. Only the
+// collector calls it.
+func (r *resume) dir(ctx context.Context, p *ImageProcessor, dir string) {
+	if r.writer == nil || r.incomplete[dir] {
+		return
+	}
+	p.throttle.Do(ctx, func() error {
+		fi, err := os.Stat(dir)
+		if err == nil {
+			r.writer.Add(checkpoint.Record{Kind: checkpoint.KindDir, Path: absPath(dir), ModTime: fi.ModTime()})
+		}
+		return nil
+	})
+}
+
+// close finishes the checkpoint. A complete run has nothing left to
+// resume, so its checkpoint is removed.
+func (r *resume) close(targetDir string, complete bool) error {
+	if r.writer == nil {
+		return nil
+	}
+	if err := r.writer.Close(); err != nil {
+		return err
+	}
+	if complete {
+		return checkpoint.Remove(targetDir)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/screenshot-sorter/pkg/checkpoint"
+	"github.com/screenshot-sorter/pkg/fileutils"
+	"github.com/screenshot-sorter/pkg/journal"
+)
+
+// cancelResolver returns a fixed time and cancels a run once it has
+// resolved a number of files
+type cancelResolver struct {
+	t      time.Time
+	after  int32
+	calls  *int32
+	cancel func()
+}
+
+func (r cancelResolver) Name() string { return "fixed" }
+
+func (r cancelResolver) Resolve(string, os.FileInfo, *time.Location) (time.Time, bool, error) {
+	if atomic.AddInt32(r.calls, 1) == r.after && r.cancel != nil {
+		r.cancel()
+	}
+	return r.t, true, nil
+}
+
+// countFiles counts the regular files below dir, outside the sorter's
+// state directory
+func countFiles(t *testing.T, dir string) int {
+	t.Helper()
+	n := 0
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == journal.DirName {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() {
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// TestResumeInterrupted copies a tree, interrupts the copy part way and
+// resumes it. The resumed run must skip what was copied, redo a copy that
+// went missing and leave exactly one copy of each file.
+func TestResumeInterrupted(t *testing.T) {
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+	fileTime := time.Date(2023, 4, 1, 10, 22, 33, 0, time.UTC)
+	if err := os.Mkdir(filepath.Join(sourceDir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	const files = 20
+	for i := 0; i < files/2; i++ {
+		writeTestFile(t, filepath.Join(sourceDir, fmt.Sprintf("a%02d.png", i)), fmt.Sprint(i), fileTime)
+		writeTestFile(t, filepath.Join(sourceDir, "sub", fmt.Sprintf("b%02d.png", i)), fmt.Sprint(i), fileTime)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls int32
+	config := func(resolver cancelResolver) *Config {
+		return &Config{
+			TargetDir: targetDir, TimeZone: "utc", Mode: "copy", Recursive: true, Workers: 1,
+			TimeSources: []string{"fixed"}, TimeResolvers: []fileutils.TimeResolver{resolver},
+		}
+	}
+	first := NewImageProcessor(config(cancelResolver{t: fileTime, after: 14, calls: &calls, cancel: cancel}))
+	if _, err := first.ProcessDirectory(ctx, sourceDir, targetDir); !errors.Is(err, ErrInterrupted) {
+		t.Fatalf("first ProcessDirectory() error = %v, want ErrInterrupted", err)
+	}
+	copied := countFiles(t, targetDir)
+	if copied == files {
+		t.Fatal("the first run copied everything before it was interrupted")
+	}
+	if _, err := os.Stat(checkpoint.Path(targetDir)); copied > 0 && err != nil {
+		t.Fatalf("no checkpoint after an interrupted run: %v", err)
+	}
+
+	// A copy that went missing is made again
+	missing := 0
+	if copied > 0 {
+		if err := os.Remove(filepath.Join(targetDir, "2023", "a00.png")); err != nil {
+			t.Fatal(err)
+		}
+		missing = 1
+	}
+
+	c := config(cancelResolver{t: fileTime, calls: new(int32)})
+	c.Resume = true
+	second := NewImageProcessor(c)
+	s, err := second.ProcessDirectory(context.Background(), sourceDir, targetDir)
+	if err != nil {
+		t.Fatalf("resumed ProcessDirectory() error = %v", err)
+	}
+	if s.Resumed != copied-missing || s.Transferred != files-copied+missing {
+		t.Errorf("resumed run skipped %d and copied %d files; %d were copied before, %d went missing",
+			s.Resumed, s.Transferred, copied, missing)
+	}
+	if n := countFiles(t, targetDir); n != files {
+		t.Errorf("target holds %d files after resuming, want %d", n, files)
+	}
+	if _, err := os.Stat(checkpoint.Path(targetDir)); !os.IsNotExist(err) {
+		t.Errorf("checkpoint left behind by a complete run: %v", err)
+	}
+	if copied > 0 && second.RunID() != first.RunID() {
+		t.Errorf("resumed run journaled to %s, want the interrupted run %s", second.RunID(), first.RunID())
+	}
+}
+
+// TestResumeChecks resumes a hand-written checkpoint whose records no
+// longer all match the source
+func TestResumeChecks(t *testing.T) {
+	os.Setenv("SCREENSHOT_SORTER_TEST_USE_MODTIME", "1")
+	defer os.Unsetenv("SCREENSHOT_SORTER_TEST_USE_MODTIME")
+
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+	fileTime := time.Date(2023, 4, 1, 10, 22, 33, 0, time.UTC)
+	sub := filepath.Join(sourceDir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"kept.png", "changed.png", "new.png", "sub/done.png"} {
+		writeTestFile(t, filepath.Join(sourceDir, name), name, fileTime)
+	}
+	subInfo, err := os.Stat(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Config{TargetDir: targetDir, TimeZone: "utc", OnConflict: string(ConflictSkip), Recursive: true, Resume: true}
+	p := NewImageProcessor(config)
+	w, _ := checkpoint.Create(targetDir, checkpoint.Header{
+		SourceDir: absPath(sourceDir), TargetDir: absPath(targetDir), Settings: p.fingerprint(),
+	})
+	w.Add(checkpoint.Record{Kind: checkpoint.KindFile, Path: absPath(filepath.Join(sourceDir, "kept.png")), ModTime: fileTime, Size: 8})
+	// changed.png was touched since
+	w.Add(checkpoint.Record{Kind: checkpoint.KindFile, Path: absPath(filepath.Join(sourceDir, "changed.png")), ModTime: fileTime.Add(-time.Hour), Size: 11})
+	w.Add(checkpoint.Record{Kind: checkpoint.KindDir, Path: absPath(sub), ModTime: subInfo.ModTime()})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := p.ProcessDirectory(context.Background(), sourceDir, targetDir)
+	if err != nil {
+		t.Fatalf("ProcessDirectory() error = %v", err)
+	}
+	if s.Resumed != 2 || s.Transferred != 2 {
+		t.Errorf("Resumed = %d, Transferred = %d; want 2 and 2", s.Resumed, s.Transferred)
+	}
+	for _, name := range []string{"kept.png", "sub/done.png"} {
+		if _, err := os.Stat(filepath.Join(sourceDir, name)); err != nil {
+			t.Errorf("%s should have been skipped: %v", name, err)
+		}
+	}
+	for _, name := range []string{"changed.png", "new.png"} {
+		if _, err := os.Stat(filepath.Join(targetDir, "2023", name)); err != nil {
+			t.Errorf("%s should have been moved: %v", name, err)
+		}
+	}
+
+	// A checkpoint only applies to the same settings
+	w, _ = checkpoint.Create(targetDir, checkpoint.Header{SourceDir: absPath(sourceDir), TargetDir: absPath(targetDir), Settings: p.fingerprint()})
+	w.Add(checkpoint.Record{Kind: checkpoint.KindFile, Path: absPath(filepath.Join(sourceDir, "kept.png"))})
+	w.Close()
+	config.Layout = "{year}/{month:02}"
+	if _, err := NewImageProcessor(config).ProcessDirectory(context.Background(), sourceDir, targetDir); err == nil {
+		t.Error("ProcessDirectory() should refuse to resume with another layout")
+	}
+}
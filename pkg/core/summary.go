@@ -0,0 +1,154 @@
+package core
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/screenshot-sorter/pkg/classify"
+)
+
+// ReasonUnsupported is the skip reason of files that are not supported
+// images
+const ReasonUnsupported = "unsupported format"
+
+// FileResult describes what happened to a single file
+type FileResult struct {
+	// Operation is what was done, or what would have been done in a dry
+	// run. Files that are not supported images are skipped with
+	// ReasonUnsupported and have no destination.
+	Operation
+	// Done is set when the file was transferred or removed, or would have
+	// been in a dry run
+	Done bool
+	// Unsupported is set for files that are not supported images
+	Unsupported bool
+}
+
+// Summary is the outcome of a ProcessDirectory run
+type Summary struct {
+	Started time.Time     `json:"started"`
+	Elapsed time.Duration `json:"-"`
+	DryRun  bool          `json:"dry_run"`
+	Mode    string        `json:"mode"`
+	// Scanned counts the files looked at, supported or not
+	Scanned int `json:"scanned"`
+	// Transferred counts the files moved, copied or linked into the target,
+	// and Bytes their total size
+	Transferred int   `json:"transferred"`
+	Bytes       int64 `json:"bytes"`
+	// Removed counts duplicates deleted from the source
+	Removed int `json:"removed"`
+	// Resumed counts the files skipped because the run being resumed
+	// had already finished them
+	Resumed int `json:"resumed"`
+	// Excluded counts the files and directories left out by -include,
+	// -exclude or a .sortignore file; an excluded directory counts once
+	Excluded int `json:"excluded"`
+	// Mismatched counts the files whose content is in another format than
+	// their extension says
+	Mismatched int `json:"mismatched"`
+	// Photos counts the files classified as camera photos
+	Photos int `json:"photos"`
+	// Skipped counts the files left alone, by reason
+	Skipped map[string]int `json:"skipped"`
+	// Conflicts counts the files whose destination name was taken and
+	// that the conflict policy dealt with
+	Conflicts int `json:"conflicts"`
+	// Buckets breaks the transferred files down by destination directory,
+	// relative to the target
+	Buckets map[string]*BucketSummary `json:"buckets"`
+	// Errors lists the files and directories that could not be processed
+	Errors []*ProcessError `json:"errors"`
+	// RunID names the undo journal of the run, if anything was journaled
+	RunID string `json:"run_id,omitempty"`
+	// Interrupted is set when the run was canceled before every file was
+	// processed
+	Interrupted bool `json:"interrupted"`
+}
+
+// BucketSummary counts the files transferred into one destination
+// directory
+type BucketSummary struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+	// First and Last are the earliest and latest capture times among them
+	First time.Time `json:"first"`
+	Last  time.Time `json:"last"`
+}
+
+func newSummary(started time.Time, dryRun bool, mode string) *Summary {
+	return &Summary{
+		Started: started,
+		DryRun:  dryRun,
+		Mode:    mode,
+		Skipped: make(map[string]int),
+		Buckets: make(map[string]*BucketSummary),
+		Errors:  []*ProcessError{},
+	}
+}
+
+// add counts the result of one file, whose destination lies below
+// targetDir
+func (s *Summary) add(res *FileResult, targetDir string) {
+	s.Scanned++
+	if res.Decision != "" {
+		s.Conflicts++
+	}
+	if res.Mismatch {
+		s.Mismatched++
+	}
+	if res.Kind == string(classify.Photo) {
+		s.Photos++
+	}
+	switch {
+	case !res.Done:
+		s.Skipped[res.Reason]++
+	case res.Action == ActionRemove:
+		s.Removed++
+	default:
+		s.Transferred++
+		s.Bytes += res.Size
+
+		// Photos sorted into their own target are listed by full path
+		bucket, err := filepath.Rel(absPath(targetDir), absPath(filepath.Dir(res.Dest)))
+		if err != nil || bucket == ".." || strings.HasPrefix(bucket, ".."+string(filepath.Separator)) {
+			bucket = absPath(filepath.Dir(res.Dest))
+		}
+		bucket = filepath.ToSlash(bucket)
+		b := s.Buckets[bucket]
+		if b == nil {
+			b = &BucketSummary{First: res.Time, Last: res.Time}
+			s.Buckets[bucket] = b
+		}
+		b.Files++
+		b.Bytes += res.Size
+		if res.Time.Before(b.First) {
+			b.First = res.Time
+		}
+		if res.Time.After(b.Last) {
+			b.Last = res.Time
+		}
+	}
+}
+
+// MarshalJSON adds the elapsed time in seconds
+func (s *Summary) MarshalJSON() ([]byte, error) {
+	// summary drops the method, so encoding it does not recurse
+	type summary Summary
+	return json.Marshal(struct {
+		*summary
+		ElapsedSeconds float64 `json:"elapsed_seconds"`
+	}{(*summary)(s), s.Elapsed.Seconds()})
+}
+
+// MarshalJSON encodes the underlying error as its message
+func (e *ProcessError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Path  string `json:"path"`
+		Op    string `json:"op"`
+		Dest  string `json:"dest,omitempty"`
+		Error string `json:"error"`
+	}{e.Path, e.Op, e.Dest, e.Err.Error()})
+}
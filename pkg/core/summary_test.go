@@ -0,0 +1,94 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessDirectorySummary(t *testing.T) {
+	os.Setenv("SCREENSHOT_SORTER_TEST_USE_MODTIME", "1")
+	defer os.Unsetenv("SCREENSHOT_SORTER_TEST_USE_MODTIME")
+
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+	april := time.Date(2023, 4, 1, 10, 0, 0, 0, time.UTC)
+	may := time.Date(2023, 5, 2, 10, 0, 0, 0, time.UTC)
+	writeTestFile(t, filepath.Join(sourceDir, "a.png"), "aaa", april)
+	writeTestFile(t, filepath.Join(sourceDir, "b.png"), "bb", may)
+	writeTestFile(t, filepath.Join(sourceDir, "dup.png"), "same", april)
+	writeTestFile(t, filepath.Join(sourceDir, "taken.png"), "new", april)
+	writeTestFile(t, filepath.Join(sourceDir, "notes.txt"), "text", april)
+	if err := os.MkdirAll(filepath.Join(targetDir, "2023"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(targetDir, "2023", "dup.png"), "same", april)
+	writeTestFile(t, filepath.Join(targetDir, "2023", "taken.png"), "old", april)
+
+	processor := NewImageProcessor(&Config{
+		TargetDir: targetDir, TimeZone: "utc", OnConflict: string(ConflictSkipIdentical),
+	})
+	s, err := processor.ProcessDirectory(context.Background(), sourceDir, targetDir)
+	if err != nil {
+		t.Fatalf("ProcessDirectory() error = %v", err)
+	}
+
+	if s.Scanned != 5 || s.Transferred != 3 || s.Bytes != 8 || s.Conflicts != 2 || len(s.Errors) != 0 {
+		t.Errorf("summary = %+v", s)
+	}
+	wantSkipped := map[string]int{ReasonUnsupported: 1, "identical file exists": 1}
+	if len(s.Skipped) != len(wantSkipped) {
+		t.Errorf("Skipped = %v, want %v", s.Skipped, wantSkipped)
+	}
+	for reason, n := range wantSkipped {
+		if s.Skipped[reason] != n {
+			t.Errorf("Skipped[%q] = %d, want %d", reason, s.Skipped[reason], n)
+		}
+	}
+	b := s.Buckets["2023"]
+	if len(s.Buckets) != 1 || b == nil || b.Files != 3 || b.Bytes != 8 || !b.First.Equal(april) || !b.Last.Equal(may) {
+		t.Errorf("Buckets = %v, 2023 = %+v", s.Buckets, b)
+	}
+	if s.RunID == "" || s.RunID != processor.RunID() {
+		t.Errorf("RunID = %q, want %q", s.RunID, processor.RunID())
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	for _, field := range []string{`"scanned":5`, `"transferred":3`, `"elapsed_seconds":`, `"buckets":{"2023":`} {
+		if !strings.Contains(string(data), field) {
+			t.Errorf("JSON %s lacks %s", data, field)
+		}
+	}
+}
+
+func TestProcessDirectorySummaryErrors(t *testing.T) {
+	os.Setenv("SCREENSHOT_SORTER_TEST_USE_MODTIME", "1")
+	defer os.Unsetenv("SCREENSHOT_SORTER_TEST_USE_MODTIME")
+
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+	writeTestFile(t, filepath.Join(sourceDir, "bad.png"), "bad", time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC))
+	writeTestFile(t, filepath.Join(targetDir, "2021"), "in the way", time.Now())
+
+	s, err := NewImageProcessor(&Config{TargetDir: targetDir, TimeZone: "utc"}).ProcessDirectory(context.Background(), sourceDir, targetDir)
+	if err == nil || s == nil {
+		t.Fatalf("ProcessDirectory() = %v, %v", s, err)
+	}
+	if s.Scanned != 1 || s.Transferred != 0 || len(s.Errors) != 1 {
+		t.Errorf("summary = %+v", s)
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	if !strings.Contains(string(data), `"op":"resolve conflict"`) || !strings.Contains(string(data), `"error":"`) {
+		t.Errorf("JSON errors = %s", data)
+	}
+}
@@ -0,0 +1,358 @@
+package fileutils
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ErrNoEXIF is returned when a file does not contain an EXIF block
+var ErrNoEXIF = errors.New("no EXIF data")
+
+// errNotJPEG is returned for streams that do not start like a JPEG file
+var errNotJPEG = errors.New("not a JPEG stream")
+
+// EXIF holds the tags the sorter reads from an EXIF block. String values
+// are kept as stored, with trailing NULs and spaces removed.
+type EXIF struct {
+	DateTimeOriginal    string
+	DateTimeDigitized   string
+	DateTime            string
+	OffsetTimeOriginal  string
+	OffsetTimeDigitized string
+	OffsetTime          string
+	// Make and Model name the camera
+	Make  string
+	Model string
+	// Exposure is set when the block records how the picture was
+	// exposed: exposure time, aperture, ISO speed or focal length
+	Exposure bool
+}
+
+const (
+	tagMake                = 0x010F
+	tagModel               = 0x0110
+	tagDateTime            = 0x0132
+	tagExifIFD             = 0x8769
+	tagDateTimeOriginal    = 0x9003
+	tagDateTimeDigitized   = 0x9004
+	tagOffsetTime          = 0x9010
+	tagOffsetTimeOriginal  = 0x9011
+	tagOffsetTimeDigitized = 0x9012
+	tagExposureTime        = 0x829A
+	tagFNumber             = 0x829D
+	tagISOSpeed            = 0x8827
+	tagFocalLength         = 0x920A
+
+	// maxIFDEntries bounds the work done on corrupt or hostile input
+	maxIFDEntries = 1024
+	// maxSegmentSize is the largest a JPEG marker segment can be
+	maxSegmentSize = 0xFFFF
+)
+
+// typeSizes gives the byte size of each TIFF field type
+var typeSizes = map[uint16]uint32{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 13: 4,
+}
+
+// ParseEXIF parses a TIFF-structured EXIF payload, i.e. the bytes that
+// follow the "Exif\x00\x00" header in a JPEG APP1 segment
+func ParseEXIF(data []byte) (*EXIF, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("EXIF header truncated")
+	}
+
+	var order binary.ByteOrder
+	switch string(data[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid EXIF byte order marker")
+	}
+
+	p := &exifParser{data: data, order: order, visited: map[uint32]bool{}}
+	e := &EXIF{}
+	if err := p.walk(order.Uint32(data[4:8]), e, false); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+type exifParser struct {
+	data    []byte
+	order   binary.ByteOrder
+	visited map[uint32]bool
+}
+
+// walk reads one IFD and, for IFD0, follows the Exif sub-IFD pointer.
+// Offsets are checked against the payload and each IFD is read at most
+// once, so loops and out-of-range pointers cannot hang or panic.
+func (p *exifParser) walk(offset uint32, e *EXIF, inExif bool) error {
+	if p.visited[offset] {
+		return fmt.Errorf("EXIF IFD loop at offset %d", offset)
+	}
+	p.visited[offset] = true
+
+	if uint64(offset)+2 > uint64(len(p.data)) {
+		return fmt.Errorf("EXIF IFD offset %d out of range", offset)
+	}
+	count := uint32(p.order.Uint16(p.data[offset:]))
+	if count > maxIFDEntries {
+		return fmt.Errorf("EXIF IFD has too many entries (%d)", count)
+	}
+	if uint64(offset)+2+uint64(count)*12 > uint64(len(p.data)) {
+		return fmt.Errorf("EXIF IFD at offset %d truncated", offset)
+	}
+
+	var exifOffset uint32
+	for i := uint32(0); i < count; i++ {
+		entry := p.data[offset+2+i*12 : offset+2+(i+1)*12]
+		tag := p.order.Uint16(entry[0:2])
+		typ := p.order.Uint16(entry[2:4])
+		n := p.order.Uint32(entry[4:8])
+
+		switch {
+		case !inExif && tag == tagExifIFD && (typ == 4 || typ == 13):
+			exifOffset = p.order.Uint32(entry[8:12])
+		case !inExif && tag == tagDateTime:
+			e.DateTime = p.ascii(entry, typ, n)
+		case !inExif && tag == tagMake:
+			e.Make = p.ascii(entry, typ, n)
+		case !inExif && tag == tagModel:
+			e.Model = p.ascii(entry, typ, n)
+		case inExif && (tag == tagExposureTime || tag == tagFNumber || tag == tagISOSpeed || tag == tagFocalLength):
+			e.Exposure = true
+		case inExif && tag == tagDateTimeOriginal:
+			e.DateTimeOriginal = p.ascii(entry, typ, n)
+		case inExif && tag == tagDateTimeDigitized:
+			e.DateTimeDigitized = p.ascii(entry, typ, n)
+		case inExif && tag == tagOffsetTime:
+			e.OffsetTime = p.ascii(entry, typ, n)
+		case inExif && tag == tagOffsetTimeOriginal:
+			e.OffsetTimeOriginal = p.ascii(entry, typ, n)
+		case inExif && tag == tagOffsetTimeDigitized:
+			e.OffsetTimeDigitized = p.ascii(entry, typ, n)
+		}
+	}
+
+	if exifOffset != 0 {
+		return p.walk(exifOffset, e, true)
+	}
+	return nil
+}
+
+// value returns the raw bytes of an entry, which are stored inline when
+// they fit in four bytes and at an offset otherwise
+func (p *exifParser) value(entry []byte, typ uint16, n uint32) []byte {
+	size, ok := typeSizes[typ]
+	if !ok || n == 0 || n > maxSegmentSize {
+		return nil
+	}
+	total := size * n
+	if total <= 4 {
+		return entry[8 : 8+total]
+	}
+	off := p.order.Uint32(entry[8:12])
+	if uint64(off)+uint64(total) > uint64(len(p.data)) {
+		return nil
+	}
+	return p.data[off : off+total]
+}
+
+func (p *exifParser) ascii(entry []byte, typ uint16, n uint32) string {
+	if typ != 2 && typ != 7 {
+		return ""
+	}
+	return strings.TrimRight(string(p.value(entry, typ, n)), "\x00 ")
+}
+
+// ReadJPEGEXIF scans the marker segments of a JPEG stream for an EXIF APP1
+// segment. It stops at the start of the image data and never decodes
+// pixels. ErrNoEXIF is returned if the image has no EXIF block.
+func ReadJPEGEXIF(r io.Reader) (*EXIF, error) {
+	var exif *EXIF
+	err := scanJPEG(r, isAPP1, func(_ byte, payload []byte) (bool, error) {
+		// APP1 is also used for XMP; keep looking if this isn't EXIF
+		if !isEXIF(payload) {
+			return false, nil
+		}
+		var err error
+		exif, err = ParseEXIF(payload[6:])
+		return true, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if exif == nil {
+		return nil, ErrNoEXIF
+	}
+	return exif, nil
+}
+
+// JPEGInfo is what the header segments of a JPEG stream tell about the
+// image
+type JPEGInfo struct {
+	Width, Height int
+	// EXIF is nil if the image has no EXIF block
+	EXIF *EXIF
+}
+
+// ReadJPEGInfo reads the size of a JPEG image and its EXIF block. Like
+// ReadJPEGEXIF it stops at the start of the image data.
+func ReadJPEGInfo(r io.Reader) (*JPEGInfo, error) {
+	info := &JPEGInfo{}
+	want := func(marker byte) bool { return isAPP1(marker) || isSOF(marker) }
+	err := scanJPEG(r, want, func(marker byte, payload []byte) (bool, error) {
+		switch {
+		case isSOF(marker):
+			if len(payload) < 5 {
+				return false, fmt.Errorf("truncated JPEG frame header")
+			}
+			info.Height = int(binary.BigEndian.Uint16(payload[1:3]))
+			info.Width = int(binary.BigEndian.Uint16(payload[3:5]))
+		case info.EXIF == nil && isEXIF(payload):
+			exif, err := ParseEXIF(payload[6:])
+			if err != nil {
+				return false, err
+			}
+			info.EXIF = exif
+		}
+		return info.Width != 0 && info.EXIF != nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func isAPP1(marker byte) bool {
+	return marker == 0xE1
+}
+
+// isSOF reports whether marker starts a frame, whose header holds the
+// size of the image. C4, C8 and CC share the range but are other segments.
+func isSOF(marker byte) bool {
+	return marker >= 0xC0 && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC
+}
+
+func isEXIF(payload []byte) bool {
+	return len(payload) >= 6 && string(payload[:6]) == "Exif\x00\x00"
+}
+
+// scanJPEG calls visit with the payload of each marker segment before the
+// image data that want accepts, and skips the others. It stops early once
+// visit returns done or an error.
+func scanJPEG(r io.Reader, want func(marker byte) bool, visit func(marker byte, payload []byte) (done bool, err error)) error {
+	br := bufio.NewReader(r)
+
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil || soi != [2]byte{0xFF, 0xD8} {
+		return errNotJPEG
+	}
+
+	for {
+		marker, err := nextMarker(br)
+		if err != nil {
+			return err
+		}
+		switch {
+		case marker == 0xD9 || marker == 0xDA: // EOI, SOS
+			return nil
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7): // no payload
+			continue
+		}
+
+		var lenBuf [2]byte
+		if _, err := io.ReadFull(br, lenBuf[:]); err != nil {
+			return fmt.Errorf("truncated JPEG segment: %w", err)
+		}
+		length := int(binary.BigEndian.Uint16(lenBuf[:]))
+		if length < 2 {
+			return fmt.Errorf("invalid JPEG segment length %d", length)
+		}
+		length -= 2
+
+		if !want(marker) {
+			if _, err := br.Discard(length); err != nil {
+				return fmt.Errorf("truncated JPEG segment: %w", err)
+			}
+			continue
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			return fmt.Errorf("truncated JPEG segment 0x%02X: %w", marker, err)
+		}
+		if done, err := visit(marker, payload); done || err != nil {
+			return err
+		}
+	}
+}
+
+// nextMarker reads the next marker code, skipping fill bytes
+func nextMarker(br *bufio.Reader) (byte, error) {
+	b, err := br.ReadByte()
+	if err != nil {
+		return 0, fmt.Errorf("truncated JPEG stream: %w", err)
+	}
+	if b != 0xFF {
+		return 0, fmt.Errorf("invalid JPEG marker 0x%02X", b)
+	}
+	for b == 0xFF {
+		if b, err = br.ReadByte(); err != nil {
+			return 0, fmt.Errorf("truncated JPEG stream: %w", err)
+		}
+	}
+	return b, nil
+}
+
+// Time returns the best capture time recorded in the EXIF block, trying
+// DateTimeOriginal, DateTimeDigitized and DateTime in that order. When the
+// matching OffsetTime tag is present the result carries that offset;
+// otherwise the wall-clock time is interpreted in loc.
+func (e *EXIF) Time(loc *time.Location) (time.Time, bool) {
+	candidates := []struct{ value, offset string }{
+		{e.DateTimeOriginal, e.OffsetTimeOriginal},
+		{e.DateTimeDigitized, e.OffsetTimeDigitized},
+		{e.DateTime, e.OffsetTime},
+	}
+	for _, c := range candidates {
+		if t, ok := parseEXIFTime(c.value, c.offset, loc); ok {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func parseEXIFTime(value, offset string, loc *time.Location) (time.Time, bool) {
+	if value == "" || strings.HasPrefix(value, "0000") {
+		return time.Time{}, false
+	}
+	if zone, ok := parseEXIFOffset(offset); ok {
+		loc = zone
+	}
+	t, err := time.ParseInLocation("2006:01:02 15:04:05", value, loc)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// parseEXIFOffset parses an OffsetTime value such as "+02:00"
+func parseEXIFOffset(offset string) (*time.Location, bool) {
+	if offset == "" {
+		return nil, false
+	}
+	t, err := time.Parse("-07:00", offset)
+	if err != nil {
+		return nil, false
+	}
+	_, secs := t.Zone()
+	return time.FixedZone(offset, secs), true
+}
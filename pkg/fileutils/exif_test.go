@@ -0,0 +1,200 @@
+package fileutils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+type testTag struct {
+	tag   uint16
+	value string
+}
+
+// buildEXIF assembles a minimal TIFF payload with the given IFD0 and Exif
+// sub-IFD ASCII tags
+func buildEXIF(order binary.ByteOrder, ifd0, exifIFD []testTag) []byte {
+	var buf bytes.Buffer
+	if order == binary.LittleEndian {
+		buf.WriteString("II*\x00")
+	} else {
+		buf.WriteString("MM\x00*")
+	}
+	binary.Write(&buf, order, uint32(8))
+
+	ifd0Size := 2 + (len(ifd0)+1)*12 + 4
+	exifStart := 8 + ifd0Size
+	exifSize := 2 + len(exifIFD)*12 + 4
+	dataStart := exifStart + exifSize
+
+	var data bytes.Buffer
+	writeIFD := func(tags []testTag, exifPtr bool) {
+		count := len(tags)
+		if exifPtr {
+			count++
+		}
+		binary.Write(&buf, order, uint16(count))
+		for _, t := range tags {
+			value := t.value + "\x00"
+			binary.Write(&buf, order, t.tag)
+			binary.Write(&buf, order, uint16(2))
+			binary.Write(&buf, order, uint32(len(value)))
+			if len(value) <= 4 {
+				var inline [4]byte
+				copy(inline[:], value)
+				buf.Write(inline[:])
+			} else {
+				binary.Write(&buf, order, uint32(dataStart+data.Len()))
+				data.WriteString(value)
+			}
+		}
+		if exifPtr {
+			binary.Write(&buf, order, uint16(tagExifIFD))
+			binary.Write(&buf, order, uint16(4))
+			binary.Write(&buf, order, uint32(1))
+			binary.Write(&buf, order, uint32(exifStart))
+		}
+		binary.Write(&buf, order, uint32(0))
+	}
+	writeIFD(ifd0, true)
+	writeIFD(exifIFD, false)
+	buf.Write(data.Bytes())
+	return buf.Bytes()
+}
+
+// buildJPEG wraps an EXIF payload in a JPEG stream with an APP0 segment
+// before it, as most cameras write
+func buildJPEG(exif []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0xFF, 0xD8})
+	buf.Write([]byte{0xFF, 0xE0, 0x00, 0x07, 'J', 'F', 'I', 'F', 0x00})
+	if exif != nil {
+		payload := append([]byte("Exif\x00\x00"), exif...)
+		buf.Write([]byte{0xFF, 0xE1})
+		binary.Write(&buf, binary.BigEndian, uint16(len(payload)+2))
+		buf.Write(payload)
+	}
+	buf.Write([]byte{0xFF, 0xDA, 0x00, 0x02, 0x00})
+	buf.Write([]byte{0xFF, 0xD9})
+	return buf.Bytes()
+}
+
+func TestReadJPEGEXIF(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		t.Run(order.String(), func(t *testing.T) {
+			payload := buildEXIF(order,
+				[]testTag{{tagDateTime, "2024:01:01 00:00:00"}},
+				[]testTag{
+					{tagDateTimeOriginal, "2023:04:01 10:22:33"},
+					{tagOffsetTimeOriginal, "+02:00"},
+				})
+
+			exif, err := ReadJPEGEXIF(bytes.NewReader(buildJPEG(payload)))
+			if err != nil {
+				t.Fatalf("ReadJPEGEXIF() error = %v", err)
+			}
+
+			got, ok := exif.Time(time.UTC)
+			if !ok {
+				t.Fatal("Time() found no timestamp")
+			}
+			want := time.Date(2023, 4, 1, 8, 22, 33, 0, time.UTC)
+			if !got.Equal(want) {
+				t.Errorf("Time() = %v, want %v", got, want)
+			}
+			if _, offset := got.Zone(); offset != 2*3600 {
+				t.Errorf("Time() offset = %d, want %d", offset, 2*3600)
+			}
+		})
+	}
+}
+
+func TestReadJPEGInfo(t *testing.T) {
+	payload := buildEXIF(binary.BigEndian,
+		[]testTag{{tagMake, "Google"}, {tagModel, "Pixel 8"}},
+		[]testTag{{tagExposureTime, "1/120"}})
+	data := buildJPEG(payload)
+	// Frame header of a 4080x3072 image, just before the scan
+	sof := []byte{0xFF, 0xC2, 0x00, 0x11, 8, 0x0C, 0x00, 0x0F, 0xF0, 3, 1, 0x22, 0, 2, 0x11, 1, 3, 0x11, 1}
+	i := bytes.Index(data, []byte{0xFF, 0xDA})
+	data = append(append(append([]byte{}, data[:i]...), sof...), data[i:]...)
+
+	info, err := ReadJPEGInfo(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadJPEGInfo() error = %v", err)
+	}
+	if info.Width != 4080 || info.Height != 3072 {
+		t.Errorf("size = %dx%d, want 4080x3072", info.Width, info.Height)
+	}
+	if info.EXIF == nil || info.EXIF.Make != "Google" || info.EXIF.Model != "Pixel 8" || !info.EXIF.Exposure {
+		t.Errorf("EXIF = %+v, want the camera and its exposure", info.EXIF)
+	}
+
+	// A screenshot without EXIF
+	info, err = ReadJPEGInfo(bytes.NewReader(buildJPEG(nil)))
+	if err != nil || info.EXIF != nil {
+		t.Errorf("ReadJPEGInfo() = %+v, %v; want no EXIF", info, err)
+	}
+	if _, err := ReadJPEGInfo(bytes.NewReader([]byte("not a jpeg"))); err == nil {
+		t.Error("ReadJPEGInfo() should reject non-JPEG input")
+	}
+}
+
+func TestEXIFTimeFallback(t *testing.T) {
+	payload := buildEXIF(binary.LittleEndian,
+		[]testTag{{tagDateTime, "2021:06:15 12:00:00"}},
+		[]testTag{{tagDateTimeOriginal, "0000:00:00 00:00:00"}})
+
+	exif, err := ParseEXIF(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loc := time.FixedZone("test", -5*3600)
+	got, ok := exif.Time(loc)
+	if !ok {
+		t.Fatal("Time() found no timestamp")
+	}
+	if want := time.Date(2021, 6, 15, 12, 0, 0, 0, loc); !got.Equal(want) {
+		t.Errorf("Time() = %v, want %v", got, want)
+	}
+}
+
+func TestReadJPEGEXIFMissing(t *testing.T) {
+	if _, err := ReadJPEGEXIF(bytes.NewReader(buildJPEG(nil))); !errors.Is(err, ErrNoEXIF) {
+		t.Errorf("ReadJPEGEXIF() error = %v, want ErrNoEXIF", err)
+	}
+	if _, err := ReadJPEGEXIF(bytes.NewReader([]byte("not a jpeg"))); err == nil {
+		t.Error("ReadJPEGEXIF() should reject non-JPEG input")
+	}
+}
+
+func TestParseEXIFMalformed(t *testing.T) {
+	valid := buildEXIF(binary.BigEndian, nil, []testTag{{tagDateTimeOriginal, "2023:04:01 10:22:33"}})
+
+	// IFD0 whose Exif pointer refers back to itself
+	loop := buildEXIF(binary.LittleEndian, nil, nil)
+	binary.LittleEndian.PutUint32(loop[8+2+8:], 8)
+
+	tests := map[string][]byte{
+		"empty":      {},
+		"bad order":  []byte("XX*\x00\x08\x00\x00\x00"),
+		"bad offset": []byte("II*\x00\xff\xff\x00\x00"),
+		"loop":       loop,
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseEXIF(data); err == nil {
+				t.Error("ParseEXIF() expected error")
+			}
+		})
+	}
+
+	// Every truncation of a valid payload must be handled without panicking
+	for i := range valid {
+		ParseEXIF(valid[:i])
+		ReadJPEGEXIF(bytes.NewReader(buildJPEG(valid[:i])))
+	}
+}
@@ -0,0 +1,136 @@
+package fileutils
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FilenamePattern extracts a capture time from a file name. The expression
+// must define the named groups year, month and day, and may define hour,
+// minute, second and ampm.
+type FilenamePattern struct {
+	Name   string
+	Regexp *regexp.Regexp
+}
+
+// builtinFilenamePatterns covers the naming schemes of common screenshot
+// tools. They are tried in order after any user-defined patterns.
+var builtinFilenamePatterns = []*FilenamePattern{
+	// Screenshot 2023-04-01 at 10.22.33.png, Screen Shot 2019-01-02 at 1.02.03 PM.png
+	mustFilenamePattern("macos", `(?i)screen ?shot (?P<year>\d{4})-(?P<month>\d{2})-(?P<day>\d{2}) at (?P<hour>\d{1,2})\.(?P<minute>\d{2})\.(?P<second>\d{2})(?:[\s\x{202F}]?(?P<ampm>[AP]M))?`),
+	// Screenshot_20230401-102233_Chrome.jpg
+	mustFilenamePattern("android", `(?i)screenshot_(?P<year>\d{4})(?P<month>\d{2})(?P<day>\d{2})[-_](?P<hour>\d{2})(?P<minute>\d{2})(?P<second>\d{2})`),
+	// Screenshot_2023-04-01-10-22-33-123_com.android.chrome.jpg
+	mustFilenamePattern("android-dashed", `(?i)screenshot_(?P<year>\d{4})-(?P<month>\d{2})-(?P<day>\d{2})-(?P<hour>\d{2})-(?P<minute>\d{2})-(?P<second>\d{2})`),
+	// Screenshot from 2023-04-01 10-22-33.png
+	mustFilenamePattern("gnome", `(?i)screenshot from (?P<year>\d{4})-(?P<month>\d{2})-(?P<day>\d{2}) (?P<hour>\d{2})-(?P<minute>\d{2})-(?P<second>\d{2})`),
+	// Screenshot 2023-04-01 102233.png
+	mustFilenamePattern("windows", `(?i)screenshot (?P<year>\d{4})-(?P<month>\d{2})-(?P<day>\d{2}) (?P<hour>\d{2})(?P<minute>\d{2})(?P<second>\d{2})`),
+	// 2023-04-01 10.22.33.png
+	mustFilenamePattern("dropbox", `^(?P<year>\d{4})-(?P<month>\d{2})-(?P<day>\d{2}) (?P<hour>\d{2})\.(?P<minute>\d{2})\.(?P<second>\d{2})`),
+}
+
+// BuiltinFilenamePatterns returns a copy of the built-in pattern library
+func BuiltinFilenamePatterns() []*FilenamePattern {
+	return append([]*FilenamePattern(nil), builtinFilenamePatterns...)
+}
+
+// NewFilenamePattern compiles a user-defined pattern and checks that it
+// defines the groups needed to build a date
+func NewFilenamePattern(name, expr string) (*FilenamePattern, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filename pattern %q: %w", expr, err)
+	}
+
+	groups := make(map[string]bool)
+	for _, g := range re.SubexpNames() {
+		if g == "" {
+			continue
+		}
+		switch g {
+		case "year", "month", "day", "hour", "minute", "second", "ampm":
+			groups[g] = true
+		default:
+			return nil, fmt.Errorf("filename pattern %q: unknown group %q", expr, g)
+		}
+	}
+	for _, required := range []string{"year", "month", "day"} {
+		if !groups[required] {
+			return nil, fmt.Errorf("filename pattern %q: missing named group %q", expr, required)
+		}
+	}
+
+	return &FilenamePattern{Name: name, Regexp: re}, nil
+}
+
+func mustFilenamePattern(name, expr string) *FilenamePattern {
+	p, err := NewFilenamePattern(name, expr)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// Match extracts the time encoded in name, interpreting it as wall-clock
+// time in loc. Matches that do not form a valid date are rejected.
+func (p *FilenamePattern) Match(name string, loc *time.Location) (time.Time, bool) {
+	m := p.Regexp.FindStringSubmatch(name)
+	if m == nil {
+		return time.Time{}, false
+	}
+
+	fields := map[string]int{}
+	ampm := ""
+	for i, g := range p.Regexp.SubexpNames() {
+		if g == "" || m[i] == "" {
+			continue
+		}
+		if g == "ampm" {
+			ampm = strings.ToUpper(m[i])
+			continue
+		}
+		v, err := strconv.Atoi(m[i])
+		if err != nil {
+			return time.Time{}, false
+		}
+		fields[g] = v
+	}
+
+	year, month, day := fields["year"], fields["month"], fields["day"]
+	hour, minute, second := fields["hour"], fields["minute"], fields["second"]
+	if ampm != "" {
+		if hour < 1 || hour > 12 {
+			return time.Time{}, false
+		}
+		hour %= 12
+		if ampm == "PM" {
+			hour += 12
+		}
+	}
+
+	if year < 1970 || year > 2200 || month < 1 || month > 12 || day < 1 ||
+		hour > 23 || minute > 59 || second > 59 {
+		return time.Time{}, false
+	}
+	t := time.Date(year, time.Month(month), day, hour, minute, second, 0, loc)
+	// time.Date normalises 31 April into 1 May; treat that as no match
+	if t.Day() != day {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// TimeFromFilename returns the time encoded in a file name by the first
+// matching pattern, along with that pattern's name
+func TimeFromFilename(name string, patterns []*FilenamePattern, loc *time.Location) (time.Time, string, bool) {
+	for _, p := range patterns {
+		if t, ok := p.Match(name, loc); ok {
+			return t, p.Name, true
+		}
+	}
+	return time.Time{}, "", false
+}
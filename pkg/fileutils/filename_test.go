@@ -0,0 +1,84 @@
+package fileutils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeFromFilename(t *testing.T) {
+	want := time.Date(2023, 4, 1, 10, 22, 33, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		pattern string
+		ok      bool
+	}{
+		{"Screenshot 2023-04-01 at 10.22.33.png", "macos", true},
+		{"Screen Shot 2023-04-01 at 10.22.33 AM.png", "macos", true},
+		{"Screenshot_20230401-102233_Chrome.jpg", "android", true},
+		{"Screenshot_2023-04-01-10-22-33-512_com.android.chrome.jpg", "android-dashed", true},
+		{"Screenshot from 2023-04-01 10-22-33.png", "gnome", true},
+		{"Screenshot 2023-04-01 102233.png", "windows", true},
+		{"2023-04-01 10.22.33.png", "dropbox", true},
+		{"IMG_20230401_102233.jpg", "", false}, // a camera's name, not a screenshot tool's
+		{"holiday.png", "", false},
+		{"Screenshot_20231301-102233.png", "", false}, // month 13
+		{"Screenshot_20230431-102233.png", "", false}, // 31 April
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, pattern, ok := TimeFromFilename(tt.name, BuiltinFilenamePatterns(), time.UTC)
+			if ok != tt.ok {
+				t.Fatalf("TimeFromFilename() ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if pattern != tt.pattern {
+				t.Errorf("pattern = %q, want %q", pattern, tt.pattern)
+			}
+			if !got.Equal(want) {
+				t.Errorf("time = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestTimeFromFilenamePM(t *testing.T) {
+	got, _, ok := TimeFromFilename("Screen Shot 2019-01-02 at 1.02.03 PM.png", BuiltinFilenamePatterns(), time.UTC)
+	if !ok {
+		t.Fatal("expected a match")
+	}
+	if want := time.Date(2019, 1, 2, 13, 2, 3, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("time = %v, want %v", got, want)
+	}
+}
+
+func TestNewFilenamePattern(t *testing.T) {
+	custom, err := NewFilenamePattern("custom", `^shot-(?P<day>\d{2})\.(?P<month>\d{2})\.(?P<year>\d{4})`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// User patterns are tried before the built-in library
+	patterns := append([]*FilenamePattern{custom}, BuiltinFilenamePatterns()...)
+	got, name, ok := TimeFromFilename("shot-01.04.2023.png", patterns, time.UTC)
+	if !ok || name != "custom" {
+		t.Fatalf("TimeFromFilename() = %v, %q, %v", got, name, ok)
+	}
+	if want := time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("time = %v, want %v", got, want)
+	}
+
+	invalid := []string{
+		`(`,
+		`(?P<year>\d{4})-(?P<month>\d{2})`,
+		`(?P<year>\d{4})(?P<month>\d{2})(?P<day>\d{2})(?P<week>\d)`,
+	}
+	for _, expr := range invalid {
+		if _, err := NewFilenamePattern("bad", expr); err == nil {
+			t.Errorf("NewFilenamePattern(%q) expected error", expr)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package fileutils
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+	"time"
+)
+
+// errNotPNG is returned for streams that do not start with the PNG
+// signature
+var errNotPNG = errors.New("not a PNG stream")
+
+const (
+	pngSignature = "\x89PNG\r\n\x1a\n"
+	// maxPNGMetadataChunk bounds the size of the chunks we load into
+	// memory; larger text or EXIF chunks are skipped
+	maxPNGMetadataChunk = 1 << 20
+)
+
+// PNGMetadata holds the timestamps found in the ancillary chunks that
+// precede the image data of a PNG file
+type PNGMetadata struct {
+	// Width and Height are the size of the image, from the IHDR chunk
+	Width, Height int
+	// ModTime is the tIME chunk, the image's last modification in UTC
+	ModTime time.Time
+	// CreationTime is the raw "Creation Time" text keyword
+	CreationTime string
+	// EXIF is the parsed eXIf chunk, if any
+	EXIF *EXIF
+}
+
+// pngTimeLayouts are the formats seen in "Creation Time" keywords. The
+// PNG specification recommends RFC 1123 but most tools write something else.
+var pngTimeLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006:01:02 15:04:05",
+	"Mon 02 Jan 2006 03:04:05 PM MST",
+	"Mon Jan 2 15:04:05 2006",
+}
+
+// ReadPNGMetadata scans the chunk stream of a PNG file up to the first
+// IDAT chunk and collects the image size and the tIME, eXIf and "Creation
+// Time" text chunks.
+// Pixel data is never read or decoded.
+func ReadPNGMetadata(r io.Reader) (*PNGMetadata, error) {
+	br := bufio.NewReader(r)
+
+	sig := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(br, sig); err != nil || string(sig) != pngSignature {
+		return nil, errNotPNG
+	}
+
+	m := &PNGMetadata{}
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(br, header[:]); err != nil {
+			return nil, fmt.Errorf("truncated PNG chunk header: %w", err)
+		}
+		length := binary.BigEndian.Uint32(header[:4])
+		typ := string(header[4:8])
+
+		if typ == "IDAT" || typ == "IEND" {
+			return m, nil
+		}
+		if length > 0x7FFFFFFF {
+			return nil, fmt.Errorf("invalid PNG chunk length %d", length)
+		}
+
+		interesting := typ == "IHDR" || typ == "tIME" || typ == "eXIf" || typ == "tEXt" || typ == "zTXt" || typ == "iTXt"
+		if !interesting || length > maxPNGMetadataChunk {
+			if _, err := br.Discard(int(length) + 4); err != nil {
+				return nil, fmt.Errorf("truncated PNG chunk %s: %w", typ, err)
+			}
+			continue
+		}
+
+		data := make([]byte, length+4)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return nil, fmt.Errorf("truncated PNG chunk %s: %w", typ, err)
+		}
+		data, crc := data[:length], binary.BigEndian.Uint32(data[length:])
+		// A corrupt ancillary chunk is ignored, as PNG decoders do
+		if crc32.Update(crc32.ChecksumIEEE(header[4:8]), crc32.IEEETable, data) != crc {
+			continue
+		}
+
+		switch typ {
+		case "IHDR":
+			if len(data) == 13 {
+				m.Width = int(binary.BigEndian.Uint32(data[0:4]))
+				m.Height = int(binary.BigEndian.Uint32(data[4:8]))
+			}
+		case "tIME":
+			if t, ok := parseTIME(data); ok {
+				m.ModTime = t
+			}
+		case "eXIf":
+			if exif, err := ParseEXIF(data); err == nil {
+				m.EXIF = exif
+			}
+		default:
+			if keyword, text, ok := parsePNGText(typ, data); ok && strings.EqualFold(keyword, "Creation Time") {
+				m.CreationTime = strings.TrimSpace(text)
+			}
+		}
+	}
+}
+
+// parseTIME decodes the 7-byte tIME chunk
+func parseTIME(data []byte) (time.Time, bool) {
+	if len(data) != 7 {
+		return time.Time{}, false
+	}
+	year := int(binary.BigEndian.Uint16(data[:2]))
+	month, day := int(data[2]), int(data[3])
+	hour, minute, second := int(data[4]), int(data[5]), int(data[6])
+	if year == 0 || month < 1 || month > 12 || day < 1 || day > 31 || hour > 23 || minute > 59 || second > 60 {
+		return time.Time{}, false
+	}
+	return time.Date(year, time.Month(month), day, hour, minute, second, 0, time.UTC), true
+}
+
+// parsePNGText decodes tEXt, zTXt and iTXt chunks into keyword and text
+func parsePNGText(typ string, data []byte) (string, string, bool) {
+	keyword, rest, ok := bytes.Cut(data, []byte{0})
+	if !ok {
+		return "", "", false
+	}
+
+	switch typ {
+	case "tEXt":
+		return string(keyword), latin1(rest), true
+	case "zTXt":
+		if len(rest) < 1 || rest[0] != 0 {
+			return "", "", false
+		}
+		text, err := inflate(rest[1:])
+		if err != nil {
+			return "", "", false
+		}
+		return string(keyword), latin1(text), true
+	case "iTXt":
+		if len(rest) < 2 {
+			return "", "", false
+		}
+		compressed, method := rest[0], rest[1]
+		// Skip the language tag and translated keyword
+		rest = rest[2:]
+		for i := 0; i < 2; i++ {
+			if _, rest, ok = bytes.Cut(rest, []byte{0}); !ok {
+				return "", "", false
+			}
+		}
+		if compressed == 1 {
+			if method != 0 {
+				return "", "", false
+			}
+			text, err := inflate(rest)
+			if err != nil {
+				return "", "", false
+			}
+			return string(keyword), string(text), true
+		}
+		return string(keyword), string(rest), true
+	}
+	return "", "", false
+}
+
+// inflate decompresses zlib data, refusing to expand beyond the chunk limit
+func inflate(data []byte) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(io.LimitReader(zr, maxPNGMetadataChunk))
+}
+
+func latin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// Time returns the best capture time in the metadata: the eXIf capture
+// time, then the "Creation Time" keyword, then tIME. Times without an
+// explicit offset are interpreted in loc.
+func (m *PNGMetadata) Time(loc *time.Location) (time.Time, bool) {
+	if m.EXIF != nil {
+		if t, ok := m.EXIF.Time(loc); ok {
+			return t, true
+		}
+	}
+	if m.CreationTime != "" {
+		for _, layout := range pngTimeLayouts {
+			if t, err := time.ParseInLocation(layout, m.CreationTime, loc); err == nil {
+				return t, true
+			}
+		}
+	}
+	if !m.ModTime.IsZero() {
+		return m.ModTime, true
+	}
+	return time.Time{}, false
+}
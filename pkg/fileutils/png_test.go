@@ -0,0 +1,138 @@
+package fileutils
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+	"time"
+)
+
+type testChunk struct {
+	typ  string
+	data []byte
+}
+
+// buildPNG assembles a PNG stream from the given chunks, framed by IHDR
+// and followed by an IDAT and IEND
+func buildPNG(chunks ...testChunk) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(pngSignature)
+
+	write := func(c testChunk) {
+		binary.Write(&buf, binary.BigEndian, uint32(len(c.data)))
+		buf.WriteString(c.typ)
+		buf.Write(c.data)
+		crc := crc32.Update(crc32.ChecksumIEEE([]byte(c.typ)), crc32.IEEETable, c.data)
+		binary.Write(&buf, binary.BigEndian, crc)
+	}
+
+	// An IHDR chunk given first replaces the blank one
+	ihdr := testChunk{"IHDR", make([]byte, 13)}
+	if len(chunks) > 0 && chunks[0].typ == "IHDR" {
+		ihdr, chunks = chunks[0], chunks[1:]
+	}
+	write(ihdr)
+	for _, c := range chunks {
+		write(c)
+	}
+	write(testChunk{"IDAT", []byte{0, 0, 0, 0}})
+	// Text after the image data must be ignored
+	write(testChunk{"tEXt", []byte("Creation Time\x002001-01-01 00:00:00")})
+	write(testChunk{"IEND", nil})
+	return buf.Bytes()
+}
+
+func compress(s string) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write([]byte(s))
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestReadPNGMetadata(t *testing.T) {
+	want := time.Date(2023, 4, 1, 10, 22, 33, 0, time.UTC)
+	tIME := []byte{0x07, 0xE7, 4, 1, 10, 22, 33}
+	exif := buildEXIF(binary.LittleEndian, nil, []testTag{{tagDateTimeOriginal, "2023:04:01 10:22:33"}})
+
+	tests := []struct {
+		name   string
+		chunks []testChunk
+	}{
+		{"tIME", []testChunk{{"tIME", tIME}}},
+		{"eXIf", []testChunk{{"eXIf", exif}}},
+		{"tEXt", []testChunk{{"tEXt", []byte("Creation Time\x00Sat, 01 Apr 2023 10:22:33 +0000")}}},
+		{"zTXt", []testChunk{{"zTXt", append([]byte("Creation Time\x00\x00"), compress("2023-04-01T10:22:33Z")...)}}},
+		{"iTXt", []testChunk{{"iTXt", []byte("Creation Time\x00\x00\x00en\x00\x002023:04:01 10:22:33")}}},
+		{"iTXt compressed", []testChunk{{"iTXt", append([]byte("Creation Time\x00\x01\x00\x00\x00"), compress("2023-04-01 10:22:33")...)}}},
+		// eXIf wins over a differing tIME
+		{"precedence", []testChunk{
+			{"tIME", []byte{0x07, 0xE8, 1, 1, 0, 0, 0}},
+			{"eXIf", exif},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta, err := ReadPNGMetadata(bytes.NewReader(buildPNG(tt.chunks...)))
+			if err != nil {
+				t.Fatalf("ReadPNGMetadata() error = %v", err)
+			}
+			got, ok := meta.Time(time.UTC)
+			if !ok {
+				t.Fatal("Time() found no timestamp")
+			}
+			if !got.Equal(want) {
+				t.Errorf("Time() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestReadPNGMetadataNoTime(t *testing.T) {
+	meta, err := ReadPNGMetadata(bytes.NewReader(buildPNG(testChunk{"tEXt", []byte("Software\x00Greenshot")})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := meta.Time(time.UTC); ok {
+		t.Error("Time() should find no timestamp")
+	}
+}
+
+func TestReadPNGMetadataSize(t *testing.T) {
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], 1170)
+	binary.BigEndian.PutUint32(ihdr[4:], 2532)
+	meta, err := ReadPNGMetadata(bytes.NewReader(buildPNG(testChunk{"IHDR", ihdr})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Width != 1170 || meta.Height != 2532 {
+		t.Errorf("size = %dx%d, want 1170x2532", meta.Width, meta.Height)
+	}
+}
+
+func TestReadPNGMetadataMalformed(t *testing.T) {
+	if _, err := ReadPNGMetadata(bytes.NewReader([]byte("test content"))); err == nil {
+		t.Error("ReadPNGMetadata() should reject non-PNG input")
+	}
+
+	// A chunk with a bad CRC is ignored rather than trusted
+	data := buildPNG(testChunk{"tIME", []byte{0x07, 0xE7, 4, 1, 10, 22, 33}})
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(pngSignature)+25+8+6]++
+	meta, err := ReadPNGMetadata(bytes.NewReader(corrupt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := meta.Time(time.UTC); ok {
+		t.Error("Time() should ignore a chunk with a bad CRC")
+	}
+
+	// Every truncation must be handled without panicking
+	for i := range data {
+		ReadPNGMetadata(bytes.NewReader(data[:i]))
+	}
+}
@@ -0,0 +1,24 @@
+//go:build linux
+
+package fileutils
+
+import (
+	"os"
+	"syscall"
+)
+
+// reflink clones the extents of src into dst with the FICLONE ioctl.
+// Filesystems without shared extents (ext4, tmpfs) and clones across
+// filesystems report errReflinkUnsupported so the caller copies instead;
+// Go's io.Copy then uses copy_file_range, which still avoids moving data
+// through user space.
+func reflink(src, dst *os.File) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dst.Fd(), ficlone, src.Fd())
+	switch errno {
+	case 0:
+		return nil
+	case syscall.EOPNOTSUPP, syscall.ENOTTY, syscall.EXDEV, syscall.EINVAL, syscall.ENOSYS:
+		return errReflinkUnsupported
+	}
+	return errno
+}
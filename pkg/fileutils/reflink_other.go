@@ -0,0 +1,10 @@
+//go:build !linux
+
+package fileutils
+
+import "os"
+
+// reflink is only implemented on Linux; elsewhere files are copied
+func reflink(src, dst *os.File) error {
+	return errReflinkUnsupported
+}
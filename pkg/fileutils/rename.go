@@ -0,0 +1,43 @@
+package fileutils
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// RenameNoReplace renames src to dst like os.Rename, but never replaces an
+// existing dst. If dst exists the error matches fs.ErrExist, even when
+// another process creates it between a caller's check and the rename.
+func RenameNoReplace(src, dst string) error {
+	return renameNoReplace(src, dst)
+}
+
+// linkRename emulates a no-replace rename with link and unlink, since
+// link(2) refuses to create a name that already exists
+func linkRename(src, dst string) error {
+	err := os.Link(src, dst)
+	if err == nil {
+		if err := os.Remove(src); err != nil {
+			// Leave the file under its original name only
+			os.Remove(dst)
+			return &os.LinkError{Op: "rename", Old: src, New: dst, Err: err}
+		}
+		return nil
+	}
+
+	var linkErr *os.LinkError
+	if errors.As(err, &linkErr) {
+		err = linkErr.Err
+	}
+	if errors.Is(err, fs.ErrExist) || isCrossDevice(err) {
+		return &os.LinkError{Op: "rename", Old: src, New: dst, Err: err}
+	}
+
+	// The filesystem has no hard links (FAT, many network shares); check
+	// and rename is the best we can do there
+	if _, err := os.Lstat(dst); err == nil {
+		return &os.LinkError{Op: "rename", Old: src, New: dst, Err: fs.ErrExist}
+	}
+	return os.Rename(src, dst)
+}
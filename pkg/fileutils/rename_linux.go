@@ -0,0 +1,49 @@
+//go:build linux
+
+package fileutils
+
+import (
+	"os"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// renameNoReplaceFlag is RENAME_NOREPLACE for renameat2(2)
+const renameNoReplaceFlag = 0x1
+
+// renameat2Unsupported is set once the kernel has refused renameat2
+var renameat2Unsupported atomic.Bool
+
+// renameNoReplace uses renameat2(RENAME_NOREPLACE), which checks for an
+// existing destination and renames in a single atomic step. Filesystems
+// that do not support the flag fall back to link and unlink.
+func renameNoReplace(src, dst string) error {
+	if renameat2Unsupported.Load() {
+		return linkRename(src, dst)
+	}
+
+	srcPtr, err := syscall.BytePtrFromString(src)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: src, New: dst, Err: err}
+	}
+	dstPtr, err := syscall.BytePtrFromString(dst)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: src, New: dst, Err: err}
+	}
+
+	dirfd := atFDCWD
+	_, _, errno := syscall.Syscall6(sysRenameat2, uintptr(dirfd), uintptr(unsafe.Pointer(srcPtr)),
+		uintptr(dirfd), uintptr(unsafe.Pointer(dstPtr)), renameNoReplaceFlag, 0)
+	switch errno {
+	case 0:
+		return nil
+	case syscall.ENOSYS, syscall.EPERM:
+		renameat2Unsupported.Store(true)
+		return linkRename(src, dst)
+	case syscall.EINVAL:
+		// Supported by the kernel but not by this filesystem
+		return linkRename(src, dst)
+	}
+	return &os.LinkError{Op: "rename", Old: src, New: dst, Err: errno}
+}
@@ -0,0 +1,7 @@
+//go:build !linux && !windows
+
+package fileutils
+
+func renameNoReplace(src, dst string) error {
+	return linkRename(src, dst)
+}
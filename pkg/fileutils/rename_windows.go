@@ -0,0 +1,23 @@
+package fileutils
+
+import (
+	"os"
+	"syscall"
+)
+
+// renameNoReplace uses MoveFile, which unlike the MoveFileEx call behind
+// os.Rename fails when the destination exists
+func renameNoReplace(src, dst string) error {
+	from, err := syscall.UTF16PtrFromString(src)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: src, New: dst, Err: err}
+	}
+	to, err := syscall.UTF16PtrFromString(dst)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: src, New: dst, Err: err}
+	}
+	if err := syscall.MoveFile(from, to); err != nil {
+		return &os.LinkError{Op: "rename", Old: src, New: dst, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package fileutils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/screenshot-sorter/pkg/format"
+)
+
+// Names of the built-in time sources
+const (
+	SourceFilename  = "filename"
+	SourceEXIF      = "exif"
+	SourcePNG       = "png"
+	SourceSidecar   = "sidecar"
+	SourceBirthTime = "birthtime"
+	SourceModTime   = "mtime"
+)
+
+// DefaultTimeSources is the resolver order used when none is configured
+var DefaultTimeSources = []string{
+	SourceFilename, SourceEXIF, SourcePNG, SourceSidecar, SourceBirthTime, SourceModTime,
+}
+
+// TimeResolver determines a file's capture time from a single source.
+// Resolve reports ok=false when the source has nothing to say about the
+// file; an error means the source exists but could not be read, and the
+// chain moves on to the next resolver. Naive wall-clock times are
+// interpreted in loc. Resolve may be called for several files at once.
+type TimeResolver interface {
+	Name() string
+	Resolve(path string, fi os.FileInfo, loc *time.Location) (t time.Time, ok bool, err error)
+}
+
+// ResolvedTime is a capture time together with the source it came from
+type ResolvedTime struct {
+	Time time.Time
+	// Source is the name of the resolver that produced Time
+	Source string
+	// Fallback is set when Time comes from filesystem metadata rather
+	// than from the image itself or its name
+	Fallback bool
+}
+
+// String describes the provenance, e.g. "from exif" or "fallback: mtime"
+func (r ResolvedTime) String() string {
+	if r.Fallback {
+		return "fallback: " + r.Source
+	}
+	return "from " + r.Source
+}
+
+// ResolverChain tries resolvers in order and returns the first result
+type ResolverChain struct {
+	resolvers []TimeResolver
+}
+
+// NewResolverChain creates a chain from the given resolvers. The file's
+// modification time is always used as a last resort, so a chain never
+// fails to produce a time.
+func NewResolverChain(resolvers ...TimeResolver) *ResolverChain {
+	return &ResolverChain{resolvers: resolvers}
+}
+
+// Names returns the names of the resolvers in the chain, in order
+func (c *ResolverChain) Names() []string {
+	names := make([]string, len(c.resolvers))
+	for i, r := range c.resolvers {
+		names[i] = r.Name()
+	}
+	return names
+}
+
+// Resolve runs the chain for one file. Errors from individual resolvers
+// are returned alongside the result so callers can report them.
+func (c *ResolverChain) Resolve(path string, fi os.FileInfo, loc *time.Location) (ResolvedTime, []error) {
+	var errs []error
+	for _, r := range c.resolvers {
+		t, ok, err := r.Resolve(path, fi, loc)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r.Name(), err))
+			continue
+		}
+		if ok {
+			return ResolvedTime{Time: t, Source: r.Name(), Fallback: isFallback(r)}, errs
+		}
+	}
+	return ResolvedTime{Time: fi.ModTime(), Source: SourceModTime, Fallback: true}, errs
+}
+
+// fallbackResolver is implemented by resolvers whose times come from
+// filesystem metadata
+type fallbackResolver interface {
+	fallback() bool
+}
+
+func isFallback(r TimeResolver) bool {
+	f, ok := r.(fallbackResolver)
+	return ok && f.fallback()
+}
+
+// funcResolver adapts a function to the TimeResolver interface
+type funcResolver struct {
+	name       string
+	filesystem bool
+	fn         func(path string, fi os.FileInfo, loc *time.Location) (time.Time, bool, error)
+}
+
+func (r *funcResolver) Name() string   { return r.name }
+func (r *funcResolver) fallback() bool { return r.filesystem }
+
+func (r *funcResolver) Resolve(path string, fi os.FileInfo, loc *time.Location) (time.Time, bool, error) {
+	return r.fn(path, fi, loc)
+}
+
+// NewBuiltinResolver returns the built-in resolver with the given name.
+// The filename resolver matches patterns in order, and the exif and png
+// resolvers identify the content of files with formats.
+func NewBuiltinResolver(name string, patterns []*FilenamePattern, formats *format.Registry) (TimeResolver, error) {
+	switch name {
+	case SourceFilename:
+		return &funcResolver{name: name, fn: func(path string, _ os.FileInfo, loc *time.Location) (time.Time, bool, error) {
+			t, _, ok := TimeFromFilename(filepath.Base(path), patterns, loc)
+			return t, ok, nil
+		}}, nil
+	case SourceEXIF:
+		return &funcResolver{name: name, fn: func(path string, _ os.FileInfo, loc *time.Location) (time.Time, bool, error) {
+			return resolveEXIF(path, loc, formats)
+		}}, nil
+	case SourcePNG:
+		return &funcResolver{name: name, fn: func(path string, _ os.FileInfo, loc *time.Location) (time.Time, bool, error) {
+			return resolvePNG(path, loc, formats)
+		}}, nil
+	case SourceSidecar:
+		return &funcResolver{name: name, fn: resolveSidecar}, nil
+	case SourceBirthTime:
+		return &funcResolver{name: name, filesystem: true, fn: func(path string, fi os.FileInfo, _ *time.Location) (time.Time, bool, error) {
+			t, ok := birthTime(path, fi)
+			return t, ok, nil
+		}}, nil
+	case SourceModTime:
+		return &funcResolver{name: name, filesystem: true, fn: func(_ string, fi os.FileInfo, _ *time.Location) (time.Time, bool, error) {
+			return fi.ModTime(), true, nil
+		}}, nil
+	}
+	return nil, fmt.Errorf("unknown time source %q", name)
+}
+
+// resolveEXIF and resolvePNG go by the content of the file rather than its
+// extension, which may be wrong. Content that is not in the format the
+// extension names, nor in another known one, is reported, as the file is
+// likely damaged.
+func resolveEXIF(path string, loc *time.Location, formats *format.Registry) (time.Time, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	defer f.Close()
+
+	exif, err := ReadJPEGEXIF(f)
+	if errors.Is(err, ErrNoEXIF) || errors.Is(err, errNotJPEG) && !damaged(formats, f, "jpeg") {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	t, ok := exif.Time(loc)
+	return t, ok, nil
+}
+
+func resolvePNG(path string, loc *time.Location, formats *format.Registry) (time.Time, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	defer f.Close()
+
+	meta, err := ReadPNGMetadata(f)
+	if errors.Is(err, errNotPNG) && !damaged(formats, f, "png") {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	t, ok := meta.Time(loc)
+	return t, ok, nil
+}
+
+// damaged reports whether f, which is not in the format named, has the
+// extension of that format and content in no other format of formats
+func damaged(formats *format.Registry, f *os.File, name string) bool {
+	named, ok := formats.ByExtension(format.Ext(f.Name()))
+	if !ok || named.Name != name {
+		return false
+	}
+	_, identified, err := formats.DetectFile(f.Name())
+	return err == nil && !identified
+}
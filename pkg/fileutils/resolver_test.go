@@ -0,0 +1,220 @@
+package fileutils
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/screenshot-sorter/pkg/format"
+)
+
+type staticResolver struct {
+	name string
+	t    time.Time
+	ok   bool
+	err  error
+}
+
+func (r staticResolver) Name() string { return r.name }
+
+func (r staticResolver) Resolve(string, os.FileInfo, *time.Location) (time.Time, bool, error) {
+	return r.t, r.ok, r.err
+}
+
+func mustBuiltin(t *testing.T, name string) TimeResolver {
+	t.Helper()
+	formats, err := format.NewRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewBuiltinResolver(name, BuiltinFilenamePatterns(), formats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func writeFile(t *testing.T, path string, data []byte, mtime time.Time) os.FileInfo {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fi
+}
+
+func TestResolverChain(t *testing.T) {
+	dir := t.TempDir()
+	mtime := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	exif := buildEXIF(binary.LittleEndian, nil, []testTag{{tagDateTimeOriginal, "2021:06:01 12:00:00"}})
+	jpeg := filepath.Join(dir, "photo.jpg")
+	jpegInfo := writeFile(t, jpeg, buildJPEG(exif), mtime)
+
+	named := filepath.Join(dir, "Screenshot_20220301-101010.png")
+	namedInfo := writeFile(t, named, buildPNG(), mtime)
+
+	plain := filepath.Join(dir, "plain.png")
+	plainInfo := writeFile(t, plain, []byte("not a png"), mtime)
+
+	// A PNG saved with the wrong extension is read as a PNG
+	misnamed := filepath.Join(dir, "shot.PNG.jpg")
+	misnamedInfo := writeFile(t, misnamed, buildPNG(testChunk{"tIME", []byte{0x07, 0xE7, 3, 1, 10, 0, 0}}), mtime)
+
+	chain := NewResolverChain(
+		mustBuiltin(t, SourceFilename),
+		mustBuiltin(t, SourceEXIF),
+		mustBuiltin(t, SourcePNG),
+		mustBuiltin(t, SourceModTime),
+	)
+
+	tests := []struct {
+		path     string
+		fi       os.FileInfo
+		year     int
+		source   string
+		fallback bool
+		warnings int
+	}{
+		{jpeg, jpegInfo, 2021, SourceEXIF, false, 0},
+		{named, namedInfo, 2022, SourceFilename, false, 0},
+		{plain, plainInfo, 2020, SourceModTime, true, 1},
+		{misnamed, misnamedInfo, 2023, SourcePNG, false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(filepath.Base(tt.path), func(t *testing.T) {
+			got, warnings := chain.Resolve(tt.path, tt.fi, time.UTC)
+			if got.Time.Year() != tt.year || got.Source != tt.source || got.Fallback != tt.fallback {
+				t.Errorf("Resolve() = %+v, want year %d from %s (fallback %v)", got, tt.year, tt.source, tt.fallback)
+			}
+			if len(warnings) != tt.warnings {
+				t.Errorf("Resolve() warnings = %v, want %d", warnings, tt.warnings)
+			}
+		})
+	}
+}
+
+func TestResolverFormats(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "shot.png")
+	fi := writeFile(t, path, []byte("qoif\x00\x00\x05\x00"), time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC))
+
+	// Content in a format of the run is not taken for a damaged PNG
+	qoi := format.Format{Name: "qoi", Extensions: []string{".qoi"}, Detect: func(h []byte) bool {
+		return strings.HasPrefix(string(h), "qoif")
+	}}
+	formats, err := format.NewRegistry(qoi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewBuiltinResolver(SourcePNG, nil, formats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := r.Resolve(path, fi, time.UTC); ok || err != nil {
+		t.Errorf("Resolve() = %v, %v; want nothing to say", ok, err)
+	}
+	if _, _, err := mustBuiltin(t, SourcePNG).Resolve(path, fi, time.UTC); err == nil {
+		t.Error("Resolve() with the built-in formats should report a damaged PNG")
+	}
+}
+
+func TestResolverChainCustom(t *testing.T) {
+	dir := t.TempDir()
+	fi := writeFile(t, filepath.Join(dir, "a.png"), nil, time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC))
+
+	custom := time.Date(2015, 5, 5, 0, 0, 0, 0, time.UTC)
+	chain := NewResolverChain(
+		staticResolver{name: "broken", err: errors.New("boom")},
+		staticResolver{name: "empty"},
+		staticResolver{name: "database", t: custom, ok: true},
+	)
+
+	got, warnings := chain.Resolve(filepath.Join(dir, "a.png"), fi, time.UTC)
+	if !got.Time.Equal(custom) || got.Source != "database" || got.Fallback {
+		t.Errorf("Resolve() = %+v", got)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("Resolve() warnings = %v, want 1", warnings)
+	}
+	if got.String() != "from database" {
+		t.Errorf("String() = %q", got.String())
+	}
+
+	// An exhausted chain falls back to the modification time
+	got, _ = NewResolverChain(staticResolver{name: "empty"}).Resolve(filepath.Join(dir, "a.png"), fi, time.UTC)
+	if got.Time.Year() != 2020 || got.String() != "fallback: mtime" {
+		t.Errorf("Resolve() = %+v, %q", got, got.String())
+	}
+}
+
+func TestSidecarResolver(t *testing.T) {
+	dir := t.TempDir()
+	mtime := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	sidecar := mustBuiltin(t, SourceSidecar)
+
+	tests := []struct {
+		name     string
+		sidecar  string
+		contents string
+		want     time.Time
+	}{
+		{
+			"takeout.png", "takeout.png.json",
+			`{"title":"takeout.png","photoTakenTime":{"timestamp":"1680344553","formatted":"..."}}`,
+			time.Unix(1680344553, 0),
+		},
+		{
+			"attr.png", "attr.xmp",
+			`<rdf:Description xmp:CreateDate="2019-03-04T05:06:07" exif:DateTimeOriginal="2018-03-04T05:06:07+02:00"/>`,
+			time.Date(2018, 3, 4, 3, 6, 7, 0, time.UTC),
+		},
+		{
+			"element.png", "element.png.xmp",
+			`<photoshop:DateCreated>2017-08-09</photoshop:DateCreated>`,
+			time.Date(2017, 8, 9, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			fi := writeFile(t, path, nil, mtime)
+			if err := os.WriteFile(filepath.Join(dir, tt.sidecar), []byte(tt.contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, ok, err := sidecar.Resolve(path, fi, time.UTC)
+			if err != nil || !ok {
+				t.Fatalf("Resolve() = %v, %v, %v", got, ok, err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("Resolve() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// No sidecar means no answer, not an error
+	path := filepath.Join(dir, "lonely.png")
+	fi := writeFile(t, path, nil, mtime)
+	if _, ok, err := sidecar.Resolve(path, fi, time.UTC); ok || err != nil {
+		t.Errorf("Resolve() without sidecar = %v, %v", ok, err)
+	}
+}
+
+func TestNewBuiltinResolverUnknown(t *testing.T) {
+	if _, err := NewBuiltinResolver("carbon-dating", nil, nil); err == nil {
+		t.Error("NewBuiltinResolver() should reject unknown sources")
+	}
+}
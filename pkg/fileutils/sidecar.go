@@ -0,0 +1,112 @@
+package fileutils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSidecarSize bounds how much of a sidecar file is read
+const maxSidecarSize = 1 << 20
+
+// xmpDatePattern matches the XMP date properties in either attribute or
+// element form, in order of preference
+var xmpDatePattern = regexp.MustCompile(`(exif:DateTimeOriginal|xmp:CreateDate|photoshop:DateCreated)(?:\s*=\s*"([^"]+)"|>([^<]+)<)`)
+
+var xmpDatePreference = map[string]int{
+	"exif:DateTimeOriginal": 0,
+	"xmp:CreateDate":        1,
+	"photoshop:DateCreated": 2,
+}
+
+// xmpTimeLayouts are the ISO 8601 forms allowed by the XMP specification
+var xmpTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// takeoutMetadata is the subset of a Google Takeout JSON sidecar we use
+type takeoutMetadata struct {
+	PhotoTakenTime struct {
+		Timestamp string `json:"timestamp"`
+	} `json:"photoTakenTime"`
+}
+
+// resolveSidecar reads the capture time from a metadata file stored next
+// to the image: a Google Takeout JSON file or an XMP sidecar
+func resolveSidecar(path string, _ os.FileInfo, loc *time.Location) (time.Time, bool, error) {
+	for _, candidate := range []string{path + ".json", path + ".supplemental-metadata.json"} {
+		data, err := readSidecar(candidate)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		if data == nil {
+			continue
+		}
+		var meta takeoutMetadata
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return time.Time{}, false, fmt.Errorf("invalid JSON sidecar %s: %w", candidate, err)
+		}
+		if secs, err := strconv.ParseInt(meta.PhotoTakenTime.Timestamp, 10, 64); err == nil && secs > 0 {
+			return time.Unix(secs, 0), true, nil
+		}
+	}
+
+	ext := filepath.Ext(path)
+	for _, candidate := range []string{path + ".xmp", strings.TrimSuffix(path, ext) + ".xmp"} {
+		data, err := readSidecar(candidate)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		if data == nil {
+			continue
+		}
+		if t, ok := parseXMPTime(string(data), loc); ok {
+			return t, true, nil
+		}
+	}
+
+	return time.Time{}, false, nil
+}
+
+// readSidecar returns nil without error when the sidecar does not exist
+func readSidecar(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(io.LimitReader(f, maxSidecarSize))
+}
+
+func parseXMPTime(xmp string, loc *time.Location) (time.Time, bool) {
+	var best time.Time
+	bestRank := len(xmpDatePreference)
+	for _, m := range xmpDatePattern.FindAllStringSubmatch(xmp, -1) {
+		rank := xmpDatePreference[m[1]]
+		if rank >= bestRank {
+			continue
+		}
+		value := strings.TrimSpace(m[2] + m[3])
+		for _, layout := range xmpTimeLayouts {
+			if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+				best, bestRank = t, rank
+				break
+			}
+		}
+	}
+	return best, !best.IsZero()
+}
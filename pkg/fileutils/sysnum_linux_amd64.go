@@ -0,0 +1,11 @@
+package fileutils
+
+// Syscall numbers and ioctl requests not exported by the frozen syscall
+// package
+const (
+	sysStatx     = 332
+	sysRenameat2 = 316
+
+	// ficlone is the FICLONE ioctl request, _IOW(0x94, 9, int)
+	ficlone = 0x40049409
+)
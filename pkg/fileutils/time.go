@@ -6,8 +6,10 @@ import (
 )
 
 // GetFileTime attempts to get the most appropriate timestamp for the file
-func GetFileTime(fi os.FileInfo) time.Time {
-	if platformTime, ok := getPlatformSpecificTime(fi); ok {
+// at path, whose FileInfo is fi: its birth time where the platform records
+// one, and its modification time otherwise
+func GetFileTime(path string, fi os.FileInfo) time.Time {
+	if platformTime, ok := getPlatformSpecificTime(path, fi); ok {
 		return platformTime
 	}
 	return fi.ModTime()
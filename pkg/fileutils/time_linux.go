@@ -0,0 +1,91 @@
+//go:build linux
+
+package fileutils
+
+import (
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	atFDCWD           = -100
+	atSymlinkNoFollow = 0x100
+	statxBtime        = 0x800
+)
+
+// statxTimestamp mirrors struct statx_timestamp
+type statxTimestamp struct {
+	Sec  int64
+	Nsec uint32
+	_    int32
+}
+
+// statxT mirrors struct statx up to the timestamps we read; the kernel
+// structure is 256 bytes and the remainder is padding here
+type statxT struct {
+	Mask           uint32
+	Blksize        uint32
+	Attributes     uint64
+	Nlink          uint32
+	UID            uint32
+	GID            uint32
+	Mode           uint16
+	_              uint16
+	Ino            uint64
+	Size           uint64
+	Blocks         uint64
+	AttributesMask uint64
+	Atime          statxTimestamp
+	Btime          statxTimestamp
+	Ctime          statxTimestamp
+	Mtime          statxTimestamp
+	_              [16]uint64
+}
+
+// statxUnsupported is set once the kernel or a seccomp filter has refused
+// statx, so we stop issuing a syscall per file
+var statxUnsupported atomic.Bool
+
+// getPlatformSpecificTime returns the birth time, which on Linux only
+// statx reports
+func getPlatformSpecificTime(path string, fi os.FileInfo) (time.Time, bool) {
+	return birthTime(path, fi)
+}
+
+// birthTime returns the file's birth time via statx(STATX_BTIME). It
+// reports false on kernels without statx and on filesystems that do not
+// record a birth time, such as ext3, tmpfs or most network mounts.
+func birthTime(path string, fi os.FileInfo) (time.Time, bool) {
+	if os.Getenv("SCREENSHOT_SORTER_TEST_USE_MODTIME") == "1" {
+		return fi.ModTime(), true
+	}
+	if statxUnsupported.Load() {
+		return time.Time{}, false
+	}
+
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	var stx statxT
+	dirfd := atFDCWD
+	_, _, errno := syscall.Syscall6(sysStatx, uintptr(dirfd), uintptr(unsafe.Pointer(p)),
+		atSymlinkNoFollow, statxBtime, uintptr(unsafe.Pointer(&stx)), 0)
+	switch errno {
+	case 0:
+	case syscall.ENOSYS, syscall.EPERM:
+		statxUnsupported.Store(true)
+		return time.Time{}, false
+	default:
+		return time.Time{}, false
+	}
+
+	if stx.Mask&statxBtime == 0 || (stx.Btime.Sec == 0 && stx.Btime.Nsec == 0) {
+		return time.Time{}, false
+	}
+	return time.Unix(stx.Btime.Sec, int64(stx.Btime.Nsec)), true
+}
@@ -0,0 +1,67 @@
+//go:build linux
+
+package fileutils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBirthTime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "birth.png")
+	if err := os.WriteFile(path, []byte("test content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changing mtime must not change the birth time
+	old := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	btime, ok := birthTime(path, fi)
+	if !ok {
+		t.Skip("filesystem does not report birth time")
+	}
+	if time.Since(btime) > time.Minute {
+		t.Errorf("birthTime() = %v, expected a recent time", btime)
+	}
+
+	if _, ok := birthTime(filepath.Join(t.TempDir(), "missing.png"), fi); ok {
+		t.Error("birthTime() should fail for a missing file")
+	}
+}
+
+func TestGetFileTimeUsesBirthTime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "birth.png")
+	if err := os.WriteFile(path, []byte("test content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// GetFileTime agrees with the birth time the resolvers use, and falls
+	// back to the modification time where there is none
+	btime, ok := birthTime(path, fi)
+	if !ok {
+		if got := GetFileTime(path, fi); !got.Equal(old) {
+			t.Errorf("GetFileTime() = %v without a birth time, want %v", got, old)
+		}
+		t.Skip("filesystem does not report birth time")
+	}
+	if got := GetFileTime(path, fi); !got.Equal(btime) {
+		t.Errorf("GetFileTime() = %v, want the birth time %v", got, btime)
+	}
+}
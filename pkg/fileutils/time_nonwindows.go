@@ -1,4 +1,4 @@
-//go:build !windows
+//go:build !windows && !linux
 
 package fileutils
 
@@ -7,6 +7,10 @@ import (
 	"time"
 )
 
-func getPlatformSpecificTime(fi os.FileInfo) (time.Time, bool) {
+func getPlatformSpecificTime(path string, fi os.FileInfo) (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func birthTime(path string, fi os.FileInfo) (time.Time, bool) {
 	return time.Time{}, false
 }
@@ -22,7 +22,7 @@ func TestGetFileTime(t *testing.T) {
 	}
 
 	// Test file time retrieval
-	fileTime := GetFileTime(fi)
+	fileTime := GetFileTime(tempFile.Name(), fi)
 	if fileTime.IsZero() {
 		t.Error("Expected non-zero file time")
 	}
@@ -6,7 +6,7 @@ import (
 	"time"
 )
 
-func getPlatformSpecificTime(fi os.FileInfo) (time.Time, bool) {
+func getPlatformSpecificTime(path string, fi os.FileInfo) (time.Time, bool) {
 	if os.Getenv("SCREENSHOT_SORTER_TEST_USE_MODTIME") == "1" {
 		return fi.ModTime(), true
 	}
@@ -18,3 +18,9 @@ func getPlatformSpecificTime(fi os.FileInfo) (time.Time, bool) {
 	}
 	return time.Time{}, false
 }
+
+// birthTime returns the file's creation time, which Windows reports as
+// part of the FileInfo
+func birthTime(path string, fi os.FileInfo) (time.Time, bool) {
+	return getPlatformSpecificTime(path, fi)
+}
@@ -0,0 +1,250 @@
+package fileutils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TransferMode selects how a file is placed at its destination
+type TransferMode string
+
+const (
+	// ModeMove renames the file, copying across filesystems when needed
+	ModeMove TransferMode = "move"
+	// ModeCopy copies the file and leaves the original in place
+	ModeCopy TransferMode = "copy"
+	// ModeHardlink adds a second name for the file on the same filesystem
+	ModeHardlink TransferMode = "hardlink"
+	// ModeReflink makes a copy-on-write clone where the filesystem
+	// supports it, and a regular copy otherwise
+	ModeReflink TransferMode = "reflink"
+)
+
+// TransferModes lists the valid modes
+var TransferModes = []TransferMode{ModeMove, ModeCopy, ModeHardlink, ModeReflink}
+
+// errReflinkUnsupported is returned by reflink when cloning is not possible
+// and the caller should fall back to copying data
+var errReflinkUnsupported = errors.New("reflink not supported")
+
+// ParseTransferMode validates a mode name. The empty string means move.
+func ParseTransferMode(s string) (TransferMode, error) {
+	if s == "" {
+		return ModeMove, nil
+	}
+	for _, m := range TransferModes {
+		if strings.EqualFold(s, string(m)) {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("invalid transfer mode %q (valid: move, copy, hardlink, reflink)", s)
+}
+
+// TransferOptions adjusts how Transfer places a file
+type TransferOptions struct {
+	// Replace allows an existing dst to be overwritten
+	Replace bool
+	// Reader, if set, wraps the source of every byte-by-byte copy, e.g. to
+	// limit bandwidth. It is only called for such copies: renames, links
+	// and reflinks copy no bytes.
+	Reader func(io.Reader) io.Reader
+}
+
+// Transfer places src at dst using the given mode. Unless opts.Replace is
+// set, an existing dst is never overwritten: the error then matches
+// fs.ErrExist, even if dst appeared after the caller last checked for it.
+func Transfer(mode TransferMode, src, dst string, opts TransferOptions) error {
+	switch mode {
+	case ModeMove, "":
+		return moveFile(src, dst, opts)
+	case ModeCopy:
+		_, err := copyFile(src, dst, false, false, opts)
+		return err
+	case ModeHardlink:
+		return linkFile(src, dst, opts.Replace)
+	case ModeReflink:
+		_, err := copyFile(src, dst, true, false, opts)
+		return err
+	}
+	return fmt.Errorf("invalid transfer mode %q", mode)
+}
+
+// MoveFile renames src to dst. When they are on different filesystems it
+// copies the file, verifies the copy against the original and only then
+// removes the original. An existing dst is never replaced.
+func MoveFile(src, dst string) error {
+	return moveFile(src, dst, TransferOptions{})
+}
+
+func moveFile(src, dst string, opts TransferOptions) error {
+	var err error
+	if opts.Replace {
+		err = os.Rename(src, dst)
+	} else {
+		err = RenameNoReplace(src, dst)
+	}
+	if err == nil || !isCrossDevice(err) {
+		return err
+	}
+
+	srcSum, err := copyFile(src, dst, false, true, opts)
+	if err != nil {
+		return err
+	}
+	dstSum, err := HashFile(dst)
+	if err != nil {
+		return fmt.Errorf("failed to verify copy %s: %w", dst, err)
+	}
+	if !bytes.Equal(srcSum, dstSum) {
+		os.Remove(dst)
+		return fmt.Errorf("verification of copy %s failed: content differs from %s", dst, src)
+	}
+	if err := os.Remove(src); err != nil {
+		return fmt.Errorf("copied to %s but failed to remove original: %w", dst, err)
+	}
+	return nil
+}
+
+// CopyFile copies src to dst, preserving permissions and modification
+// time. The copy becomes visible at dst only once it is complete, and an
+// existing dst is never replaced.
+func CopyFile(src, dst string) error {
+	_, err := copyFile(src, dst, false, false, TransferOptions{})
+	return err
+}
+
+// ReflinkFile clones src to dst with a copy-on-write reflink, falling back
+// to a regular copy on filesystems that cannot share extents
+func ReflinkFile(src, dst string) error {
+	_, err := copyFile(src, dst, true, false, TransferOptions{})
+	return err
+}
+
+// LinkFile creates dst as a hard link to src. Both must be on the same
+// filesystem.
+func LinkFile(src, dst string) error {
+	return linkFile(src, dst, false)
+}
+
+// linkFile links src to dst. To replace dst it links to a temporary name
+// first and renames that over dst, so dst is never missing.
+func linkFile(src, dst string, replace bool) error {
+	target := dst
+	if replace {
+		target = filepath.Join(filepath.Dir(dst), fmt.Sprintf(".%s.%d.tmp", filepath.Base(dst), rand.Int63()))
+	}
+	if err := os.Link(src, target); err != nil {
+		if isCrossDevice(err) {
+			return fmt.Errorf("cannot hardlink across filesystems: %w", err)
+		}
+		return err
+	}
+	if replace {
+		if err := os.Rename(target, dst); err != nil {
+			os.Remove(target)
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile writes src to a temporary file next to dst and renames it into
+// place, replacing an existing dst only if opts.Replace is set. With hash
+// set it returns the SHA-256 of the data read from src; otherwise the data
+// is copied with io.Copy so the kernel can use copy_file_range.
+func copyFile(src, dst string, tryReflink, hash bool, opts TransferOptions) ([]byte, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	tmpName := tmp.Name()
+	committed := false
+	defer func() {
+		if !committed {
+			tmp.Close()
+			os.Remove(tmpName)
+		}
+	}()
+
+	var sum []byte
+	cloned := false
+	if tryReflink {
+		if err := reflink(in, tmp); err == nil {
+			cloned = true
+		} else if !errors.Is(err, errReflinkUnsupported) {
+			return nil, fmt.Errorf("reflink %s: %w", src, err)
+		}
+	}
+	var r io.Reader = in
+	if opts.Reader != nil && !cloned {
+		r = opts.Reader(in)
+	}
+	switch {
+	case cloned:
+	case hash:
+		h := sha256.New()
+		if _, err := io.Copy(tmp, io.TeeReader(r, h)); err != nil {
+			return nil, fmt.Errorf("failed to copy %s: %w", src, err)
+		}
+		sum = h.Sum(nil)
+	default:
+		if _, err := io.Copy(tmp, r); err != nil {
+			return nil, fmt.Errorf("failed to copy %s: %w", src, err)
+		}
+	}
+
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		return nil, err
+	}
+	if err := tmp.Sync(); err != nil {
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Chtimes(tmpName, info.ModTime(), info.ModTime()); err != nil {
+		return nil, err
+	}
+	rename := RenameNoReplace
+	if opts.Replace {
+		rename = os.Rename
+	}
+	if err := rename(tmpName, dst); err != nil {
+		return nil, err
+	}
+	committed = true
+	return sum, nil
+}
+
+// HashFile returns the SHA-256 of a file's contents
+func HashFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package fileutils
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isCrossDevice reports whether err means source and destination are on
+// different filesystems
+func isCrossDevice(err error) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
@@ -0,0 +1,184 @@
+package fileutils
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTransferModes(t *testing.T) {
+	mtime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	tests := []struct {
+		mode         TransferMode
+		keepOriginal bool
+		sameFile     bool
+	}{
+		{ModeMove, false, false},
+		{ModeCopy, true, false},
+		{ModeHardlink, true, true},
+		{ModeReflink, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "src.png")
+			dst := filepath.Join(dir, "dst.png")
+			writeFile(t, src, []byte("screenshot data"), mtime)
+			if err := os.Chmod(src, 0640); err != nil {
+				t.Fatal(err)
+			}
+			srcInfo, _ := os.Stat(src)
+
+			if err := Transfer(tt.mode, src, dst, TransferOptions{}); err != nil {
+				t.Fatalf("Transfer() error = %v", err)
+			}
+
+			data, err := os.ReadFile(dst)
+			if err != nil || string(data) != "screenshot data" {
+				t.Fatalf("destination content = %q, %v", data, err)
+			}
+			dstInfo, err := os.Stat(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !dstInfo.ModTime().Equal(mtime) {
+				t.Errorf("destination mtime = %v, want %v", dstInfo.ModTime(), mtime)
+			}
+			if dstInfo.Mode().Perm() != srcInfo.Mode().Perm() {
+				t.Errorf("destination mode = %v, want %v", dstInfo.Mode().Perm(), srcInfo.Mode().Perm())
+			}
+			if got := os.SameFile(srcInfo, dstInfo); got != tt.sameFile && tt.keepOriginal {
+				t.Errorf("SameFile() = %v, want %v", got, tt.sameFile)
+			}
+
+			_, err = os.Stat(src)
+			if exists := err == nil; exists != tt.keepOriginal {
+				t.Errorf("original exists = %v, want %v", exists, tt.keepOriginal)
+			}
+
+			// No temporary files may be left behind
+			entries, _ := os.ReadDir(dir)
+			for _, e := range entries {
+				if filepath.Ext(e.Name()) == ".tmp" {
+					t.Errorf("leftover temporary file %s", e.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestTransferNoReplace(t *testing.T) {
+	for _, mode := range TransferModes {
+		t.Run(string(mode), func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "src.png")
+			dst := filepath.Join(dir, "dst.png")
+			writeFile(t, src, []byte("new"), time.Now())
+			writeFile(t, dst, []byte("old"), time.Now())
+
+			err := Transfer(mode, src, dst, TransferOptions{})
+			if !errors.Is(err, fs.ErrExist) {
+				t.Fatalf("Transfer() error = %v, want fs.ErrExist", err)
+			}
+			if data, _ := os.ReadFile(dst); string(data) != "old" {
+				t.Errorf("destination overwritten with %q", data)
+			}
+			if _, err := os.Stat(src); err != nil {
+				t.Errorf("source lost after failed transfer: %v", err)
+			}
+
+			if err := Transfer(mode, src, dst, TransferOptions{Replace: true}); err != nil {
+				t.Fatalf("Transfer() with replace error = %v", err)
+			}
+			if data, _ := os.ReadFile(dst); string(data) != "new" {
+				t.Errorf("destination content = %q, want %q", data, "new")
+			}
+			entries, _ := os.ReadDir(dir)
+			for _, e := range entries {
+				if filepath.Ext(e.Name()) == ".tmp" {
+					t.Errorf("leftover temporary file %s", e.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestRenameNoReplace(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.png")
+	dst := filepath.Join(dir, "dst.png")
+	writeFile(t, src, []byte("new"), time.Now())
+	writeFile(t, dst, []byte("old"), time.Now())
+
+	if err := RenameNoReplace(src, dst); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("RenameNoReplace() error = %v, want fs.ErrExist", err)
+	}
+	if err := os.Remove(dst); err != nil {
+		t.Fatal(err)
+	}
+	if err := RenameNoReplace(src, dst); err != nil {
+		t.Fatalf("RenameNoReplace() error = %v", err)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Error("source should be gone after rename")
+	}
+
+	// The link and unlink fallback behaves the same way
+	writeFile(t, src, []byte("newer"), time.Now())
+	if err := linkRename(src, dst); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("linkRename() error = %v, want fs.ErrExist", err)
+	}
+	if err := os.Remove(dst); err != nil {
+		t.Fatal(err)
+	}
+	if err := linkRename(src, dst); err != nil {
+		t.Fatalf("linkRename() error = %v", err)
+	}
+	if data, _ := os.ReadFile(dst); string(data) != "newer" {
+		t.Errorf("destination content = %q", data)
+	}
+}
+
+func TestMoveFileCrossDevice(t *testing.T) {
+	other, err := os.MkdirTemp("/dev/shm", "screenshot-sorter-test")
+	if err != nil {
+		t.Skip("no second filesystem available")
+	}
+	defer os.RemoveAll(other)
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.png")
+	writeFile(t, src, []byte("screenshot data"), time.Now())
+
+	if err := os.Link(src, filepath.Join(other, "probe")); err == nil || !isCrossDevice(err) {
+		t.Skip("temporary directories share a filesystem")
+	}
+
+	dst := filepath.Join(other, "dst.png")
+	if err := MoveFile(src, dst); err != nil {
+		t.Fatalf("MoveFile() error = %v", err)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Error("original should be removed after a verified copy")
+	}
+	if data, err := os.ReadFile(dst); err != nil || string(data) != "screenshot data" {
+		t.Errorf("destination content = %q, %v", data, err)
+	}
+}
+
+func TestParseTransferMode(t *testing.T) {
+	if m, err := ParseTransferMode(""); err != nil || m != ModeMove {
+		t.Errorf("ParseTransferMode(\"\") = %v, %v", m, err)
+	}
+	if m, err := ParseTransferMode("Copy"); err != nil || m != ModeCopy {
+		t.Errorf("ParseTransferMode(\"Copy\") = %v, %v", m, err)
+	}
+	if _, err := ParseTransferMode("teleport"); err == nil {
+		t.Error("ParseTransferMode() should reject unknown modes")
+	}
+}
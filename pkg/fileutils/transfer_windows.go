@@ -0,0 +1,16 @@
+package fileutils
+
+import (
+	"errors"
+	"syscall"
+)
+
+// errorNotSameDevice is ERROR_NOT_SAME_DEVICE, returned by MoveFileEx
+// when moving between volumes
+const errorNotSameDevice syscall.Errno = 17
+
+// isCrossDevice reports whether err means source and destination are on
+// different volumes
+func isCrossDevice(err error) bool {
+	return errors.Is(err, errorNotSameDevice)
+}
@@ -0,0 +1,250 @@
+// Package format identifies image files by their content, so files with a
+// wrong extension or none at all are recognized for what they are.
+package format
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// HeaderSize is the number of bytes at the start of a file that detection
+// looks at
+const HeaderSize = 256
+
+// Format is a kind of image file the sorter handles
+type Format struct {
+	// Name identifies the format, e.g. "png"
+	Name string
+	// Extensions lists the extensions of the format in lower case, with
+	// the leading dot. The first is the one misnamed files are given.
+	Extensions []string
+	// Detect reports whether header, the first HeaderSize bytes of a file
+	// or all of a shorter one, starts a file in the format. Nil means the
+	// format is only recognized by its extension. It must be safe for
+	// concurrent use.
+	Detect func(header []byte) bool
+}
+
+// Rename returns name with its extension replaced by the first extension
+// of f, or with that extension added if name has none
+func (f Format) Rename(name string) string {
+	if Ext(name) != "" {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return name + f.Extensions[0]
+}
+
+// Builtin returns the formats the sorter knows about out of the box
+func Builtin() []Format {
+	return []Format{
+		{Name: "png", Extensions: []string{".png"}, Detect: prefix("\x89PNG\r\n\x1a\n")},
+		{Name: "jpeg", Extensions: []string{".jpg", ".jpeg", ".jpe", ".jfif"}, Detect: prefix("\xFF\xD8\xFF")},
+		{Name: "gif", Extensions: []string{".gif"}, Detect: prefix("GIF87a", "GIF89a")},
+		{Name: "bmp", Extensions: []string{".bmp"}, Detect: detectBMP},
+		{Name: "webp", Extensions: []string{".webp"}, Detect: detectWebP},
+		{Name: "heic", Extensions: []string{".heic", ".heif", ".hif"}, Detect: detectHEIC},
+		{Name: "avif", Extensions: []string{".avif"}, Detect: detectAVIF},
+		{Name: "tiff", Extensions: []string{".tif", ".tiff"}, Detect: prefix("II*\x00", "MM\x00*", "II+\x00", "MM\x00+")},
+		{Name: "jxl", Extensions: []string{".jxl"}, Detect: prefix("\xFF\x0A", "\x00\x00\x00\x0CJXL \r\n\x87\n")},
+	}
+}
+
+// Registry holds the formats of a run. It is safe for concurrent use.
+type Registry struct {
+	// formats are tried in order by Detect
+	formats []Format
+	byExt   map[string]int
+}
+
+// NewRegistry returns a registry of the built-in formats and extra. Extra
+// formats are detected before the built-in ones and take over their
+// extensions, and one named like a built-in format replaces it.
+func NewRegistry(extra ...Format) (*Registry, error) {
+	r := &Registry{byExt: make(map[string]int)}
+	names := make(map[string]bool)
+	for _, f := range extra {
+		if f.Name == "" {
+			return nil, errors.New("image format without a name")
+		}
+		if names[f.Name] {
+			return nil, fmt.Errorf("duplicate image format %q", f.Name)
+		}
+		if len(f.Extensions) == 0 {
+			return nil, fmt.Errorf("image format %q has no extensions", f.Name)
+		}
+		for _, ext := range f.Extensions {
+			if Ext("x"+ext) != ext {
+				return nil, fmt.Errorf("image format %q has invalid extension %q", f.Name, ext)
+			}
+		}
+		names[f.Name] = true
+		r.add(f)
+	}
+	for _, f := range Builtin() {
+		if !names[f.Name] {
+			r.add(f)
+		}
+	}
+	return r, nil
+}
+
+func (r *Registry) add(f Format) {
+	for _, ext := range f.Extensions {
+		if _, taken := r.byExt[ext]; !taken {
+			r.byExt[ext] = len(r.formats)
+		}
+	}
+	r.formats = append(r.formats, f)
+}
+
+// Names returns the names of the formats in detection order
+func (r *Registry) Names() []string {
+	names := make([]string, len(r.formats))
+	for i, f := range r.formats {
+		names[i] = f.Name
+	}
+	return names
+}
+
+// ByExtension returns the format an extension, as returned by Ext,
+// belongs to
+func (r *Registry) ByExtension(ext string) (Format, bool) {
+	i, ok := r.byExt[ext]
+	if !ok {
+		return Format{}, false
+	}
+	return r.formats[i], true
+}
+
+// Detect returns the format of the file whose first bytes are header
+func (r *Registry) Detect(header []byte) (Format, bool) {
+	for _, f := range r.formats {
+		if f.Detect != nil && f.Detect(header) {
+			return f, true
+		}
+	}
+	return Format{}, false
+}
+
+// DetectFile reads the start of the file at path and returns its format.
+// ok is false if it is in none of the formats of r.
+func (r *Registry) DetectFile(path string) (f Format, ok bool, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Format{}, false, err
+	}
+	defer file.Close()
+	header := make([]byte, HeaderSize)
+	n, err := io.ReadFull(file, header)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return Format{}, false, err
+	}
+	f, ok = r.Detect(header[:n])
+	return f, ok, nil
+}
+
+// Ext returns the extension of a file name in lower case, or "" if it has
+// none. Only a final dot followed by letters and digits, at least one of
+// them a letter, starts an extension, so "Screenshot at 10.15.32" has
+// none. Dot files such as .DS_Store count as having one.
+func Ext(name string) string {
+	ext := filepath.Ext(name)
+	switch ext {
+	case "":
+		return ""
+	case name:
+		return strings.ToLower(ext)
+	}
+	letter := false
+	for _, c := range ext[1:] {
+		switch {
+		case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			letter = true
+		case c >= '0' && c <= '9':
+		default:
+			return ""
+		}
+	}
+	if !letter {
+		return ""
+	}
+	return strings.ToLower(ext)
+}
+
+// prefix detects files starting with one of signatures
+func prefix(signatures ...string) func([]byte) bool {
+	return func(header []byte) bool {
+		for _, sig := range signatures {
+			if bytes.HasPrefix(header, []byte(sig)) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// detectBMP checks the size of the DIB header as well, as "BM" alone
+// starts plenty of files that are not bitmaps
+func detectBMP(header []byte) bool {
+	if len(header) < 18 || string(header[:2]) != "BM" {
+		return false
+	}
+	switch binary.LittleEndian.Uint32(header[14:18]) {
+	case 12, 40, 52, 56, 64, 108, 124:
+		return true
+	}
+	return false
+}
+
+func detectWebP(header []byte) bool {
+	return len(header) >= 12 && string(header[:4]) == "RIFF" && string(header[8:12]) == "WEBP"
+}
+
+// brands returns the major and compatible brands of the ftyp box that
+// starts ISO media files such as HEIC and AVIF
+func brands(header []byte) []string {
+	if len(header) < 16 || string(header[4:8]) != "ftyp" {
+		return nil
+	}
+	size := int(binary.BigEndian.Uint32(header[:4]))
+	if size < 16 {
+		return nil
+	}
+	end := size
+	if end > len(header) {
+		end = len(header)
+	}
+	list := []string{string(header[8:12])}
+	for i := 16; i+4 <= end; i += 4 {
+		list = append(list, string(header[i:i+4]))
+	}
+	return list
+}
+
+func hasBrand(list []string, wanted ...string) bool {
+	for _, b := range list {
+		for _, w := range wanted {
+			if b == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func detectAVIF(header []byte) bool {
+	return hasBrand(brands(header), "avif", "avis")
+}
+
+// detectHEIC accepts HEVC and generic HEIF brands. AVIF files list the
+// generic brands too, so they are left to detectAVIF.
+func detectHEIC(header []byte) bool {
+	list := brands(header)
+	return hasBrand(list, "heic", "heix", "heim", "heis", "hevc", "hevx", "mif1", "msf1") && !hasBrand(list, "avif", "avis")
+}
@@ -0,0 +1,139 @@
+package format
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// ftyp builds the ftyp box of an ISO media file
+func ftyp(major string, compatible ...string) []byte {
+	box := []byte{0, 0, 0, byte(16 + 4*len(compatible)), 'f', 't', 'y', 'p'}
+	box = append(box, major...)
+	box = append(box, 0, 0, 0, 0)
+	for _, b := range compatible {
+		box = append(box, b...)
+	}
+	return append(box, "\x00\x00\x00\x20meta"...)
+}
+
+func TestDetect(t *testing.T) {
+	r, err := NewRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bmp := append([]byte("BM\x36\x00\x0C\x00\x00\x00\x00\x00\x36\x00\x00\x00"), 40, 0, 0, 0)
+	tests := []struct {
+		name   string
+		header []byte
+		want   string
+	}{
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"), "png"},
+		{"jpeg", []byte("\xFF\xD8\xFF\xE1\x00\x10Exif"), "jpeg"},
+		{"gif", []byte("GIF89a\x01\x00"), "gif"},
+		{"bmp", bmp, "bmp"},
+		{"webp", []byte("RIFF\x24\x00\x00\x00WEBPVP8 "), "webp"},
+		{"iphone heic", ftyp("heic", "mif1", "heic"), "heic"},
+		{"generic heif", ftyp("mif1", "mif1"), "heic"},
+		{"avif", ftyp("avif", "avif", "mif1", "miaf", "MA1B"), "avif"},
+		{"avif with a generic major brand", ftyp("mif1", "mif1", "avif"), "avif"},
+		{"little-endian tiff", []byte("II*\x00\x08\x00\x00\x00"), "tiff"},
+		{"big-endian tiff", []byte("MM\x00*\x00\x00\x00\x08"), "tiff"},
+		{"jxl codestream", []byte("\xFF\x0A\xFA\x1F"), "jxl"},
+		{"jxl container", []byte("\x00\x00\x00\x0CJXL \r\n\x87\n"), "jxl"},
+		{"text", []byte("<!DOCTYPE html>"), ""},
+		{"letter starting with BM", []byte("BMW service booklet"), ""},
+		{"mp4", ftyp("isom", "isom", "mp41"), ""},
+		{"empty", nil, ""},
+	}
+	for _, tt := range tests {
+		f, ok := r.Detect(tt.header)
+		if f.Name != tt.want || ok != (tt.want != "") {
+			t.Errorf("Detect(%s) = %q, %v; want %q", tt.name, f.Name, ok, tt.want)
+		}
+	}
+}
+
+func TestExt(t *testing.T) {
+	tests := map[string]string{
+		"shot.PNG":                             ".png",
+		"shot.PNG.jpg":                         ".jpg",
+		"IMG_0001.jpeg":                        ".jpeg",
+		"download":                             "",
+		"Screenshot 2023-01-01 at 10.15.32":    "",
+		"Screenshot 2023-01-01 at 10.15.32 PM": "",
+		"photo.jpg.crdownload":                 ".crdownload",
+		".DS_Store":                            ".ds_store",
+		"dir.d/file":                           "",
+	}
+	for name, want := range tests {
+		if got := Ext(name); got != want {
+			t.Errorf("Ext(%q) = %q, want %q", name, got, want)
+		}
+	}
+
+	jpeg := Builtin()[1]
+	for name, want := range map[string]string{"shot.PNG.png": "shot.PNG.jpg", "download": "download.jpg", "at 10.15.32": "at 10.15.32.jpg"} {
+		if got := jpeg.Rename(name); got != want {
+			t.Errorf("Rename(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	// A raw camera format built on TIFF has to be tried before TIFF, and
+	// a new PNG format takes over the built-in one
+	dng := Format{Name: "dng", Extensions: []string{".dng"}, Detect: func(h []byte) bool {
+		return len(h) > 8 && string(h[:4]) == "II*\x00" && h[8] == 'D'
+	}}
+	png := Format{Name: "png", Extensions: []string{".png", ".apng"}}
+	r, err := NewRegistry(dng, png)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"dng", "png", "jpeg", "gif", "bmp", "webp", "heic", "avif", "tiff", "jxl"}; !reflect.DeepEqual(r.Names(), want) {
+		t.Errorf("Names() = %v, want %v", r.Names(), want)
+	}
+	if f, _ := r.Detect([]byte("II*\x00\x08\x00\x00\x00D")); f.Name != "dng" {
+		t.Errorf("Detect() = %q, want dng", f.Name)
+	}
+	if f, _ := r.Detect([]byte("II*\x00\x08\x00\x00\x00\x00")); f.Name != "tiff" {
+		t.Errorf("Detect() = %q, want tiff", f.Name)
+	}
+	// The replacement has no Detect, so PNG content is no longer recognized
+	if _, ok := r.Detect([]byte("\x89PNG\r\n\x1a\n")); ok {
+		t.Error("Detect() recognized the replaced PNG format")
+	}
+	if f, ok := r.ByExtension(".apng"); !ok || f.Name != "png" {
+		t.Errorf("ByExtension(.apng) = %q, %v", f.Name, ok)
+	}
+	// Other registries are not affected
+	if other, _ := NewRegistry(); len(other.Names()) != len(Builtin()) {
+		t.Errorf("a new registry has formats %v", other.Names())
+	}
+
+	for _, bad := range [][]Format{
+		{{Extensions: []string{".x"}}},
+		{{Name: "x"}},
+		{{Name: "x", Extensions: []string{"x"}}},
+		{{Name: "x", Extensions: []string{".X"}}},
+		{{Name: "x", Extensions: []string{".x"}}, {Name: "x", Extensions: []string{".y"}}},
+	} {
+		if _, err := NewRegistry(bad...); err == nil {
+			t.Errorf("NewRegistry(%+v) should fail", bad)
+		}
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "short")
+	if err := os.WriteFile(path, []byte("GIF89a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if f, ok, err := r.DetectFile(path); err != nil || !ok || f.Name != "gif" {
+		t.Errorf("DetectFile() = %q, %v, %v; want gif", f.Name, ok, err)
+	}
+	if _, _, err := r.DetectFile(filepath.Join(dir, "missing")); err == nil {
+		t.Error("DetectFile() of a missing file should fail")
+	}
+}
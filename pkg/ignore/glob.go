@@ -0,0 +1,121 @@
+package ignore
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Glob is a compiled doublestar pattern, matched against slash-separated
+// relative paths. Within a path segment, * matches any run of characters,
+// ? any one character and [...] a character class, as in path.Match. A
+// segment of ** matches any number of segments, including none, and
+// {a,b} matches either alternative.
+type Glob struct {
+	pattern string
+	// alternatives holds the pattern with its braces expanded, each split
+	// into segments
+	alternatives [][]string
+}
+
+// Compile parses a doublestar pattern
+func Compile(pattern string) (*Glob, error) {
+	expanded, err := expandBraces(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	g := &Glob{pattern: pattern}
+	for _, alt := range expanded {
+		segments := strings.Split(alt, "/")
+		for _, seg := range segments {
+			if _, err := path.Match(seg, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+			}
+		}
+		g.alternatives = append(g.alternatives, segments)
+	}
+	return g, nil
+}
+
+// String returns the pattern g was compiled from
+func (g *Glob) String() string {
+	return g.pattern
+}
+
+// Match reports whether name matches g as a whole
+func (g *Glob) Match(name string) bool {
+	segments := strings.Split(name, "/")
+	for _, alt := range g.alternatives {
+		if matchSegments(alt, segments) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			rest := pattern[1:]
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(rest, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// expandBraces returns every pattern {a,b} alternatives in pattern stand
+// for, e.g. "*.{png,jpg}" becomes "*.png" and "*.jpg"
+func expandBraces(pattern string) ([]string, error) {
+	open, depth := -1, 0
+	var commas []int
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '{':
+			if depth == 0 {
+				open = i
+			}
+			depth++
+		case ',':
+			if depth == 1 {
+				commas = append(commas, i)
+			}
+		case '}':
+			if depth == 0 {
+				return nil, fmt.Errorf("unmatched }")
+			}
+			depth--
+			if depth > 0 {
+				continue
+			}
+			prefix, suffix := pattern[:open], pattern[i+1:]
+			bounds := append(append([]int{open}, commas...), i)
+			var expanded []string
+			for j := 0; j+1 < len(bounds); j++ {
+				alts, err := expandBraces(prefix + pattern[bounds[j]+1:bounds[j+1]] + suffix)
+				if err != nil {
+					return nil, err
+				}
+				expanded = append(expanded, alts...)
+			}
+			return expanded, nil
+		}
+	}
+	if depth > 0 {
+		return nil, fmt.Errorf("unmatched {")
+	}
+	return []string{pattern}, nil
+}
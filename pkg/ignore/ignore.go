@@ -0,0 +1,183 @@
+// Package ignore decides which files and directories a walk leaves out,
+// from -include and -exclude patterns and from .sortignore files, which
+// follow the rules of .gitignore.
+package ignore
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// FileName is the file listing what to leave out of the directory it is
+// in and the directories below
+const FileName = ".sortignore"
+
+// rule is one pattern of a .sortignore file or of -include or -exclude
+type rule struct {
+	glob *Glob
+	// negate is set for patterns starting with !, which bring back what
+	// an earlier pattern left out
+	negate bool
+	// dirOnly is set for patterns ending in /, which only match
+	// directories
+	dirOnly bool
+}
+
+// compileRule compiles a pattern the way .gitignore reads it: a pattern
+// with a slash at the start or in the middle is relative to its base
+// directory, and one without matches at any depth below it
+func compileRule(pattern string, negatable bool) (rule, error) {
+	var r rule
+	if negatable && strings.HasPrefix(pattern, "!") {
+		r.negate, pattern = true, pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		r.dirOnly, pattern = true, strings.TrimRight(pattern, "/")
+	}
+	if pattern == "" {
+		return r, fmt.Errorf("empty pattern")
+	}
+	if strings.Contains(pattern, "/") {
+		pattern = strings.TrimPrefix(pattern, "/")
+	} else {
+		pattern = "**/" + pattern
+	}
+	g, err := Compile(pattern)
+	r.glob = g
+	return r, err
+}
+
+func (r rule) match(rel string, isDir bool) bool {
+	return (isDir || !r.dirOnly) && r.glob.Match(rel)
+}
+
+// Filter holds the -include and -exclude patterns of a walk. A nil Filter
+// leaves nothing out.
+type Filter struct {
+	include []rule
+	exclude []rule
+}
+
+// NewFilter compiles include and exclude patterns. They are matched
+// against paths relative to the root of the walk.
+func NewFilter(include, exclude []string) (*Filter, error) {
+	f := &Filter{}
+	for _, pattern := range include {
+		r, err := compileRule(pattern, false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -include pattern %q: %w", pattern, err)
+		}
+		f.include = append(f.include, r)
+	}
+	for _, pattern := range exclude {
+		r, err := compileRule(pattern, false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -exclude pattern %q: %w", pattern, err)
+		}
+		f.exclude = append(f.exclude, r)
+	}
+	return f, nil
+}
+
+// Excluded reports whether the file or directory at rel matches an
+// exclude pattern
+func (f *Filter) Excluded(rel string, isDir bool) bool {
+	if f == nil {
+		return false
+	}
+	for _, r := range f.exclude {
+		if r.match(rel, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// Included reports whether the file at rel matches an include pattern, or
+// there are none
+func (f *Filter) Included(rel string) bool {
+	if f == nil || len(f.include) == 0 {
+		return true
+	}
+	for _, r := range f.include {
+		if r.match(rel, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// Rules are the .sortignore patterns in force in a directory of a walk:
+// those of its own .sortignore file on top of those of the directories
+// above it. A nil *Rules ignores nothing.
+type Rules struct {
+	parent *Rules
+	// dir is the directory of the file, relative to the root of the walk,
+	// or "" for the root
+	dir   string
+	rules []rule
+}
+
+// Add returns the rules in force in the directory at rel, relative to the
+// root of the walk, given the content of its .sortignore file: r with the
+// patterns in data on top. path names the file in errors.
+func (r *Rules) Add(rel, path string, data []byte) (*Rules, error) {
+	added := &Rules{parent: r, dir: filepath.ToSlash(rel)}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		pattern := trimLine(scanner.Text())
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+		// A backslash keeps a leading # or ! literal
+		negatable := true
+		if strings.HasPrefix(pattern, `\#`) || strings.HasPrefix(pattern, `\!`) {
+			pattern, negatable = pattern[1:], false
+		}
+		rl, err := compileRule(pattern, negatable)
+		if err != nil {
+			return r, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		added.rules = append(added.rules, rl)
+	}
+	if len(added.rules) == 0 {
+		return r, nil
+	}
+	return added, nil
+}
+
+// trimLine drops the line ending and trailing spaces, unless they are
+// escaped with a backslash
+func trimLine(line string) string {
+	line = strings.TrimSuffix(line, "\r")
+	trimmed := strings.TrimRight(line, " ")
+	if strings.HasSuffix(trimmed, `\`) && len(trimmed) < len(line) {
+		trimmed += " "
+	}
+	return trimmed
+}
+
+// Ignored reports whether the file or directory at rel, relative to the
+// root of the walk, is left out. The deepest .sortignore file with a
+// matching pattern decides, and within a file the last matching pattern.
+func (r *Rules) Ignored(rel string, isDir bool) bool {
+	rel = filepath.ToSlash(rel)
+	for ; r != nil; r = r.parent {
+		sub := rel
+		if r.dir != "" {
+			if !strings.HasPrefix(rel, r.dir+"/") {
+				continue
+			}
+			sub = rel[len(r.dir)+1:]
+		}
+		for i := len(r.rules) - 1; i >= 0; i-- {
+			if r.rules[i].match(sub, isDir) {
+				return !r.rules[i].negate
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package ignore
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"*.png", "a.png", true},
+		{"*.png", "dir/a.png", false},
+		{"**/*.png", "a.png", true},
+		{"**/*.png", "dir/sub/a.png", true},
+		{"dir/**", "dir/sub/a.png", true},
+		{"dir/**", "dir", true},
+		{"dir/**/a.png", "dir/a.png", true},
+		{"dir/**/a.png", "dir/x/y/a.png", true},
+		{"dir/**/a.png", "other/a.png", false},
+		{"*.{png,jp{e,}g}", "a.jpeg", true},
+		{"*.{png,jp{e,}g}", "a.jpg", true},
+		{"*.{png,jp{e,}g}", "a.gif", false},
+		{"IMG_????.png", "IMG_0001.png", true},
+		{"[ab]*", "c", false},
+		{`\{literal\}`, "{literal}", true},
+	}
+	for _, tt := range tests {
+		g, err := Compile(tt.pattern)
+		if err != nil {
+			t.Errorf("Compile(%q) error = %v", tt.pattern, err)
+			continue
+		}
+		if got := g.Match(tt.name); got != tt.want {
+			t.Errorf("Compile(%q).Match(%q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+
+	for _, pattern := range []string{"[a-", "{a,b", "a}"} {
+		if _, err := Compile(pattern); err == nil {
+			t.Errorf("Compile(%q) should fail", pattern)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	f, err := NewFilter([]string{"*.png", "phone/**"}, []string{"node_modules", "Unsorted keep/", "/top.png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		rel      string
+		isDir    bool
+		excluded bool
+		included bool
+	}{
+		{rel: "a.png", included: true},
+		{rel: "deep/er/a.png", included: true},
+		{rel: "a.jpg"},
+		{rel: "phone/a.jpg", included: true},
+		{rel: "node_modules", isDir: true, excluded: true},
+		{rel: "web/node_modules", isDir: true, excluded: true},
+		{rel: "Unsorted keep", isDir: true, excluded: true},
+		{rel: "Unsorted keep", isDir: false},
+		{rel: "top.png", excluded: true, included: true},
+		{rel: "sub/top.png", included: true},
+	}
+	for _, tt := range tests {
+		if got := f.Excluded(tt.rel, tt.isDir); got != tt.excluded {
+			t.Errorf("Excluded(%q, %v) = %v, want %v", tt.rel, tt.isDir, got, tt.excluded)
+		}
+		if !tt.isDir {
+			if got := f.Included(tt.rel); got != tt.included {
+				t.Errorf("Included(%q) = %v, want %v", tt.rel, got, tt.included)
+			}
+		}
+	}
+
+	var none *Filter
+	if none.Excluded("a.png", false) || !none.Included("a.png") {
+		t.Error("a nil Filter should leave nothing out")
+	}
+	if _, err := NewFilter(nil, []string{"[z-a"}); err == nil || !strings.Contains(err.Error(), "-exclude") {
+		t.Errorf("NewFilter() error = %v, want one naming -exclude", err)
+	}
+}
+
+func TestRules(t *testing.T) {
+	root, err := (*Rules)(nil).Add("", ".sortignore", []byte(`# Syncthing and friends
+.stversions/
+*.tmp.png
+drafts/
+!drafts/keep.png
+\#hash.png
+trailing.png
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := root.Add("album", "album/.sortignore", []byte("/local.png\n!*.tmp.png\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		rules *Rules
+		rel   string
+		isDir bool
+		want  bool
+	}{
+		{root, ".stversions", true, true},
+		{root, "x/.stversions", true, true},
+		{root, ".stversions", false, false},
+		{root, "a.tmp.png", false, true},
+		{root, "x/a.tmp.png", false, true},
+		{root, "drafts", true, true},
+		{root, "#hash.png", false, true},
+		{root, "trailing.png", false, true},
+		{root, "a.png", false, false},
+		// The deeper file overrides the one above
+		{sub, "album/a.tmp.png", false, false},
+		{sub, "album/local.png", false, true},
+		{sub, "album/deeper/local.png", false, false},
+		{sub, "local.png", false, false},
+		{sub, "other/a.tmp.png", false, true},
+	}
+	for _, tt := range tests {
+		if got := tt.rules.Ignored(tt.rel, tt.isDir); got != tt.want {
+			t.Errorf("Ignored(%q, %v) = %v, want %v", tt.rel, tt.isDir, got, tt.want)
+		}
+	}
+
+	if _, err := root.Add("bad", "bad/.sortignore", []byte("ok.png\n[z-a\n")); err == nil || !strings.HasPrefix(err.Error(), "bad/.sortignore:2: ") {
+		t.Errorf("Add() error = %v, want one naming bad/.sortignore:2", err)
+	}
+}
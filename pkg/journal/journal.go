@@ -0,0 +1,191 @@
+// Package journal records what each run of the sorter did to the file
+// system, so that a run can be undone later.
+package journal
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DirName is the directory under the target that holds the sorter's own
+// state. The sorter never sorts files inside it.
+const DirName = ".screenshot-sorter"
+
+// ext is the extension of journal files; undone runs are renamed to
+// ext + undoneSuffix and are no longer listed
+const (
+	ext          = ".jsonl"
+	undoneSuffix = ".undone"
+)
+
+// Op is the kind of change an entry records
+type Op string
+
+const (
+	// OpMove means the file was moved from Source to Dest
+	OpMove Op = "move"
+	// OpCopy means Dest is a new copy of Source
+	OpCopy Op = "copy"
+	// OpHardlink means Dest is a new hard link to Source
+	OpHardlink Op = "hardlink"
+	// OpReflink means Dest is a new clone of Source
+	OpReflink Op = "reflink"
+	// OpRemove means Source was deleted as a duplicate of Dest
+	OpRemove Op = "remove"
+)
+
+// Entry is one change made by a run
+type Entry struct {
+	Run    string `json:"run"`
+	Op     Op     `json:"op"`
+	Source string `json:"source"`
+	Dest   string `json:"dest"`
+	// Size and SHA256 describe the content at Dest right after the change
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// Replaced is set when Dest overwrote an existing file
+	Replaced bool      `json:"replaced,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// Journal appends the entries of one run to
+// <target>/.screenshot-sorter/journal/<run-id>.jsonl
+type Journal struct {
+	runID   string
+	path    string
+	mu      sync.Mutex
+	written bool
+}
+
+// NewRunID returns a run ID that sorts chronologically, e.g.
+// 20240315T143022Z-1a2b3c
+func NewRunID(t time.Time) string {
+	b := make([]byte, 3)
+	rand.Read(b)
+	return t.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b)
+}
+
+// Dir returns the directory holding the journals of targetDir
+func Dir(targetDir string) string {
+	return filepath.Join(targetDir, DirName, "journal")
+}
+
+// Open returns the journal for a run. Nothing is written until the first
+// entry is recorded, so runs that change nothing leave no journal behind.
+func Open(targetDir, runID string) *Journal {
+	return &Journal{runID: runID, path: filepath.Join(Dir(targetDir), runID+ext)}
+}
+
+// RunID returns the ID of the run the journal belongs to
+func (j *Journal) RunID() string {
+	return j.runID
+}
+
+// Path returns the journal file
+func (j *Journal) Path() string {
+	return j.path
+}
+
+// Written reports whether any entry has been recorded
+func (j *Journal) Written() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.written
+}
+
+// Record appends an entry, filling in its run ID and time. Each entry is
+// written out before Record returns, so the journal stays complete even
+// if the run is killed.
+func (j *Journal) Record(e Entry) error {
+	e.Run = j.runID
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(j.path), 0755); err != nil {
+		return fmt.Errorf("failed to create journal directory: %w", err)
+	}
+	f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	j.written = true
+	return f.Close()
+}
+
+// List returns the IDs of the runs in targetDir that can still be undone,
+// oldest first
+func List(targetDir string) ([]string, error) {
+	entries, err := os.ReadDir(Dir(targetDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var runs []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ext) {
+			runs = append(runs, strings.TrimSuffix(e.Name(), ext))
+		}
+	}
+	sort.Strings(runs)
+	return runs, nil
+}
+
+// Read returns the entries of a run in the order they were recorded
+func Read(targetDir, runID string) ([]Entry, error) {
+	if runID == "" || strings.ContainsAny(runID, `/\`) {
+		return nil, fmt.Errorf("invalid run ID %q", runID)
+	}
+	f, err := os.Open(filepath.Join(Dir(targetDir), runID+ext))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no journal for run %s in %s", runID, targetDir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	var truncated error
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		if truncated != nil {
+			return nil, truncated
+		}
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// A run killed mid-write can leave a partial last line, which
+			// is ignored; anywhere else it means the journal is corrupt
+			truncated = fmt.Errorf("journal %s line %d: %w", runID, line, err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
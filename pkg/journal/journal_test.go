@@ -0,0 +1,204 @@
+package journal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// sortFile moves or copies a test file the way the sorter would and
+// journals it
+func sortFile(t *testing.T, j *Journal, op Op, src, dst, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if op == OpMove {
+		os.Remove(src)
+	}
+	sum := sha256.Sum256([]byte(content))
+	err := j.Record(Entry{Op: op, Source: src, Dest: dst, Size: int64(len(content)), SHA256: hex.EncodeToString(sum[:])})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecordAndRead(t *testing.T) {
+	target := t.TempDir()
+	j := Open(target, NewRunID(time.Date(2024, 3, 15, 14, 30, 22, 0, time.UTC)))
+	if j.Written() {
+		t.Error("Written() before any entry")
+	}
+	if _, err := os.Stat(j.Path()); !os.IsNotExist(err) {
+		t.Error("journal file created before any entry")
+	}
+
+	sortFile(t, j, OpMove, "/src/a.png", filepath.Join(target, "2024", "a.png"), "a")
+	sortFile(t, j, OpCopy, "/src/b.png", filepath.Join(target, "2024", "b.png"), "b")
+
+	// A partial last line from a killed run is ignored
+	f, err := os.OpenFile(j.Path(), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"run":"x","op":"mo`)
+	f.Close()
+
+	entries, err := Read(target, j.RunID())
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if len(entries) != 2 || entries[0].Op != OpMove || entries[1].Source != "/src/b.png" {
+		t.Fatalf("Read() = %+v", entries)
+	}
+	if entries[0].Run != j.RunID() || entries[0].Time.IsZero() {
+		t.Errorf("Record() did not fill in run and time: %+v", entries[0])
+	}
+
+	runs, err := List(target)
+	if err != nil || len(runs) != 1 || runs[0] != j.RunID() {
+		t.Errorf("List() = %v, %v", runs, err)
+	}
+	if _, err := Read(target, "../escape"); err == nil {
+		t.Error("Read() should reject run IDs with path separators")
+	}
+}
+
+func TestUndo(t *testing.T) {
+	source := t.TempDir()
+	target := t.TempDir()
+	j := Open(target, NewRunID(time.Now()))
+
+	moved := filepath.Join(source, "moved.png")
+	sortFile(t, j, OpMove, moved, filepath.Join(target, "2024", "moved.png"), "moved")
+
+	copied := filepath.Join(source, "copied.png")
+	if err := os.WriteFile(copied, []byte("copied"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sortFile(t, j, OpCopy, copied, filepath.Join(target, "2024", "copied.png"), "copied")
+
+	// The duplicate removed from the source is identical to this file
+	duplicate := filepath.Join(source, "dup.png")
+	sortFile(t, j, OpRemove, duplicate, filepath.Join(target, "2023", "dup.png"), "dup")
+
+	edited := filepath.Join(source, "edited.png")
+	editedDest := filepath.Join(target, "2023", "edited.png")
+	sortFile(t, j, OpMove, edited, editedDest, "edited")
+	if err := os.WriteFile(editedDest, []byte("retouched"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A dry run changes nothing
+	result, err := Undo(context.Background(), target, j.RunID(), true)
+	if err != nil || len(result.Restored) != 3 || len(result.Failed) != 1 {
+		t.Fatalf("Undo(dry run) = %+v, %v", result, err)
+	}
+	if _, err := os.Stat(moved); !os.IsNotExist(err) {
+		t.Fatal("dry run restored a file")
+	}
+
+	result, err = Undo(context.Background(), target, j.RunID(), false)
+	if err != nil {
+		t.Fatalf("Undo() error = %v", err)
+	}
+	if len(result.Restored) != 3 {
+		t.Errorf("Restored = %+v, want 3 entries", result.Restored)
+	}
+	if len(result.Failed) != 1 || result.Failed[0].Entry.Source != edited || !errors.Is(result.Failed[0].Err, ErrModified) {
+		t.Fatalf("Failed = %+v, want the edited file", result.Failed)
+	}
+
+	for path, want := range map[string]string{moved: "moved", copied: "copied", duplicate: "dup", editedDest: "retouched"} {
+		if data, err := os.ReadFile(path); err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v; want %q", path, data, err, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(target, "2024")); !os.IsNotExist(err) {
+		t.Error("emptied bucket should be removed")
+	}
+
+	// Only the failed entry remains to be retried
+	entries, err := Read(target, j.RunID())
+	if err != nil || len(entries) != 1 || entries[0].Source != edited {
+		t.Fatalf("journal after undo = %+v, %v", entries, err)
+	}
+
+	// Once everything is restored the run is retired
+	if err := os.WriteFile(editedDest, []byte("edited"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if result, err := Undo(context.Background(), target, j.RunID(), false); err != nil || len(result.Failed) != 0 {
+		t.Fatalf("Undo() retry = %+v, %v", result, err)
+	}
+	if runs, _ := List(target); len(runs) != 0 {
+		t.Errorf("List() after full undo = %v", runs)
+	}
+}
+
+func TestUndoCanceled(t *testing.T) {
+	source := t.TempDir()
+	target := t.TempDir()
+	j := Open(target, NewRunID(time.Now()))
+	sortFile(t, j, OpMove, filepath.Join(source, "a.png"), filepath.Join(target, "2024", "a.png"), "a")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, err := Undo(ctx, target, j.RunID(), false)
+	if !errors.Is(err, context.Canceled) || len(result.Restored) != 0 {
+		t.Fatalf("Undo() = %+v, %v; want nothing restored and context.Canceled", result, err)
+	}
+	if entries, err := Read(target, j.RunID()); err != nil || len(entries) != 1 {
+		t.Errorf("journal after canceled undo = %+v, %v; want the entry kept", entries, err)
+	}
+}
+
+func TestUndoKeepsOnlyCopy(t *testing.T) {
+	target := t.TempDir()
+	j := Open(target, NewRunID(time.Now()))
+	dest := filepath.Join(target, "2024", "a.png")
+	sortFile(t, j, OpCopy, filepath.Join(t.TempDir(), "gone.png"), dest, "a")
+
+	result, err := Undo(context.Background(), target, j.RunID(), false)
+	if err != nil || len(result.Failed) != 1 {
+		t.Fatalf("Undo() = %+v, %v; want one failure", result, err)
+	}
+	if _, err := os.Stat(dest); err != nil {
+		t.Error("the only remaining copy was deleted")
+	}
+}
+
+func TestUndoPrunesRelativeTarget(t *testing.T) {
+	source := t.TempDir()
+	base := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(base); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	// Entries are journaled with absolute paths, -target as typed
+	j := Open("out", NewRunID(time.Now()))
+	sortFile(t, j, OpMove, filepath.Join(source, "a.png"), filepath.Join(base, "out", "2024", "04", "a.png"), "a")
+
+	if _, err := Undo(context.Background(), "out", j.RunID(), false); err != nil {
+		t.Fatalf("Undo() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(base, "out", "2024")); !os.IsNotExist(err) {
+		t.Error("emptied buckets should be removed")
+	}
+	if _, err := os.Stat(filepath.Join(base, "out")); err != nil {
+		t.Errorf("target should be kept: %v", err)
+	}
+}
@@ -0,0 +1,205 @@
+package journal
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/screenshot-sorter/pkg/fileutils"
+)
+
+// ErrModified is reported for files that changed after the run touched
+// them. Undo leaves such files alone.
+var ErrModified = errors.New("modified since it was sorted")
+
+// Failure is an entry Undo could not reverse
+type Failure struct {
+	Entry Entry
+	Err   error
+}
+
+// UndoResult lists what Undo did
+type UndoResult struct {
+	RunID    string
+	Restored []Entry
+	Failed   []Failure
+	// Warnings describe entries that were reversed only partially, such
+	// as files that had overwritten something that no longer exists
+	Warnings []string
+}
+
+// Undo reverses a run, newest change first. Moved files go back to their
+// original paths, copies and links are deleted and removed duplicates are
+// copied back. Files modified since the run are left alone and reported
+// in Failed, as is anything else that could not be restored.
+//
+// Unless dryRun is set, the journal is updated afterwards: a fully undone
+// run is retired, otherwise only the failed entries remain so the undo can
+// be retried once the problems are fixed.
+//
+// Canceling ctx stops Undo between entries. The entries not reached yet
+// stay in the journal along with the failed ones, and ctx.Err() is
+// returned with the result.
+func Undo(ctx context.Context, targetDir, runID string, dryRun bool) (*UndoResult, error) {
+	entries, err := Read(targetDir, runID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &UndoResult{RunID: runID}
+	var remaining []Entry
+	var stopped error
+	for i := len(entries) - 1; i >= 0; i-- {
+		if stopped = ctx.Err(); stopped != nil {
+			remaining = append(entries[:i+1:i+1], remaining...)
+			break
+		}
+		e := entries[i]
+		warning, err := undoEntry(targetDir, e, dryRun)
+		if err != nil {
+			result.Failed = append(result.Failed, Failure{Entry: e, Err: err})
+			remaining = append([]Entry{e}, remaining...)
+			continue
+		}
+		result.Restored = append(result.Restored, e)
+		if warning != "" {
+			result.Warnings = append(result.Warnings, warning)
+		}
+	}
+
+	if dryRun {
+		return result, stopped
+	}
+	if err := rewrite(targetDir, runID, remaining); err != nil {
+		return result, fmt.Errorf("failed to update journal: %w", err)
+	}
+	return result, stopped
+}
+
+func undoEntry(targetDir string, e Entry, dryRun bool) (string, error) {
+	if err := checkUnmodified(e); err != nil {
+		return "", err
+	}
+
+	switch e.Op {
+	case OpMove:
+		if _, err := os.Lstat(e.Source); err == nil {
+			return "", fmt.Errorf("%s exists again", e.Source)
+		}
+		if !dryRun {
+			if err := os.MkdirAll(filepath.Dir(e.Source), 0755); err != nil {
+				return "", err
+			}
+			if err := fileutils.MoveFile(e.Dest, e.Source); err != nil {
+				return "", err
+			}
+			pruneEmpty(filepath.Dir(e.Dest), targetDir)
+		}
+		if e.Replaced {
+			return fmt.Sprintf("%s had replaced an older file, which cannot be restored", e.Dest), nil
+		}
+
+	case OpCopy, OpHardlink, OpReflink:
+		// Without the original, the copy is the only one left
+		if _, err := os.Stat(e.Source); err != nil {
+			return "", fmt.Errorf("original %s is gone, keeping the copy: %w", e.Source, err)
+		}
+		if !dryRun {
+			if err := os.Remove(e.Dest); err != nil {
+				return "", err
+			}
+			pruneEmpty(filepath.Dir(e.Dest), targetDir)
+		}
+		if e.Replaced {
+			return fmt.Sprintf("%s had replaced an older file, which cannot be restored", e.Dest), nil
+		}
+
+	case OpRemove:
+		if _, err := os.Lstat(e.Source); err == nil {
+			return "", fmt.Errorf("%s exists again", e.Source)
+		}
+		if !dryRun {
+			if err := os.MkdirAll(filepath.Dir(e.Source), 0755); err != nil {
+				return "", err
+			}
+			if err := fileutils.CopyFile(e.Dest, e.Source); err != nil {
+				return "", err
+			}
+		}
+
+	default:
+		return "", fmt.Errorf("unknown operation %q", e.Op)
+	}
+	return "", nil
+}
+
+// checkUnmodified verifies that Dest still holds the content the run left
+// there
+func checkUnmodified(e Entry) error {
+	fi, err := os.Lstat(e.Dest)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() || fi.Size() != e.Size {
+		return fmt.Errorf("%s: %w", e.Dest, ErrModified)
+	}
+	sum, err := fileutils.HashFile(e.Dest)
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(sum) != e.SHA256 {
+		return fmt.Errorf("%s: %w", e.Dest, ErrModified)
+	}
+	return nil
+}
+
+// pruneEmpty removes dir and its parents up to, but not including, stop
+// for as long as they are empty. Journaled paths are absolute, so stop is
+// made absolute as well.
+func pruneEmpty(dir, stop string) {
+	stop, err := filepath.Abs(stop)
+	if err != nil {
+		return
+	}
+	for dir = filepath.Clean(dir); ; dir = filepath.Dir(dir) {
+		rel, err := filepath.Rel(stop, dir)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return
+		}
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
+
+// rewrite replaces a run's journal with the entries that are left, or
+// retires it when there are none
+func rewrite(targetDir, runID string, entries []Entry) error {
+	path := filepath.Join(Dir(targetDir), runID+ext)
+	if len(entries) == 0 {
+		return os.Rename(path, path+undoneSuffix)
+	}
+
+	tmp, err := os.CreateTemp(Dir(targetDir), "."+runID+".*.tmp")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(tmp)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,292 @@
+package layout
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default is the layout used when none is configured: one folder per year
+const Default = "{year}"
+
+// Template is a parsed destination path template such as
+// "{year}/{month:02}-{monthname}". Templates always use "/" as the
+// separator and expand to a relative, OS-specific path.
+type Template struct {
+	raw        string
+	components [][]segment
+	// patterns match each component's expansions, for MatchDir
+	patterns []*regexp.Regexp
+}
+
+// segment is either literal text or a single time token
+type segment struct {
+	literal string
+	token   string
+	width   int
+}
+
+// tokens maps every supported token name to its formatter. Numeric tokens
+// honour a zero-padding width such as {month:02}; textual tokens do not.
+// min and max bound the values of numeric tokens, and words lists every
+// value of a textual token, so directory names can be matched back.
+var tokens = map[string]struct {
+	numeric  bool
+	format   func(t time.Time) string
+	min, max int
+	words    []string
+}{
+	"year":      {numeric: true, format: func(t time.Time) string { return strconv.Itoa(t.Year()) }, min: 1000, max: 9999},
+	"month":     {numeric: true, format: func(t time.Time) string { return strconv.Itoa(int(t.Month())) }, min: 1, max: 12},
+	"monthname": {format: func(t time.Time) string { return t.Month().String() }, words: monthNames(0)},
+	"mon":       {format: func(t time.Time) string { return t.Month().String()[:3] }, words: monthNames(3)},
+	"day":       {numeric: true, format: func(t time.Time) string { return strconv.Itoa(t.Day()) }, min: 1, max: 31},
+	"quarter":   {numeric: true, format: func(t time.Time) string { return strconv.Itoa((int(t.Month())-1)/3 + 1) }, min: 1, max: 4},
+	"isoyear":   {numeric: true, format: func(t time.Time) string { y, _ := t.ISOWeek(); return strconv.Itoa(y) }, min: 1000, max: 9999},
+	"isoweek":   {numeric: true, format: func(t time.Time) string { _, w := t.ISOWeek(); return strconv.Itoa(w) }, min: 1, max: 53},
+	"weekday":   {format: func(t time.Time) string { return t.Weekday().String() }, words: weekdayNames()},
+	"hour":      {numeric: true, format: func(t time.Time) string { return strconv.Itoa(t.Hour()) }, min: 0, max: 23},
+}
+
+// monthNames lists the month names, cut to n characters unless n is 0
+func monthNames(n int) []string {
+	names := make([]string, 12)
+	for i := range names {
+		names[i] = time.Month(i + 1).String()
+		if n > 0 {
+			names[i] = names[i][:n]
+		}
+	}
+	return names
+}
+
+func weekdayNames() []string {
+	names := make([]string, 7)
+	for i := range names {
+		names[i] = time.Weekday(i).String()
+	}
+	return names
+}
+
+// Parse parses and validates a layout template
+func Parse(s string) (*Template, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, fmt.Errorf("layout is empty")
+	}
+	if strings.HasPrefix(s, "/") || strings.HasPrefix(s, `\`) || filepath.VolumeName(s) != "" {
+		return nil, fmt.Errorf("layout %q must be a relative path", s)
+	}
+
+	t := &Template{raw: s}
+	hasToken := false
+	for _, part := range strings.Split(s, "/") {
+		if part == "" {
+			return nil, fmt.Errorf("layout %q contains an empty path component", s)
+		}
+		if part == "." || part == ".." {
+			return nil, fmt.Errorf("layout %q must not contain %q components", s, part)
+		}
+		segments, err := parseComponent(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid layout %q: %w", s, err)
+		}
+		for _, seg := range segments {
+			if seg.token != "" {
+				hasToken = true
+			}
+		}
+		t.components = append(t.components, segments)
+		t.patterns = append(t.patterns, componentPattern(segments))
+	}
+	if !hasToken {
+		return nil, fmt.Errorf("layout %q does not contain any time token", s)
+	}
+	return t, nil
+}
+
+// MustParse is like Parse but panics on error
+func MustParse(s string) *Template {
+	t, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func parseComponent(part string) ([]segment, error) {
+	var segments []segment
+	for part != "" {
+		open := strings.IndexByte(part, '{')
+		if open < 0 {
+			if err := checkLiteral(part); err != nil {
+				return nil, err
+			}
+			segments = append(segments, segment{literal: part})
+			break
+		}
+		if open > 0 {
+			if err := checkLiteral(part[:open]); err != nil {
+				return nil, err
+			}
+			segments = append(segments, segment{literal: part[:open]})
+		}
+		end := strings.IndexByte(part[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated token in %q", part)
+		}
+		seg, err := parseToken(part[open+1 : open+end])
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, seg)
+		part = part[open+end+1:]
+	}
+	return segments, nil
+}
+
+func parseToken(body string) (segment, error) {
+	name, spec, hasSpec := strings.Cut(body, ":")
+	info, ok := tokens[name]
+	if !ok {
+		return segment{}, fmt.Errorf("unknown token {%s}", body)
+	}
+	seg := segment{token: name}
+	if hasSpec {
+		if !info.numeric {
+			return segment{}, fmt.Errorf("token {%s} does not accept a width", name)
+		}
+		if len(spec) < 2 || spec[0] != '0' {
+			return segment{}, fmt.Errorf("invalid width %q in {%s}, expected e.g. :02", spec, body)
+		}
+		width, err := strconv.Atoi(spec[1:])
+		if err != nil || width < 1 || width > 9 {
+			return segment{}, fmt.Errorf("invalid width %q in {%s}, expected e.g. :02", spec, body)
+		}
+		seg.width = width
+	}
+	return seg, nil
+}
+
+// checkLiteral rejects characters that cannot appear in file names on
+// every supported platform, so a layout behaves the same everywhere
+func checkLiteral(s string) error {
+	if strings.ContainsAny(s, "}") {
+		return fmt.Errorf("unexpected '}' in %q", s)
+	}
+	if i := strings.IndexAny(s, `\:*?"<>|`); i >= 0 {
+		return fmt.Errorf("invalid character %q in %q", s[i], s)
+	}
+	return nil
+}
+
+// String returns the template as it was written
+func (t *Template) String() string {
+	return t.raw
+}
+
+// Expand renders the template for the given time, using the time's own
+// location. The result is a relative path using the OS separator.
+func (t *Template) Expand(tm time.Time) string {
+	parts := make([]string, len(t.components))
+	for i, segments := range t.components {
+		var b strings.Builder
+		for _, seg := range segments {
+			if seg.token == "" {
+				b.WriteString(seg.literal)
+				continue
+			}
+			v := tokens[seg.token].format(tm)
+			for n := len(v); n < seg.width; n++ {
+				b.WriteByte('0')
+			}
+			b.WriteString(v)
+		}
+		parts[i] = b.String()
+	}
+	return filepath.Join(parts...)
+}
+
+// MatchDir reports whether dir, a relative path, is a directory Expand
+// can produce or one of its parents. "." matches, being the parent of
+// every expansion.
+func (t *Template) MatchDir(dir string) bool {
+	dir = filepath.ToSlash(filepath.Clean(dir))
+	if dir == "." {
+		return true
+	}
+	parts := strings.Split(dir, "/")
+	if len(parts) > len(t.components) {
+		return false
+	}
+	for i, part := range parts {
+		if !t.matchComponent(i, part) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *Template) matchComponent(i int, part string) bool {
+	m := t.patterns[i].FindStringSubmatch(part)
+	if m == nil {
+		return false
+	}
+	// Each token has a capture group; numbers must be in range and padded
+	// exactly as Expand would pad them
+	group := 1
+	for _, seg := range t.components[i] {
+		if seg.token == "" {
+			continue
+		}
+		info := tokens[seg.token]
+		v := m[group]
+		group++
+		if !info.numeric {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < info.min || n > info.max {
+			return false
+		}
+		want := strconv.Itoa(n)
+		for len(want) < seg.width {
+			want = "0" + want
+		}
+		if v != want {
+			return false
+		}
+	}
+	return true
+}
+
+// componentPattern builds a regexp matching every expansion of a
+// component, with one group per token
+func componentPattern(segments []segment) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, seg := range segments {
+		if seg.token == "" {
+			b.WriteString(regexp.QuoteMeta(seg.literal))
+			continue
+		}
+		info := tokens[seg.token]
+		if !info.numeric {
+			b.WriteString("(" + strings.Join(info.words, "|") + ")")
+			continue
+		}
+		digits := len(strconv.Itoa(info.max))
+		switch {
+		case seg.width >= digits:
+			fmt.Fprintf(&b, "([0-9]{%d})", seg.width)
+		case seg.width > 0:
+			fmt.Fprintf(&b, "([0-9]{%d,%d})", seg.width, digits)
+		default:
+			fmt.Fprintf(&b, "([0-9]{1,%d})", digits)
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
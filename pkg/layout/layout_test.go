@@ -0,0 +1,115 @@
+package layout
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExpand(t *testing.T) {
+	tm := time.Date(2023, time.April, 1, 10, 22, 33, 0, time.UTC)
+
+	tests := []struct {
+		layout string
+		want   string
+	}{
+		{"{year}", "2023"},
+		{"{year}/{month:02}-{monthname}/{day}", "2023/04-April/1"},
+		{"{year}/{month}/{day:02}", "2023/4/01"},
+		{"{year}/Q{quarter}", "2023/Q2"},
+		{"{isoyear}/W{isoweek:02}", "2023/W13"},
+		{"{year}/{mon} {weekday}", "2023/Apr Saturday"},
+		{"Screenshots {year}/{hour:02}h", "Screenshots 2023/10h"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.layout, func(t *testing.T) {
+			tmpl, err := Parse(tt.layout)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tt.layout, err)
+			}
+			if got := tmpl.Expand(tm); got != filepath.FromSlash(tt.want) {
+				t.Errorf("Expand() = %q, want %q", got, filepath.FromSlash(tt.want))
+			}
+		})
+	}
+}
+
+func TestExpandISOYearBoundary(t *testing.T) {
+	// 1 Jan 2021 belongs to ISO week 53 of 2020
+	tm := time.Date(2021, time.January, 1, 12, 0, 0, 0, time.UTC)
+	got := MustParse("{isoyear}/W{isoweek}").Expand(tm)
+	if want := filepath.FromSlash("2020/W53"); got != want {
+		t.Errorf("Expand() = %q, want %q", got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"   ",
+		"/{year}",
+		"{year}/../x",
+		"./{year}",
+		"{year}//{month}",
+		"{year",
+		"year}",
+		"{decade}",
+		"{monthname:02}",
+		"{month:2}",
+		"{month:0x}",
+		"static",
+		"{year}:{month}",
+		`{year}\{month}`,
+	}
+
+	for _, layout := range tests {
+		t.Run(layout, func(t *testing.T) {
+			if _, err := Parse(layout); err == nil {
+				t.Errorf("Parse(%q) expected error", layout)
+			}
+		})
+	}
+}
+
+func TestMatchDir(t *testing.T) {
+	tests := []struct {
+		layout string
+		dir    string
+		want   bool
+	}{
+		{"{year}", "2023", true},
+		{"{year}", ".", true},
+		{"{year}", "subdir", false},
+		{"{year}", "23", false},
+		{"{year}", "2023/2023", false},
+		{"{year}/{month:02}", "2023", true},
+		{"{year}/{month:02}", "2023/04", true},
+		{"{year}/{month:02}", "2023/4", false},
+		{"{year}/{month:02}", "2023/13", false},
+		{"{year}/{month}", "2023/4", true},
+		{"{year}/{month}", "2023/04", false},
+		{"{year}/{month:02}-{monthname}", "2023/04-April", true},
+		{"{year}/{month:02}-{monthname}", "2023/04-Apr", false},
+		{"photos-{year}/Q{quarter}", "photos-2023/Q2", true},
+		{"photos-{year}/Q{quarter}", "photos-2023/Q5", false},
+		{"{year}{month:02}", "202304", true},
+		{"{year}/{mon}/{day:02}", filepath.Join("2023", "Apr", "01"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.layout+" "+tt.dir, func(t *testing.T) {
+			if got := MustParse(tt.layout).MatchDir(tt.dir); got != tt.want {
+				t.Errorf("MatchDir(%q) = %v, want %v", tt.dir, got, tt.want)
+			}
+		})
+	}
+
+	// Every expansion is matched
+	tmpl := MustParse("{isoyear}-W{isoweek:02}/{weekday}/{hour:02}")
+	for tm := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC); tm.Year() < 2024; tm = tm.Add(97 * time.Hour) {
+		if dir := tmpl.Expand(tm); !tmpl.MatchDir(dir) {
+			t.Errorf("MatchDir(%q) = false for an expansion", dir)
+		}
+	}
+}
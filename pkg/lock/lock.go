@@ -0,0 +1,121 @@
+// Package lock keeps two sorters from working on the same target at once.
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/screenshot-sorter/pkg/journal"
+)
+
+// fileName is the lock file of a target, next to its journals. It holds
+// the process ID of the sorter working on the target, so it doubles as a
+// pidfile.
+const fileName = "sorter.pid"
+
+// errHeld is returned by lockFile when another process holds the lock
+var errHeld = errors.New("lock is held")
+
+// Path returns the lock file of targetDir
+func Path(targetDir string) string {
+	return filepath.Join(targetDir, journal.DirName, fileName)
+}
+
+// HeldError is returned by Acquire when another sorter works on the target
+type HeldError struct {
+	Path string
+	// PID is the process holding the lock, or 0 if it is not known
+	PID int
+}
+
+func (e *HeldError) Error() string {
+	if e.PID > 0 {
+		return fmt.Sprintf("another screenshot-sorter (pid %d) is working on this target (%s)", e.PID, e.Path)
+	}
+	return fmt.Sprintf("another screenshot-sorter is working on this target (%s)", e.Path)
+}
+
+// Lock is an exclusive lock on a target. The operating system releases it
+// when the process ends, however it ends.
+type Lock struct {
+	file *os.File
+}
+
+// Acquire locks targetDir and writes the process ID to its lock file. It
+// does not wait: if another process holds the lock, it returns a
+// *HeldError.
+func Acquire(targetDir string) (*Lock, error) {
+	path := Path(targetDir)
+	var file *os.File
+	for file == nil {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+		}
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open lock file: %w", err)
+		}
+		if err := lockFile(f); err != nil {
+			f.Close()
+			if errors.Is(err, errHeld) {
+				return nil, &HeldError{Path: path, PID: ReadPID(targetDir)}
+			}
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+		// The sorter that held the lock may have removed the file after it
+		// was opened here; a lock on a removed file keeps no one out
+		if opened, err := f.Stat(); err == nil {
+			if current, err := os.Stat(path); err == nil && os.SameFile(opened, current) {
+				file = f
+				continue
+			}
+		}
+		f.Close()
+	}
+
+	err := file.Truncate(0)
+	if err == nil {
+		_, err = file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write lock file: %w", err)
+	}
+	return &Lock{file: file}, nil
+}
+
+// Release unlocks the target and removes the lock file, along with the
+// state directory if nothing else is kept there. The file is removed while
+// still locked, and Acquire checks that the file it locked is in place, so
+// a sorter that opened it just before cannot lock a file no one else sees.
+func (l *Lock) Release() error {
+	path := l.file.Name()
+	removed := os.Remove(path) == nil
+	if !removed {
+		l.file.Truncate(0)
+	}
+	err := l.file.Close()
+	if !removed && removeAfterClose {
+		os.Remove(path)
+	}
+	os.Remove(filepath.Dir(path))
+	return err
+}
+
+// ReadPID returns the process ID in the lock file of targetDir, or 0 if
+// no sorter holds it or the file cannot be read
+func ReadPID(targetDir string) int {
+	data, err := os.ReadFile(Path(targetDir))
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
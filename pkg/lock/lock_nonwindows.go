@@ -0,0 +1,23 @@
+//go:build !windows
+
+package lock
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// removeAfterClose is not needed where files can be removed while open,
+// and would remove a file another sorter may have locked in the meantime
+const removeAfterClose = false
+
+// lockFile takes an flock on file, which belongs to the open file rather
+// than the process, so even a second Acquire in the same process fails
+func lockFile(file *os.File) error {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return errHeld
+	}
+	return err
+}
@@ -0,0 +1,54 @@
+package lock
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAcquire(t *testing.T) {
+	targetDir := t.TempDir()
+	l, err := Acquire(targetDir)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	if pid := ReadPID(targetDir); pid != os.Getpid() {
+		t.Errorf("ReadPID() = %d, want %d", pid, os.Getpid())
+	}
+
+	// A second sorter is turned away and told who holds the lock
+	_, err = Acquire(targetDir)
+	var held *HeldError
+	if !errors.As(err, &held) || held.PID != os.Getpid() {
+		t.Fatalf("second Acquire() error = %v, want a HeldError naming pid %d", err, os.Getpid())
+	}
+
+	if err := l.Release(); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if pid := ReadPID(targetDir); pid != 0 {
+		t.Errorf("ReadPID() after Release = %d, want 0", pid)
+	}
+	// Nothing is left behind in the target
+	if _, err := os.Stat(filepath.Dir(Path(targetDir))); !os.IsNotExist(err) {
+		t.Errorf("state directory left after Release: %v", err)
+	}
+
+	l, err = Acquire(targetDir)
+	if err != nil {
+		t.Fatalf("Acquire() after Release error = %v", err)
+	}
+	// The state directory stays while it holds journals
+	journals := filepath.Join(filepath.Dir(Path(targetDir)), "journal")
+	if err := os.Mkdir(journals, 0755); err != nil {
+		t.Fatal(err)
+	}
+	l.Release()
+	if _, err := os.Stat(Path(targetDir)); !os.IsNotExist(err) {
+		t.Errorf("lock file left after Release: %v", err)
+	}
+	if _, err := os.Stat(journals); err != nil {
+		t.Errorf("journals removed by Release: %v", err)
+	}
+}
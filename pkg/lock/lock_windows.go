@@ -0,0 +1,38 @@
+package lock
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// removeAfterClose is set because Windows does not remove files that are
+// open. Another sorter that opens the file in the meantime keeps it from
+// being removed, so it never locks a file no one else sees.
+const removeAfterClose = true
+
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+// Flags of LockFileEx, and the error it fails with when the range is
+// locked by another handle
+const (
+	lockfileFailImmediately               = 0x1
+	lockfileExclusiveLock                 = 0x2
+	errorLockViolation      syscall.Errno = 33
+)
+
+// lockFile locks a byte far past the end of file rather than its content,
+// since Windows keeps other handles from reading locked bytes and the
+// process ID must stay readable
+func lockFile(file *os.File) error {
+	overlapped := &syscall.Overlapped{Offset: 0xffffffff, OffsetHigh: 0x7fffffff}
+	r, _, err := procLockFileEx.Call(file.Fd(), lockfileExclusiveLock|lockfileFailImmediately,
+		0, 1, 0, uintptr(unsafe.Pointer(overlapped)))
+	if r != 0 {
+		return nil
+	}
+	if err == errorLockViolation {
+		return errHeld
+	}
+	return err
+}
@@ -0,0 +1,63 @@
+// Package sdnotify reports the state of a service to systemd over the
+// notification socket it passes in NOTIFY_SOCKET; see sd_notify(3).
+package sdnotify
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// States understood by systemd
+const (
+	// Ready tells systemd that startup is complete
+	Ready = "READY=1"
+	// Stopping tells systemd that the service is shutting down
+	Stopping = "STOPPING=1"
+	// Watchdog tells systemd that the service is still alive
+	Watchdog = "WATCHDOG=1"
+)
+
+// Status returns a state that shows status in "systemctl status"
+func Status(status string) string {
+	// A state is one assignment per line
+	return "STATUS=" + strings.ReplaceAll(status, "\n", " ")
+}
+
+// Notify sends states, one per line, to the service manager. It returns
+// false without doing anything when the process was not started by one
+// that listens.
+func Notify(states ...string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	// A name starting with @ is an abstract socket, which package net
+	// handles itself
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("failed to notify the service manager: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(strings.Join(states, "\n"))); err != nil {
+		return false, fmt.Errorf("failed to notify the service manager: %w", err)
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns how often the service manager expects Watchdog
+// before it considers the service hung, or 0 if it does not watch this
+// process
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
@@ -0,0 +1,277 @@
+// Package throttle limits the rate of filesystem operations and the
+// bandwidth of copies, optionally backing off while the filesystem is
+// slower than usual.
+package throttle
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Config describes the limits of a Throttle. Zero values mean no limit.
+type Config struct {
+	// Rate is the number of operations per second
+	Rate float64
+	// Burst is the number of operations that may start at once before
+	// Rate applies. Zero means 1.
+	Burst int
+	// Bandwidth is the number of bytes copied per second
+	Bandwidth int64
+	// Adaptive lowers the operation rate while operations take much
+	// longer than usual and raises it again, up to Rate, as they recover
+	Adaptive bool
+}
+
+// Validate rejects negative limits
+func (c Config) Validate() error {
+	switch {
+	case c.Rate < 0:
+		return fmt.Errorf("invalid rate %v, expected operations per second or 0 for no limit", c.Rate)
+	case c.Burst < 0:
+		return fmt.Errorf("invalid burst %d", c.Burst)
+	case c.Bandwidth < 0:
+		return fmt.Errorf("invalid bandwidth %d, expected bytes per second or 0 for no limit", c.Bandwidth)
+	}
+	return nil
+}
+
+// Throttle limits operations and bytes copied. It is safe for concurrent
+// use.
+type Throttle struct {
+	// ops is nil when operations are not limited
+	ops *rate.Limiter
+	// bytes is nil when bandwidth is not limited
+	bytes *rate.Limiter
+	// adapt is nil unless Config.Adaptive is set
+	adapt *adaptive
+}
+
+// New creates a Throttle from a valid Config
+func New(c Config) *Throttle {
+	t := &Throttle{}
+	burst := c.Burst
+	if burst == 0 {
+		burst = 1
+	}
+	limit := rate.Limit(c.Rate)
+	if c.Rate == 0 {
+		limit = rate.Inf
+	}
+	if limit != rate.Inf || c.Adaptive {
+		t.ops = rate.NewLimiter(limit, burst)
+	}
+	if c.Adaptive {
+		t.adapt = newAdaptive(t.ops, limit, time.Now())
+	}
+	if c.Bandwidth > 0 {
+		// Allow one second's worth of bytes at once
+		bytesBurst := c.Bandwidth
+		if bytesBurst > maxInt {
+			bytesBurst = maxInt
+		}
+		t.bytes = rate.NewLimiter(rate.Limit(c.Bandwidth), int(bytesBurst))
+	}
+	return t
+}
+
+const maxInt = int64(^uint(0) >> 1)
+
+// Do waits for the operation limit and runs fn, a single filesystem
+// operation. In adaptive mode the time fn takes feeds the rate. If ctx is
+// done before fn may start, fn does not run and ctx.Err() is returned.
+func (t *Throttle) Do(ctx context.Context, fn func() error) error {
+	return t.do(ctx, func() (bool, error) { return false, fn() })
+}
+
+// DoCopy is Do for an operation that may copy file data through Reader,
+// which fn reports in copied. How long a copy takes depends on the size of
+// the file and the bandwidth limit rather than on how the filesystem is
+// coping, so in adaptive mode only operations that copied nothing feed the
+// rate.
+func (t *Throttle) DoCopy(ctx context.Context, fn func() (copied bool, err error)) error {
+	return t.do(ctx, fn)
+}
+
+func (t *Throttle) do(ctx context.Context, fn func() (copied bool, err error)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if t == nil || t.ops == nil {
+		_, err := fn()
+		return err
+	}
+	if err := t.ops.Wait(ctx); err != nil {
+		// Wait also fails when ctx would expire before the operation could
+		// start; there is no point in waiting for that
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return err
+	}
+	if t.adapt == nil {
+		_, err := fn()
+		return err
+	}
+	start := time.Now()
+	copied, err := fn()
+	end := time.Now()
+	if !copied {
+		t.adapt.observe(end.Sub(start), end)
+	}
+	return err
+}
+
+// Limit returns the current operation rate; rate.Inf means no limit
+func (t *Throttle) Limit() rate.Limit {
+	if t == nil || t.ops == nil {
+		return rate.Inf
+	}
+	return t.ops.Limit()
+}
+
+// Reader wraps r so that reads from it keep to the bandwidth limit, and
+// fail with ctx.Err() once ctx is done. Without a limit r is returned as it
+// is, so copies can still use copy_file_range.
+func (t *Throttle) Reader(ctx context.Context, r io.Reader) io.Reader {
+	if t == nil || t.bytes == nil {
+		return r
+	}
+	return &limitedReader{ctx: ctx, r: r, limiter: t.bytes}
+}
+
+type limitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if burst := l.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := l.r.Read(p)
+	if n > 0 {
+		if werr := l.limiter.WaitN(l.ctx, n); werr != nil && err == nil {
+			err = werr
+			if ctxErr := l.ctx.Err(); ctxErr != nil {
+				err = ctxErr
+			}
+		}
+	}
+	return n, err
+}
+
+// ParseBytes parses a byte count such as "500000", "512K", "10M" or "1G".
+// Suffixes are powers of 1024 and may be followed by "B" or "iB".
+func ParseBytes(s string) (int64, error) {
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	upper = strings.TrimSuffix(strings.TrimSuffix(upper, "IB"), "B")
+	multiplier := int64(1)
+	if upper != "" {
+		if i := strings.IndexByte("KMGT", upper[len(upper)-1]); i >= 0 {
+			multiplier = int64(1) << (10 * (i + 1))
+			upper = upper[:len(upper)-1]
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(upper), 10, 64)
+	if err != nil || n < 0 || n > maxInt/multiplier {
+		return 0, fmt.Errorf("invalid byte count %q, expected e.g. 500000, 512K or 10M", s)
+	}
+	return n * multiplier, nil
+}
+
+// Adaptive tuning. Latency is smoothed like a TCP round-trip time; the
+// rate is halved while it stays well above what the filesystem manages
+// when healthy, and raised by a tenth at a time once it is back to normal.
+const (
+	adjustInterval = time.Second
+	// slowFactor and recoveredFactor are compared with the ratio of the
+	// smoothed latency to the baseline
+	slowFactor      = 2.0
+	recoveredFactor = 1.25
+	// minSlowLatency keeps the jitter of fast local disks from counting as
+	// a slowdown
+	minSlowLatency = 10 * time.Millisecond
+	// minRate is the lowest rate adaptive mode backs off to
+	minRate = rate.Limit(1)
+)
+
+type adaptive struct {
+	mu      sync.Mutex
+	limiter *rate.Limiter
+	// ceiling is the configured rate, rate.Inf for none
+	ceiling rate.Limit
+	// avg is the smoothed latency and baseline the lowest avg seen, which
+	// drifts slowly towards avg so that a lasting change becomes the norm
+	avg      time.Duration
+	baseline time.Duration
+	// adjusted is when the rate was last considered; done counts the
+	// operations finished since, to estimate the rate when there is no
+	// limit yet
+	adjusted time.Time
+	done     int
+}
+
+func newAdaptive(limiter *rate.Limiter, ceiling rate.Limit, now time.Time) *adaptive {
+	return &adaptive{limiter: limiter, ceiling: ceiling, adjusted: now}
+}
+
+// observe records the latency of an operation that finished at now
+func (a *adaptive) observe(latency time.Duration, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.avg == 0 {
+		a.avg = latency
+	} else {
+		a.avg += (latency - a.avg) / 8
+	}
+	if a.baseline == 0 || a.avg < a.baseline {
+		a.baseline = a.avg
+	}
+	a.done++
+
+	elapsed := now.Sub(a.adjusted)
+	if elapsed < adjustInterval || a.baseline <= 0 {
+		return
+	}
+	ratio := float64(a.avg) / float64(a.baseline)
+	observed := rate.Limit(float64(a.done) / elapsed.Seconds())
+	current := a.limiter.Limit()
+	switch {
+	case ratio > slowFactor && a.avg > minSlowLatency:
+		if current == rate.Inf {
+			current = observed
+		}
+		next := current / 2
+		if next < minRate {
+			next = minRate
+		}
+		a.limiter.SetLimitAt(now, next)
+	case ratio < recoveredFactor && current < a.ceiling:
+		step := a.ceiling / 10
+		if a.ceiling == rate.Inf {
+			step = current / 10
+		}
+		if step < minRate {
+			step = minRate
+		}
+		next := current + step
+		// Without a configured rate, the limit goes once it is well above
+		// what the filesystem delivers
+		if next > a.ceiling || (a.ceiling == rate.Inf && next > 2*observed) {
+			next = a.ceiling
+		}
+		a.limiter.SetLimitAt(now, next)
+	}
+	a.baseline += (a.avg - a.baseline) / 16
+	a.adjusted = now
+	a.done = 0
+}
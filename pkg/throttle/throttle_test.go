@@ -0,0 +1,196 @@
+package throttle
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestParseBytes(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"0", 0, false},
+		{"500000", 500000, false},
+		{"512K", 512 << 10, false},
+		{"10M", 10 << 20, false},
+		{"10MiB", 10 << 20, false},
+		{"1gb", 1 << 30, false},
+		{"", 0, true},
+		{"-1", 0, true},
+		{"fast", 0, true},
+		{"1.5M", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseBytes(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseBytes(%q) = %d, %v, want %d (error %v)", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	for _, c := range []Config{{Rate: -1}, {Burst: -1}, {Bandwidth: -1}} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Validate(%+v) should fail", c)
+		}
+	}
+	if err := (Config{}).Validate(); err != nil {
+		t.Errorf("Validate() of the zero Config error = %v", err)
+	}
+}
+
+func TestUnlimited(t *testing.T) {
+	th := New(Config{})
+	if th.Limit() != rate.Inf {
+		t.Errorf("Limit() = %v, want no limit", th.Limit())
+	}
+	r := bytes.NewReader(nil)
+	if th.Reader(context.Background(), r) != r {
+		t.Error("Reader() should not wrap readers without a bandwidth limit")
+	}
+}
+
+func TestReaderBandwidth(t *testing.T) {
+	const bandwidth = 64 << 10
+	th := New(Config{Bandwidth: bandwidth})
+	data := make([]byte, bandwidth*3/2)
+
+	start := time.Now()
+	n, err := io.Copy(io.Discard, th.Reader(context.Background(), bytes.NewReader(data)))
+	elapsed := time.Since(start)
+	if err != nil || n != int64(len(data)) {
+		t.Fatalf("copied %d bytes, error %v", n, err)
+	}
+	// The first second's worth is available at once, the rest takes half a
+	// second
+	if elapsed < 400*time.Millisecond {
+		t.Errorf("copied %d bytes at %d bytes/s in %v", len(data), bandwidth, elapsed)
+	}
+}
+
+func TestCanceled(t *testing.T) {
+	th := New(Config{Rate: 1, Bandwidth: 1024})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// The first operation uses the burst, the second waits until cancel
+	if err := th.Do(ctx, func() error { return nil }); err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	time.AfterFunc(50*time.Millisecond, cancel)
+	ran := false
+	err := th.Do(ctx, func() error { ran = true; return nil })
+	if !errors.Is(err, context.Canceled) || ran {
+		t.Errorf("Do() after cancel = %v, ran %v; want context.Canceled without running", err, ran)
+	}
+
+	_, err = io.Copy(io.Discard, th.Reader(ctx, bytes.NewReader(make([]byte, 4096))))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("copy after cancel error = %v, want context.Canceled", err)
+	}
+}
+
+func TestAdaptiveIgnoresCopies(t *testing.T) {
+	const bandwidth = 64 << 10
+	th := New(Config{Rate: 100, Bandwidth: bandwidth, Adaptive: true})
+	ctx := context.Background()
+	quick := func() error { return nil }
+
+	// Copies past the first second's worth wait for the bandwidth limit,
+	// which says nothing about how the filesystem is doing
+	for i := 0; i < 4; i++ {
+		if err := th.Do(ctx, quick); err != nil {
+			t.Fatal(err)
+		}
+		err := th.DoCopy(ctx, func() (bool, error) {
+			_, err := io.Copy(io.Discard, th.Reader(ctx, bytes.NewReader(make([]byte, bandwidth/2))))
+			return true, err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := th.Do(ctx, quick); err != nil {
+		t.Fatal(err)
+	}
+	if th.Limit() != 100 {
+		t.Errorf("rate = %v after bandwidth-limited copies, want 100", th.Limit())
+	}
+}
+
+func TestAdaptive(t *testing.T) {
+	limiter := rate.NewLimiter(100, 1)
+	now := time.Now()
+	a := newAdaptive(limiter, 100, now)
+
+	// feed reports one operation per 10ms for d, each taking latency
+	feed := func(d, latency time.Duration) {
+		for end := now.Add(d); now.Before(end); now = now.Add(10 * time.Millisecond) {
+			a.observe(latency, now)
+		}
+	}
+
+	feed(3*time.Second, 20*time.Millisecond)
+	if limiter.Limit() != 100 {
+		t.Fatalf("steady latency changed the rate to %v", limiter.Limit())
+	}
+
+	feed(3*time.Second, 200*time.Millisecond)
+	slow := limiter.Limit()
+	if slow > 25 {
+		t.Fatalf("rate = %v after latency rose tenfold, want it to back off", slow)
+	}
+
+	feed(30*time.Second, 20*time.Millisecond)
+	if limiter.Limit() != 100 {
+		t.Errorf("rate = %v after latency recovered, want 100", limiter.Limit())
+	}
+}
+
+func TestAdaptiveUnlimited(t *testing.T) {
+	limiter := rate.NewLimiter(rate.Inf, 1)
+	now := time.Now()
+	a := newAdaptive(limiter, rate.Inf, now)
+	feed := func(d, latency time.Duration) {
+		for end := now.Add(d); now.Before(end); now = now.Add(10 * time.Millisecond) {
+			a.observe(latency, now)
+		}
+	}
+
+	feed(3*time.Second, 20*time.Millisecond)
+	feed(3*time.Second, 200*time.Millisecond)
+	// 100 operations per second were observed, so backing off starts at 50
+	if limit := limiter.Limit(); limit == rate.Inf || limit > 50 {
+		t.Fatalf("rate = %v after latency rose tenfold, want at most 50", limit)
+	}
+	feed(60*time.Second, 20*time.Millisecond)
+	if limiter.Limit() != rate.Inf {
+		t.Errorf("rate = %v after latency recovered, want no limit", limiter.Limit())
+	}
+}
+
+func TestAdaptiveFastDisk(t *testing.T) {
+	limiter := rate.NewLimiter(rate.Inf, 1)
+	now := time.Now()
+	a := newAdaptive(limiter, rate.Inf, now)
+	// Latencies jump tenfold, but stay far below anything a user would
+	// notice
+	for i := 0; i < 500; i++ {
+		latency := 50 * time.Microsecond
+		if i%2 == 1 {
+			latency = 500 * time.Microsecond
+		}
+		now = now.Add(10 * time.Millisecond)
+		a.observe(latency, now)
+	}
+	if limiter.Limit() != rate.Inf {
+		t.Errorf("rate = %v, want no limit", limiter.Limit())
+	}
+}
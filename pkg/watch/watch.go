@@ -0,0 +1,148 @@
+// Package watch reports files as they appear in a directory tree and
+// have stopped changing.
+package watch
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Defaults for Options
+const (
+	// DefaultQuiet is how long a file must go unchanged before it is
+	// reported
+	DefaultQuiet = time.Second
+	// DefaultRescan is how often directories that cannot be watched are
+	// scanned instead
+	DefaultRescan = 30 * time.Second
+)
+
+// ErrUnsupported is returned by Watch on systems without inotify
+var ErrUnsupported = errors.New("watching directories is only supported on Linux")
+
+// Options adjusts what Watch observes and reports
+type Options struct {
+	// Recursive watches the subdirectories of the root as well, including
+	// those created or moved in later
+	Recursive bool
+	// SkipDir, if set, excludes directories below the root from the watch
+	SkipDir func(path string) bool
+	// Match, if set, limits the files reported to those whose name it
+	// accepts
+	Match func(name string) bool
+	// Quiet is how long a file must go without events, and without its
+	// size or modification time changing, before it is reported. Zero
+	// means DefaultQuiet.
+	Quiet time.Duration
+	// Rescan is how often directories that cannot be watched, e.g. because
+	// the inotify watch limit is reached, are scanned instead. Zero means
+	// DefaultRescan.
+	Rescan time.Duration
+	// Warn, if set, receives problems that do not stop the watch
+	Warn func(error)
+	// Ready, if set, is called once the watch is set up, before any file
+	// is reported
+	Ready func()
+}
+
+func (o *Options) quiet() time.Duration {
+	if o.Quiet > 0 {
+		return o.Quiet
+	}
+	return DefaultQuiet
+}
+
+func (o *Options) rescan() time.Duration {
+	if o.Rescan > 0 {
+		return o.Rescan
+	}
+	return DefaultRescan
+}
+
+func (o *Options) warn(err error) {
+	if o.Warn != nil {
+		o.Warn(err)
+	}
+}
+
+func (o *Options) match(name string) bool {
+	return o.Match == nil || o.Match(name)
+}
+
+// pending tracks files that have appeared or changed until they settle
+type pending struct {
+	quiet time.Duration
+	files map[string]*pendingFile
+}
+
+type pendingFile struct {
+	// size is -1 until the file has been looked at
+	size    int64
+	modTime time.Time
+	// changed is when the file was last seen to change
+	changed time.Time
+}
+
+func newPending(quiet time.Duration) *pending {
+	return &pending{quiet: quiet, files: make(map[string]*pendingFile)}
+}
+
+// touch notes that path changed at now
+func (p *pending) touch(path string, now time.Time) {
+	if f := p.files[path]; f != nil {
+		f.changed = now
+		return
+	}
+	p.files[path] = &pendingFile{size: -1, changed: now}
+}
+
+// settled removes and returns the files that have not changed for the
+// quiet period, sorted by path. Files that are gone are dropped.
+func (p *pending) settled(now time.Time) []string {
+	var ready []string
+	for path, f := range p.files {
+		if now.Sub(f.changed) < p.quiet {
+			continue
+		}
+		fi, err := os.Stat(path)
+		if err != nil || !fi.Mode().IsRegular() {
+			delete(p.files, path)
+			continue
+		}
+		// A file still being written grows, or at least gets a new
+		// modification time, between two looks a quiet period apart
+		if fi.Size() != f.size || !fi.ModTime().Equal(f.modTime) {
+			f.size, f.modTime, f.changed = fi.Size(), fi.ModTime(), now
+			continue
+		}
+		delete(p.files, path)
+		ready = append(ready, path)
+	}
+	sort.Strings(ready)
+	return ready
+}
+
+// scanDir reports the matching files in dir to file and returns its
+// subdirectories
+func scanDir(dir string, o *Options, file func(path string, entry os.DirEntry)) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		switch {
+		case e.IsDir():
+			if o.SkipDir == nil || !o.SkipDir(path) {
+				dirs = append(dirs, path)
+			}
+		case e.Type().IsRegular() && o.match(e.Name()):
+			file(path, e)
+		}
+	}
+	return dirs, nil
+}
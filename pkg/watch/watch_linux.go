@@ -0,0 +1,330 @@
+//go:build linux
+
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// watchMask selects the inotify events Watch needs: files finished or
+// moved in, files still being written, new directories, and watched
+// directories going away
+const watchMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE | syscall.IN_MODIFY |
+	syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF | syscall.IN_ONLYDIR
+
+// addWatch is syscall.InotifyAddWatch; tests replace it to run out of
+// watches
+var addWatch = syscall.InotifyAddWatch
+
+// event is a decoded inotify event
+type event struct {
+	wd   int32
+	mask uint32
+	name string
+}
+
+// fileState is what a scan of an unwatched directory saw of a file
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+type watcher struct {
+	root    string
+	opts    *Options
+	fd      int
+	pending *pending
+	// dirs and wds map watch descriptors to directories and back
+	dirs map[int32]string
+	wds  map[string]int32
+	// unwatched holds the directories that could not be watched, which
+	// are scanned every Rescan instead, and seen what those scans found
+	unwatched map[string]bool
+	seen      map[string]fileState
+	// limitWarned is set once running out of watches has been reported
+	limitWarned bool
+}
+
+// Watch reports every file in root, and below it with Recursive, to found
+// once the file has stopped changing: files there when Watch starts, files
+// created or moved in later, and files in directories created or moved in
+// later. found is called from one goroutine, so it may take its time;
+// events arriving meanwhile are queued. Watch returns when ctx is
+// canceled, or with an error if root cannot be watched or goes away.
+func Watch(ctx context.Context, root string, opts Options, found func(path string)) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("failed to start watching: %w", err)
+	}
+	// A non-blocking descriptor goes through the runtime poller, so
+	// closing the file stops the reader below
+	file := os.NewFile(uintptr(fd), "inotify")
+	defer file.Close()
+
+	w := &watcher{
+		root:      filepath.Clean(root),
+		opts:      &opts,
+		fd:        fd,
+		pending:   newPending(opts.quiet()),
+		dirs:      make(map[int32]string),
+		wds:       make(map[string]int32),
+		unwatched: make(map[string]bool),
+		seen:      make(map[string]fileState),
+	}
+	if err := w.addTree(w.root, time.Now()); err != nil {
+		return err
+	}
+	if opts.Ready != nil {
+		opts.Ready()
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	events := make(chan []event)
+	readErr := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := file.Read(buf)
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case events <- parseEvents(buf[:n]):
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	interval := w.pending.quiet / 4
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	settle := time.NewTicker(interval)
+	defer settle.Stop()
+	rescan := time.NewTicker(opts.rescan())
+	defer rescan.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-readErr:
+			return fmt.Errorf("failed to read file system events: %w", err)
+		case evs := <-events:
+			now := time.Now()
+			for _, ev := range evs {
+				if err := w.handle(ev, now); err != nil {
+					return err
+				}
+			}
+		case now := <-settle.C:
+			for _, path := range w.pending.settled(now) {
+				if ctx.Err() != nil {
+					return nil
+				}
+				found(path)
+			}
+		case now := <-rescan.C:
+			w.rescanUnwatched(now)
+		}
+	}
+}
+
+// parseEvents decodes the events in buf
+func parseEvents(buf []byte) []event {
+	var evs []event
+	for off := 0; off+syscall.SizeofInotifyEvent <= len(buf); {
+		raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+		nameStart := off + syscall.SizeofInotifyEvent
+		nameEnd := nameStart + int(raw.Len)
+		if nameEnd > len(buf) {
+			break
+		}
+		evs = append(evs, event{
+			wd:   raw.Wd,
+			mask: raw.Mask,
+			// The name is padded with NUL bytes
+			name: strings.TrimRight(string(buf[nameStart:nameEnd]), "\x00"),
+		})
+		off = nameEnd
+	}
+	return evs
+}
+
+// handle reacts to one event
+func (w *watcher) handle(ev event, now time.Time) error {
+	if ev.mask&syscall.IN_Q_OVERFLOW != 0 {
+		// Events were lost; look at everything again
+		w.opts.warn(errors.New("too many file system events at once, rescanning"))
+		return w.addTree(w.root, now)
+	}
+	dir, ok := w.dirs[ev.wd]
+	if !ok {
+		return nil
+	}
+
+	if ev.mask&(syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF|syscall.IN_IGNORED) != 0 {
+		// A directory moved within the tree was already taken over under
+		// its new name when the move arrived, which comes first
+		if ev.mask&syscall.IN_MOVE_SELF != 0 && dir != w.root {
+			if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+				return nil
+			}
+		}
+		if dir == w.root {
+			return fmt.Errorf("%s was moved or deleted", w.root)
+		}
+		w.forget(dir)
+		return nil
+	}
+	if ev.name == "" {
+		return nil
+	}
+
+	path := filepath.Join(dir, ev.name)
+	if ev.mask&syscall.IN_ISDIR != 0 {
+		if ev.mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 && w.opts.Recursive &&
+			(w.opts.SkipDir == nil || !w.opts.SkipDir(path)) {
+			if err := w.addTree(path, now); err != nil {
+				w.opts.warn(err)
+			}
+		}
+		return nil
+	}
+	if w.opts.match(ev.name) {
+		w.pending.touch(path, now)
+	}
+	return nil
+}
+
+// addTree watches dir, and its subdirectories with Recursive, and adds
+// the files already there. The watch is set up before the scan, so a file
+// created in between is caught by one or the other. Only errors about dir
+// itself are returned; those about subdirectories go to Warn.
+func (w *watcher) addTree(dir string, now time.Time) error {
+	if err := w.add(dir); err != nil {
+		return err
+	}
+	subdirs, err := scanDir(dir, w.opts, func(path string, entry os.DirEntry) {
+		w.pending.touch(path, now)
+		if w.unwatched[dir] {
+			w.remember(path, entry)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if !w.opts.Recursive {
+		return nil
+	}
+	for _, sub := range subdirs {
+		if err := w.addTree(sub, now); err != nil {
+			w.opts.warn(err)
+		}
+	}
+	return nil
+}
+
+// add watches dir. Once the watch limit is reached, dir is scanned
+// regularly instead.
+func (w *watcher) add(dir string) error {
+	wd, err := addWatch(w.fd, dir, watchMask)
+	if errors.Is(err, syscall.ENOSPC) {
+		if !w.limitWarned {
+			w.limitWarned = true
+			w.opts.warn(fmt.Errorf("out of inotify watches (see fs.inotify.max_user_watches); "+
+				"directories from %s on are scanned every %v instead", dir, w.opts.rescan()))
+		}
+		w.unwatched[dir] = true
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to watch %s: %w", dir, err)
+	}
+	delete(w.unwatched, dir)
+	// Watching a directory again after it moved returns its old
+	// descriptor
+	if old, ok := w.dirs[int32(wd)]; ok && old != dir {
+		delete(w.wds, old)
+	}
+	w.dirs[int32(wd)] = dir
+	w.wds[dir] = int32(wd)
+	return nil
+}
+
+// forget stops watching dir and everything below it
+func (w *watcher) forget(dir string) {
+	prefix := dir + string(filepath.Separator)
+	for path, wd := range w.wds {
+		if path == dir || strings.HasPrefix(path, prefix) {
+			syscall.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.wds, path)
+			delete(w.dirs, wd)
+		}
+	}
+	for path := range w.unwatched {
+		if path == dir || strings.HasPrefix(path, prefix) {
+			delete(w.unwatched, path)
+		}
+	}
+}
+
+// remember notes what a scan saw of a file in an unwatched directory
+func (w *watcher) remember(path string, entry os.DirEntry) {
+	if fi, err := entry.Info(); err == nil {
+		w.seen[path] = fileState{size: fi.Size(), modTime: fi.ModTime()}
+	}
+}
+
+// rescanUnwatched looks for new and changed files in the directories that
+// could not be watched, and tries again to watch them
+func (w *watcher) rescanUnwatched(now time.Time) {
+	for dir := range w.unwatched {
+		if err := w.add(dir); err != nil {
+			w.opts.warn(err)
+			w.forget(dir)
+			continue
+		}
+		subdirs, err := scanDir(dir, w.opts, func(path string, entry os.DirEntry) {
+			fi, err := entry.Info()
+			if err != nil {
+				return
+			}
+			state := fileState{size: fi.Size(), modTime: fi.ModTime()}
+			if w.seen[path] != state {
+				w.pending.touch(path, now)
+			}
+			if w.unwatched[dir] {
+				w.seen[path] = state
+			} else {
+				delete(w.seen, path)
+			}
+		})
+		if err != nil {
+			w.opts.warn(err)
+			w.forget(dir)
+			continue
+		}
+		if !w.opts.Recursive {
+			continue
+		}
+		// Subdirectories created since are new to the watch
+		for _, sub := range subdirs {
+			if _, ok := w.wds[sub]; !ok && !w.unwatched[sub] {
+				if err := w.addTree(sub, now); err != nil {
+					w.opts.warn(err)
+				}
+			}
+		}
+	}
+}
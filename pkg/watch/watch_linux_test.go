@@ -0,0 +1,194 @@
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// collector gathers what Watch reports
+type collector struct {
+	mu    sync.Mutex
+	found []string
+}
+
+func (c *collector) add(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.found = append(c.found, path)
+}
+
+func (c *collector) list() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.found...)
+}
+
+// waitFor waits until c has reported n files
+func (c *collector) waitFor(t *testing.T, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if found := c.list(); len(found) >= n {
+			return found
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("reported %v, want %d files", c.list(), n)
+	return nil
+}
+
+// startWatch runs Watch on root until the test ends
+func startWatch(t *testing.T, root string, opts Options) *collector {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &collector{}
+	ready := make(chan struct{})
+	opts.Ready = func() { close(ready) }
+	done := make(chan error, 1)
+	go func() { done <- Watch(ctx, root, opts, c.add) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Watch() error = %v", err)
+		}
+	})
+	select {
+	case <-ready:
+	case err := <-done:
+		// Reported here rather than by the cleanup
+		done <- nil
+		t.Fatalf("Watch() error = %v", err)
+	}
+	return c
+}
+
+func TestWatchNewFiles(t *testing.T) {
+	root := t.TempDir()
+	existing := filepath.Join(root, "existing.png")
+	os.WriteFile(existing, []byte("x"), 0644)
+
+	c := startWatch(t, root, Options{
+		Quiet: 100 * time.Millisecond,
+		Match: func(name string) bool { return strings.HasSuffix(name, ".png") },
+	})
+	if found := c.waitFor(t, 1); found[0] != existing {
+		t.Fatalf("reported %v, want the existing file first", found)
+	}
+
+	// A file written in pieces is reported once, after the last piece
+	growing := filepath.Join(root, "growing.png")
+	f, err := os.Create(growing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		f.Write([]byte("chunk"))
+		time.Sleep(40 * time.Millisecond)
+		if len(c.list()) > 1 {
+			t.Fatalf("reported %v while it was still being written", c.list())
+		}
+	}
+	f.Close()
+
+	// Files moved in count, files that do not match do not
+	outside := filepath.Join(t.TempDir(), "moved.png")
+	os.WriteFile(outside, []byte("m"), 0644)
+	os.WriteFile(filepath.Join(root, "notes.txt"), []byte("t"), 0644)
+	if err := os.Rename(outside, filepath.Join(root, "moved.png")); err != nil {
+		t.Fatal(err)
+	}
+
+	c.waitFor(t, 3)
+	time.Sleep(300 * time.Millisecond)
+	found := c.list()
+	want := map[string]bool{existing: true, growing: true, filepath.Join(root, "moved.png"): true}
+	if len(found) != len(want) {
+		t.Fatalf("reported %v, want each of %v once", found, want)
+	}
+	for _, path := range found {
+		if !want[path] {
+			t.Errorf("reported %s", path)
+		}
+	}
+}
+
+func TestWatchRecursive(t *testing.T) {
+	root := t.TempDir()
+	os.Mkdir(filepath.Join(root, "skipped"), 0755)
+	c := startWatch(t, root, Options{
+		Recursive: true,
+		Quiet:     50 * time.Millisecond,
+		SkipDir:   func(dir string) bool { return filepath.Base(dir) == "skipped" },
+	})
+
+	// A directory moved in with files already inside, and a new directory
+	// tree written to afterwards
+	moved := filepath.Join(t.TempDir(), "moved")
+	os.Mkdir(moved, 0755)
+	os.WriteFile(filepath.Join(moved, "a.png"), []byte("a"), 0644)
+	if err := os.Rename(moved, filepath.Join(root, "moved")); err != nil {
+		t.Fatal(err)
+	}
+	deep := filepath.Join(root, "new", "deep")
+	if err := os.MkdirAll(deep, 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(deep, "b.png"), []byte("b"), 0644)
+	os.WriteFile(filepath.Join(root, "skipped", "c.png"), []byte("c"), 0644)
+
+	c.waitFor(t, 2)
+	time.Sleep(200 * time.Millisecond)
+	found := c.list()
+	want := map[string]bool{filepath.Join(root, "moved", "a.png"): true, filepath.Join(deep, "b.png"): true}
+	if len(found) != len(want) || !want[found[0]] || !want[found[1]] {
+		t.Errorf("reported %v, want %v", found, want)
+	}
+}
+
+func TestWatchOutOfWatches(t *testing.T) {
+	root := t.TempDir()
+	limited := filepath.Join(root, "limited")
+	os.Mkdir(limited, 0755)
+	addWatch = func(fd int, path string, mask uint32) (int, error) {
+		if path == limited {
+			return -1, syscall.ENOSPC
+		}
+		return syscall.InotifyAddWatch(fd, path, mask)
+	}
+	// Cleanups run last first, so this one runs once the watch has stopped
+	t.Cleanup(func() { addWatch = syscall.InotifyAddWatch })
+
+	var warnings []error
+	var mu sync.Mutex
+	c := startWatch(t, root, Options{
+		Recursive: true,
+		Quiet:     20 * time.Millisecond,
+		Rescan:    50 * time.Millisecond,
+		Warn: func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			warnings = append(warnings, err)
+		},
+	})
+
+	// Files in the directory without a watch are found by rescanning it,
+	// once each
+	path := filepath.Join(limited, "a.png")
+	os.WriteFile(path, []byte("a"), 0644)
+	c.waitFor(t, 1)
+	time.Sleep(200 * time.Millisecond)
+	if found := c.list(); len(found) != 1 || found[0] != path {
+		t.Errorf("reported %v, want %s once", found, path)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(warnings) != 1 || !strings.Contains(warnings[0].Error(), "max_user_watches") {
+		t.Errorf("warnings = %v, want one about the watch limit", warnings)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package watch
+
+import "context"
+
+// Watch needs inotify, which only Linux has
+func Watch(ctx context.Context, root string, opts Options, found func(path string)) error {
+	return ErrUnsupported
+}
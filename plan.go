@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/screenshot-sorter/pkg/core"
+)
+
+// runPlan implements "screenshot-sorter plan [-out file] [flags]". It
+// accepts the same flags as a normal run and writes the plan as JSON to
+// -out, or to standard output.
+func runPlan(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("plan", flag.ExitOnError)
+	out := flags.String("out", "", "File to write the plan to (default: standard output)")
+	config, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	processor := core.NewImageProcessor(config)
+	// Files that could not be planned are reported once the rest of the
+	// plan is written
+	plan, err := processor.PlanDirectory(ctx, config.SourceDir, config.TargetDir)
+	if plan == nil {
+		return err
+	}
+
+	if *out == "" {
+		if werr := plan.Write(os.Stdout); werr != nil {
+			return werr
+		}
+		return err
+	}
+	if werr := plan.WriteFile(*out); werr != nil {
+		return fmt.Errorf("failed to write plan: %w", werr)
+	}
+	counts := make(map[core.Action]int)
+	for _, op := range plan.Operations {
+		counts[op.Action]++
+	}
+	fmt.Printf("Wrote plan for %d files to %s (%d to %s, %d to skip, %d duplicates to remove)\n",
+		len(plan.Operations), *out, counts[core.Action(plan.Mode)], plan.Mode, counts[core.ActionSkip], counts[core.ActionRemove])
+	return err
+}
+
+// runApply implements "screenshot-sorter apply [flags] plan.json"
+func runApply(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("apply", flag.ExitOnError)
+	onDrift := flags.String("on-drift", "stop", "What to do when files changed since the plan was made: stop (apply nothing) or skip (apply the rest)")
+	dryRun := flags.Bool("dry-run", false, "Check the plan for drift without making changes")
+	verbose := flags.Bool("verbose", false, "Show detailed processing information")
+	noJournal := flags.Bool("no-journal", false, "Do not record this run in the undo journal")
+	limits := &core.Config{}
+	throttleFlags(flags, limits)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s apply [flags] plan.json\n", filepath.Base(os.Args[0]))
+		flags.PrintDefaults()
+	}
+	if _, err := loadSettings(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("expected exactly one plan file")
+	}
+
+	policy, err := core.ParseDriftPolicy(*onDrift)
+	if err != nil {
+		return err
+	}
+	plan, err := core.ReadPlan(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	release, err := lockAll(plan.Roots(), *dryRun)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	processor := core.NewImageProcessor(&core.Config{
+		DryRun:     *dryRun,
+		Verbose:    *verbose,
+		NoJournal:  *noJournal,
+		SourceDir:  plan.SourceDir,
+		TargetDir:  plan.TargetDir,
+		Mode:       plan.Mode,
+		OnConflict: plan.OnConflict,
+		Layout:     plan.Layout,
+		Rate:       limits.Rate,
+		Burst:      limits.Burst,
+		Bandwidth:  limits.Bandwidth,
+		Adaptive:   limits.Adaptive,
+	})
+	result, err := processor.Apply(ctx, plan, policy)
+	if result == nil {
+		return err
+	}
+
+	for _, d := range result.Drifted {
+		fmt.Printf("Drift: %s: %s\n", d.Operation.Source, d.Reason)
+	}
+	fmt.Printf("Applied %d operations, %d skipped by the plan, %d drifted, %d failed\n",
+		result.Applied, result.Skipped, len(result.Drifted), len(result.Failed))
+	if runID := processor.RunID(); runID != "" {
+		fmt.Printf("To undo this run: %s undo -target %q %s\n", filepath.Base(os.Args[0]), plan.TargetDir, runID)
+	}
+	if errors.Is(err, core.ErrDrift) || errors.Is(err, core.ErrInterrupted) {
+		return err
+	}
+
+	// Skipped drift leaves the run incomplete, like a failed file
+	failures := result.Failed
+	for _, d := range result.Drifted {
+		failures = append(failures, &core.ProcessError{
+			Path: d.Operation.Source, Op: string(d.Operation.Action), Dest: d.Operation.Dest,
+			Err: fmt.Errorf("%w: %s", core.ErrDrift, d.Reason),
+		})
+	}
+	if len(failures) > 0 {
+		return &core.RunError{Errors: failures}
+	}
+	return err
+}
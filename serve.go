@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/screenshot-sorter/pkg/sdnotify"
+)
+
+// runServe implements "screenshot-sorter serve [flags]", watch mode for
+// systemd and other service managers. It never prompts, reports its state
+// over the sd_notify socket and pings the systemd watchdog.
+func runServe(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	pidFile := flags.String("pidfile", "", "Also write the process ID to this file, e.g. for PIDFile= (default: none)")
+	config, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	release, err := lockTargets(config)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if *pidFile != "" {
+		if err := os.WriteFile(*pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+			return fmt.Errorf("failed to write pidfile: %w", err)
+		}
+		defer os.Remove(*pidFile)
+	}
+
+	// The watchdog is pinged for as long as the watch runs
+	watchCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	if interval := sdnotify.WatchdogInterval(); interval > 0 {
+		go keepAlive(watchCtx, interval/2)
+	}
+
+	sorted := 0
+	status := func() string {
+		return fmt.Sprintf("Watching %s, %d files sorted", config.SourceDir, sorted)
+	}
+	log.Printf("Watching %s for new screenshots", config.SourceDir)
+	err = watchSource(watchCtx, config, watchHooks{
+		ready: func() {
+			notify(sdnotify.Ready, sdnotify.Status(status()))
+		},
+		sorted: func(string, string) {
+			sorted++
+			notify(sdnotify.Status(status()))
+		},
+	})
+	notify(sdnotify.Stopping)
+	return err
+}
+
+// keepAlive pings the systemd watchdog every interval until ctx is done
+func keepAlive(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			notify(sdnotify.Watchdog)
+		}
+	}
+}
+
+// notify passes states on to the service manager, if there is one
+func notify(states ...string) {
+	if _, err := sdnotify.Notify(states...); err != nil {
+		log.Print("Warning: ", err)
+	}
+}
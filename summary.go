@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/screenshot-sorter/pkg/core"
+)
+
+// transferredVerbs names what happened to transferred files in each mode
+var transferredVerbs = map[string]string{
+	"move":     "Moved",
+	"copy":     "Copied",
+	"hardlink": "Hardlinked",
+	"reflink":  "Reflinked",
+}
+
+// writeSummary prints the end-of-run summary in the given output format
+func writeSummary(w io.Writer, s *core.Summary, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	}
+
+	verb := transferredVerbs[s.Mode]
+	if s.DryRun {
+		verb = "Would be " + strings.ToLower(verb)
+	}
+	fmt.Fprintf(w, "\nScanned %d files in %s\n", s.Scanned, s.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "  %s: %d (%s)\n", verb, s.Transferred, formatBytes(s.Bytes))
+	if s.Removed > 0 {
+		fmt.Fprintf(w, "  Duplicates removed: %d\n", s.Removed)
+	}
+	if s.Resumed > 0 {
+		fmt.Fprintf(w, "  Finished by an earlier run: %d\n", s.Resumed)
+	}
+	if s.Excluded > 0 {
+		fmt.Fprintf(w, "  Excluded: %d\n", s.Excluded)
+	}
+	if len(s.Skipped) > 0 {
+		reasons := make([]string, 0, len(s.Skipped))
+		total := 0
+		for reason, n := range s.Skipped {
+			reasons = append(reasons, fmt.Sprintf("%s: %d", reason, n))
+			total += n
+		}
+		sort.Strings(reasons)
+		fmt.Fprintf(w, "  Skipped: %d (%s)\n", total, strings.Join(reasons, ", "))
+	}
+	if s.Photos > 0 {
+		fmt.Fprintf(w, "  Camera photos: %d\n", s.Photos)
+	}
+	if s.Mismatched > 0 {
+		fmt.Fprintf(w, "  Extension mismatches: %d\n", s.Mismatched)
+	}
+	if s.Conflicts > 0 {
+		fmt.Fprintf(w, "  Conflicts resolved: %d\n", s.Conflicts)
+	}
+	if len(s.Errors) > 0 {
+		fmt.Fprintf(w, "  Errors: %d\n", len(s.Errors))
+	}
+
+	buckets := make([]string, 0, len(s.Buckets))
+	width := 0
+	for name := range s.Buckets {
+		buckets = append(buckets, name)
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+	sort.Strings(buckets)
+	if len(buckets) > 0 {
+		fmt.Fprintln(w, "  Buckets:")
+	}
+	for _, name := range buckets {
+		b := s.Buckets[name]
+		files := "files"
+		if b.Files == 1 {
+			files = "file"
+		}
+		fmt.Fprintf(w, "    %-*s  %d %s, %s, %s to %s\n", width, name, b.Files, files, formatBytes(b.Bytes),
+			b.First.Format("2006-01-02"), b.Last.Format("2006-01-02"))
+	}
+	return nil
+}
+
+// formatBytes renders a size with a binary unit, e.g. "1.5 MiB"
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/screenshot-sorter/pkg/core"
+	"github.com/screenshot-sorter/pkg/journal"
+)
+
+// runUndo implements "screenshot-sorter undo [flags] [run-id]". Without a
+// run ID it undoes the most recent run that has not been undone yet.
+func runUndo(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("undo", flag.ExitOnError)
+	targetDir := flags.String("target", executableDir(), "Target directory of the run to undo")
+	dryRun := flags.Bool("dry-run", false, "Show what would be restored without making changes")
+	verbose := flags.Bool("verbose", false, "Show every restored file")
+	list := flags.Bool("list", false, "List the runs that can be undone")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s undo [flags] [run-id]\n", filepath.Base(os.Args[0]))
+		flags.PrintDefaults()
+	}
+	if _, err := loadSettings(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return fmt.Errorf("expected at most one run ID, got %d", flags.NArg())
+	}
+
+	runs, err := journal.List(*targetDir)
+	if err != nil {
+		return fmt.Errorf("failed to read journals: %w", err)
+	}
+	if *list {
+		for _, run := range runs {
+			fmt.Println(run)
+		}
+		return nil
+	}
+
+	runID := flags.Arg(0)
+	if runID == "" {
+		if len(runs) == 0 {
+			return fmt.Errorf("no runs to undo in %s", *targetDir)
+		}
+		runID = runs[len(runs)-1]
+	}
+
+	release, err := lockTarget(*targetDir, *dryRun)
+	if err != nil {
+		return err
+	}
+	defer release()
+	result, err := journal.Undo(ctx, *targetDir, runID, *dryRun)
+	if result == nil {
+		return err
+	}
+	if *verbose {
+		for _, e := range result.Restored {
+			fmt.Printf("Restored %s (from %s)\n", e.Source, e.Dest)
+		}
+	}
+	for _, w := range result.Warnings {
+		fmt.Printf("Warning: %s\n", w)
+	}
+	fmt.Printf("Run %s: restored %d files, %d could not be restored\n", runID, len(result.Restored), len(result.Failed))
+	if err != nil {
+		return err
+	}
+	var failures []*core.ProcessError
+	for _, f := range result.Failed {
+		failures = append(failures, &core.ProcessError{Path: f.Entry.Dest, Op: core.OpUndo, Dest: f.Entry.Source, Err: f.Err})
+	}
+	if len(failures) > 0 {
+		return &core.RunError{Errors: failures}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/screenshot-sorter/pkg/core"
+	"github.com/screenshot-sorter/pkg/watch"
+)
+
+// runWatch implements "screenshot-sorter watch [flags]". It accepts the
+// same flags as a normal run and sorts each screenshot in the source as
+// soon as it is complete, until interrupted.
+func runWatch(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	config, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	release, err := lockTargets(config)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	fmt.Printf("Watching %s for new screenshots, press Ctrl-C to stop\n", config.SourceDir)
+	return watchSource(ctx, config, watchHooks{})
+}
+
+// watchHooks lets the caller of watchSource follow the session
+type watchHooks struct {
+	// ready, if set, is called once the source is being watched
+	ready func()
+	// sorted, if set, is called after each file that was sorted
+	sorted func(source, dest string)
+}
+
+// watchSource sorts each screenshot in the source of config as soon as it
+// is complete, until ctx is canceled
+func watchSource(ctx context.Context, config *core.Config, hooks watchHooks) error {
+	processor := core.NewImageProcessor(config)
+	sourceDir, targetDir := config.SourceDir, config.TargetDir
+	// placed holds the destinations inside the watched tree, so files
+	// arriving there are not sorted a second time
+	placed := make(map[string]bool)
+
+	opts := watch.Options{
+		Recursive: config.Recursive,
+		// Leave out what a recursive run would leave alone, including the
+		// sorter's own state
+		SkipDir: func(dir string) bool {
+			_, ok := processor.TargetDirFor(sourceDir, targetDir, dir)
+			return !ok
+		},
+		Match: func(name string) bool {
+			return processor.Considers(name)
+		},
+		Warn: func(err error) {
+			log.Print("Warning: ", err)
+		},
+		Ready: hooks.ready,
+	}
+	err := watch.Watch(ctx, sourceDir, opts, func(path string) {
+		if placed[absPath(path)] {
+			delete(placed, absPath(path))
+			return
+		}
+		dir := filepath.Dir(path)
+		target, ok := processor.FileTargetDir(sourceDir, targetDir, path)
+		if !ok {
+			return
+		}
+		info, err := os.Lstat(path)
+		if err != nil {
+			return
+		}
+		result, err := processor.ProcessFile(ctx, dir, target, fs.FileInfoToDirEntry(info))
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Print(err)
+			}
+			return
+		}
+		if !result.Done {
+			return
+		}
+		if _, ok := processor.TargetDirFor(sourceDir, targetDir, filepath.Dir(result.Dest)); ok {
+			placed[absPath(result.Dest)] = true
+		}
+		if !config.Verbose {
+			fmt.Printf("%s -> %s\n", path, result.Dest)
+		}
+		if hooks.sorted != nil {
+			hooks.sorted(path, result.Dest)
+		}
+	})
+
+	if runID := processor.RunID(); runID != "" {
+		fmt.Printf("To undo this session: %s undo -target %q %s\n", filepath.Base(os.Args[0]), targetDir, runID)
+	}
+	return err
+}
+
+// absPath makes paths from the watcher and from the processor comparable
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}